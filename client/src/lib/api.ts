@@ -10,7 +10,44 @@ function getAuthHeaders(): Record<string, string> {
   return headers;
 }
 
-async function handleResponse(response: Response, method: string, path: string): Promise<Response> {
+// Access tokens live 15 minutes, so a 401 first tries to refresh the session.
+// Concurrent requests share one refresh: a refresh token can be used only once.
+let refreshing: Promise<boolean> | null = null;
+
+function refreshSession(): Promise<boolean> {
+  if (!refreshing) {
+    refreshing = (async () => {
+      if (!auth.refreshToken) return false;
+      const response = await fetch(`${API_URL}/token/refresh`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: auth.refreshToken }),
+      });
+      if (!response.ok) return false;
+      const data = await response.json();
+      auth.login(data.token, data.refresh_token);
+      return true;
+    })()
+      .catch(() => false)
+      .finally(() => {
+        refreshing = null;
+      });
+  }
+  return refreshing;
+}
+
+async function doRequest(method: string, path: string, data?: unknown): Promise<Response> {
+  const send = () =>
+    fetch(`${API_URL}${path}`, {
+      method,
+      headers: getAuthHeaders(),
+      body: data === undefined ? undefined : JSON.stringify(data),
+    });
+
+  let response = await send();
+  if (response.status === 401 && (await refreshSession())) {
+    response = await send();
+  }
   if (response.status === 401) {
     auth.logout();
     throw new Error('Session expired. Please log in again.');
@@ -23,44 +60,30 @@ async function handleResponse(response: Response, method: string, path: string):
 }
 
 async function doGet(path: string) {
-  const response = await fetch(`${API_URL}${path}`, {
-    method: 'GET',
-    headers: getAuthHeaders(),
-  });
-  return (await handleResponse(response, 'GET', path)).json();
+  return (await doRequest('GET', path)).json();
 }
 
 async function doPost(path: string, data?: unknown) {
-  const response = await fetch(`${API_URL}${path}`, {
-    method: 'POST',
-    headers: getAuthHeaders(),
-    body: JSON.stringify(data),
-  });
-  return (await handleResponse(response, 'POST', path)).json();
+  return (await doRequest('POST', path, data)).json();
 }
 
 async function doDelete(path: string, data?: unknown) {
-  const response = await fetch(`${API_URL}${path}`, {
-    method: 'DELETE',
-    headers: getAuthHeaders(),
-    body: JSON.stringify(data),
-  });
-  const handled = await handleResponse(response, 'DELETE', path);
-  if (handled.status === 204) return true;
-  return handled.json();
+  const response = await doRequest('DELETE', path, data);
+  if (response.status === 204) return true;
+  return response.json();
 }
 
 async function doPut(path: string, data?: unknown) {
-  const response = await fetch(`${API_URL}${path}`, {
-    method: 'PUT',
-    headers: getAuthHeaders(),
-    body: JSON.stringify(data),
-  });
-  return (await handleResponse(response, 'PUT', path)).json();
+  return (await doRequest('PUT', path, data)).json();
+}
+
+export interface Session {
+  token: string;
+  refresh_token: string;
 }
 
 // Auth API (no token needed)
-export async function apiLogin(login: string, password: string): Promise<string> {
+export async function apiLogin(login: string, password: string): Promise<Session> {
   const response = await fetch(`${API_URL}/login`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
@@ -68,18 +91,29 @@ export async function apiLogin(login: string, password: string): Promise<string>
   });
   if (!response.ok) throw new Error('Login failed');
   const data = await response.json();
-  return data.token;
+  if (data.two_factor_required) throw new Error('Two-factor authentication is not supported in this app yet');
+  return data;
 }
 
-export async function apiRegister(login: string, password: string): Promise<string> {
+export async function apiRegister(login: string, password: string): Promise<Session> {
   const response = await fetch(`${API_URL}/register`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify({ login, password }),
   });
   if (!response.ok) throw new Error('Registration failed');
-  const data = await response.json();
-  return data.token;
+  return response.json();
+}
+
+// Revokes the session on the server; the local session is dropped even if the request fails
+export async function apiLogout() {
+  try {
+    await fetch(`${API_URL}/logout`, { method: 'POST', headers: getAuthHeaders() });
+  } catch {
+    // The session expires on the server by itself
+  } finally {
+    auth.logout();
+  }
 }
 
 // Group API
@@ -100,4 +134,7 @@ export const fetchPurchases = () => doGet('/purchases');
 export const createPurchase = (purchase: unknown) => doPost('/purchases', purchase);
 export const deletePurchase = (purchaseId: string) => doDelete('/purchases', { id: purchaseId });
 
+// Receipt API
+export const createReceipt = (receipt: unknown) => doPost('/receipts', receipt);
+
 export default API_URL;
\ No newline at end of file
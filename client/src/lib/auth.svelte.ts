@@ -1,20 +1,29 @@
 const TOKEN_KEY = 'token';
+const REFRESH_TOKEN_KEY = 'refresh_token';
 
 let token = $state<string | null>(localStorage.getItem(TOKEN_KEY));
+let refreshToken = $state<string | null>(localStorage.getItem(REFRESH_TOKEN_KEY));
 
 export const auth = {
   get token() {
     return token;
   },
+  get refreshToken() {
+    return refreshToken;
+  },
   get isAuthenticated() {
     return token !== null;
   },
-  login(newToken: string) {
+  login(newToken: string, newRefreshToken: string) {
     token = newToken;
+    refreshToken = newRefreshToken;
     localStorage.setItem(TOKEN_KEY, newToken);
+    localStorage.setItem(REFRESH_TOKEN_KEY, newRefreshToken);
   },
   logout() {
     token = null;
+    refreshToken = null;
     localStorage.removeItem(TOKEN_KEY);
+    localStorage.removeItem(REFRESH_TOKEN_KEY);
   },
-};
\ No newline at end of file
+};
@@ -1,4 +1,4 @@
-import { fetchPurchases, createPurchase, deletePurchase } from '../lib/api';
+import { fetchPurchases, createPurchase, createReceipt, deletePurchase } from '../lib/api';
 import type { Purchase } from '../models/Purchase';
 
 let items = $state<Purchase[]>([]);
@@ -28,6 +28,18 @@ export const purchaseStore = {
     return created;
   },
 
+  /** Creates a receipt with all its purchases in one request. */
+  async createReceipt(receipt: {
+    date: string;
+    store: string;
+    common_tags: string[];
+    purchases: Pick<Purchase, 'product_id' | 'price' | 'quantity' | 'tags'>[];
+  }) {
+    const data = (await createReceipt(receipt)) as { purchases: Purchase[] };
+    items = [...items, ...(data.purchases ?? [])];
+    return data.purchases;
+  },
+
   async delete(id: string) {
     await deletePurchase(id);
     items = items.filter((p) => p.id !== id);
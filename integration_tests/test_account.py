@@ -0,0 +1,190 @@
+import csv
+import io
+import json
+import zipfile
+
+from utils.factories import create_product, create_purchase
+
+
+def create_product_and_purchase(req, user, name='Milk'):
+    product_id = create_product(req, user, name=name)
+    purchase_id = create_purchase(req, user, product_id, quantity=2, price=8990,
+                                  date='2024-01-15T00:00:00Z', tags=['dairy'])
+    return product_id, purchase_id
+
+
+def create_group(req, user1, user2):
+    req.post('invite', json={'login': user2.login}, user=user1)
+    assert req.post('invite', json={'login': user1.login}, user=user2).status_code == 200
+
+
+def user_id(req, user):
+    return req.get('group', user=user).json()['current_user_id']
+
+
+# Архив содержит все данные пользователя и не содержит хеш пароля
+def test_export_account(req):
+    user = req.get_new_user()
+    other = req.get_new_user()
+    product_id, purchase_id = create_product_and_purchase(req, user)
+    req.post('invite', json={'login': other.login}, user=user)
+
+    r = req.get('account/export', user=user)
+    assert r.status_code == 200
+    assert r.headers['Content-Type'] == 'application/zip'
+    archive = zipfile.ZipFile(io.BytesIO(r.content))
+    assert sorted(archive.namelist()) == sorted([
+        'account.json', 'products.json', 'purchases.json', 'receipts.json',
+        'invites.json', 'invite_links.json', 'purchases.csv',
+    ])
+
+    account = json.loads(archive.read('account.json'))
+    assert account['login'] == user.login
+    assert account['group'] == []
+    assert 'password' not in account
+
+    assert [p['id'] for p in json.loads(archive.read('products.json'))] == [product_id]
+    purchases = json.loads(archive.read('purchases.json'))
+    assert [p['id'] for p in purchases] == [purchase_id]
+    assert purchases[0]['product_name'] == 'Milk'
+    invites = json.loads(archive.read('invites.json'))
+    assert [i['to_login'] for i in invites['outgoing']] == [other.login]
+    assert invites['incoming'] == []
+
+    rows = list(csv.DictReader(io.StringIO(archive.read('purchases.csv').decode())))
+    assert [(row['name'], row['price']) for row in rows] == [('Milk', '89.90')]
+
+
+# Удаление аккаунта требует пароль, после удаления токен и логин перестают работать
+def test_delete_account(req, db):
+    user = req.get_new_user()
+    other = req.get_new_user()
+    create_product_and_purchase(req, user)
+    req.post('invite', json={'login': other.login}, user=user)
+    req.post('invite/links', json={}, user=user)
+    uid = user_id(req, user)
+
+    r = req.delete('account', json={'password': 'wrong'}, user=user)
+    assert r.status_code == 403
+    r = req.delete('account', json={'password': user.password, 'purchases': 'keep'}, user=user)
+    assert r.status_code == 400
+
+    r = req.delete('account', json={'password': user.password}, user=user)
+    assert r.status_code == 200
+
+    assert req.get('products', user=user).status_code == 401
+    r = req.post('login', json={'login': user.login, 'password': user.password})
+    assert r.status_code == 401
+    assert req.get('invite', user=other).json()['invites'] == []
+
+    for table, column in [('users', 'id'), ('products', 'user_id'), ('purchases', 'user_id'),
+                          ('invites', 'from_user_id'), ('invite_links', 'user_id')]:
+        assert db.execute(f'SELECT 1 FROM {table} WHERE {column} = %s', (uid,)) == [], table
+
+    # Логин снова свободен
+    r = req.post('register', json={'login': user.login, 'password': 'new_password'})
+    assert r.status_code == 200
+
+
+# Удаленный участник выходит из группы, а его продукты, которые использует группа, переходят к ней
+def test_delete_account_in_group(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+    create_group(req, user1, user2)
+    shared_id, _ = create_product_and_purchase(req, user1, name='Shared')
+    own_id, _ = create_product_and_purchase(req, user1, name='Own')
+    purchase = {
+        'product_id': shared_id,
+        'quantity': 1,
+        'price': 9000,
+        'date': '2024-02-01T00:00:00Z',
+        'store': 'Store',
+    }
+    assert req.post('purchases', json=purchase, user=user2).status_code == 200
+
+    r = req.delete('account', json={'password': user1.password}, user=user1)
+    assert r.status_code == 200
+
+    assert req.get('group', user=user2).json()['members'] == []
+    products = req.get('products', user=user2).json()['products']
+    assert [p['id'] for p in products] == [shared_id]
+    assert products[0]['user_id'] == user_id(req, user2)
+    purchases = req.get('purchases', user=user2).json()['purchases']
+    assert [p['price'] for p in purchases] == [9000]
+    assert req.put('products', json={**products[0], 'name': 'Renamed'}, user=user2).status_code == 200
+    assert own_id not in [p['id'] for p in req.get('products', user=user2).json()['products']]
+
+
+# Обезличенные покупки остаются в БД без пользователя, чека и тегов
+def test_delete_account_anonymize(req, db):
+    user = req.get_new_user()
+    product_id, purchase_id = create_product_and_purchase(req, user)
+
+    r = req.delete('account', json={'password': user.password, 'purchases': 'anonymize'}, user=user)
+    assert r.status_code == 200
+
+    rows = db.execute('SELECT user_id, receipt_id, tags, price FROM purchases WHERE id = %s', (purchase_id,))
+    assert rows == [{'user_id': None, 'receipt_id': None, 'tags': [], 'price': 8990}]
+    rows = db.execute('SELECT user_id, name FROM products WHERE id = %s', (product_id,))
+    assert rows == [{'user_id': None, 'name': 'Milk'}]
+
+
+# Смена пароля требует текущий пароль, после смены работает только новый
+def test_change_password(req):
+    user = req.get_new_user()
+
+    r = req.put('account/password', json={'current_password': 'wrong', 'new_password': 'new_password'}, user=user)
+    assert r.status_code == 403
+    r = req.put('account/password', json={'current_password': user.password, 'new_password': ''}, user=user)
+    assert r.status_code == 400
+
+    other = req.post('login', json={'login': user.login, 'password': user.password}).json()
+
+    r = req.put('account/password', json={'current_password': user.password, 'new_password': 'new_password'}, user=user)
+    assert r.status_code == 200
+
+    # Другие сессии отзываются вместе с их токенами обновления
+    r = req.post('token/refresh', json={'refresh_token': other['refresh_token']})
+    assert r.status_code == 401
+    assert len(req.get('sessions', user=user).json()['sessions']) == 1
+
+    r = req.post('login', json={'login': user.login, 'password': user.password})
+    assert r.status_code == 401
+    r = req.post('login', json={'login': user.login, 'password': 'new_password'})
+    assert r.status_code == 200
+    assert req.get('products', user=user).status_code == 200
+
+
+# Новый логин должен быть свободен и сразу виден в группе и инвайтах
+def test_change_login(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+    user3 = req.get_new_user()
+    create_group(req, user1, user2)
+    req.post('invite', json={'login': user3.login}, user=user1)
+
+    r = req.put('account/login', json={'login': user2.login}, user=user1)
+    assert r.status_code == 409
+    for login in ['', ' padded', 'x' * 51]:
+        r = req.put('account/login', json={'login': login}, user=user1)
+        assert r.status_code == 400, login
+
+    new_login = user1.login + '_renamed'
+    r = req.put('account/login', json={'login': new_login}, user=user1)
+    assert r.status_code == 200
+    assert r.json()['login'] == new_login
+
+    members = req.get('group', user=user2).json()['members']
+    assert sorted(m['login'] for m in members) == sorted([new_login, user2.login])
+    invites = req.get('invite', user=user3).json()['invites']
+    assert [i['from_login'] for i in invites] == [new_login]
+    r = req.get('invite/outgoing', user=user1)
+    assert [i['from_login'] for i in r.json()['invites']] == [new_login]
+
+    r = req.post('login', json={'login': new_login, 'password': user1.password})
+    assert r.status_code == 200
+    r = req.post('login', json={'login': user1.login, 'password': user1.password})
+    assert r.status_code == 401
+
+    # Старый логин освобождается, и на него можно пригласить другого пользователя
+    assert req.post('invite', json={'login': user1.login}, user=user3).status_code == 404
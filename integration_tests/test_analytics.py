@@ -0,0 +1,294 @@
+from utils.factories import create_product, create_purchase
+
+
+def create_purchases(req, user, items):
+    product_id = create_product(req, user, name='Apples', volume='1kg', brand='Farm')
+    for date, price, quantity, store, tags in items:
+        create_purchase(req, user, product_id, date=date, price=price, quantity=quantity, store=store, tags=tags)
+    return product_id
+
+
+# Траты суммируются по месяцам с учетом количества
+def test_spending_by_month(req):
+    user = req.get_new_user()
+    create_purchases(req, user, [
+        ('2024-01-05T00:00:00Z', 100, 2, 'Shop A', []),
+        ('2024-01-20T00:00:00Z', 50, 1, 'Shop B', []),
+        ('2024-02-10T00:00:00Z', 300, 1, 'Shop A', []),
+    ])
+
+    r = req.get('analytics/spending', user=user)
+    assert r.status_code == 200
+    data = r.json()
+    assert data['total'] == 550
+    assert data['count'] == 3
+    assert [b['start'] for b in data['buckets']] == ['2024-01-01T00:00:00Z', '2024-02-01T00:00:00Z']
+    assert [b['total'] for b in data['buckets']] == [250, 300]
+
+
+# Траты группируются по магазину внутри интервала и ограничиваются периодом
+def test_spending_by_store(req):
+    user = req.get_new_user()
+    create_purchases(req, user, [
+        ('2024-01-05T00:00:00Z', 100, 2, 'Shop A', []),
+        ('2024-01-20T00:00:00Z', 50, 1, 'Shop B', []),
+        ('2024-03-10T00:00:00Z', 300, 1, 'Shop A', []),
+    ])
+
+    r = req.get('analytics/spending?dimension=store&bucket=year&date_from=2024-01-01&date_to=2024-01-31', user=user)
+    assert r.status_code == 200
+    data = r.json()
+    assert data['total'] == 250
+    assert len(data['buckets']) == 1
+    groups = data['buckets'][0]['groups']
+    assert [(g['label'], g['total']) for g in groups] == [('Shop A', 200), ('Shop B', 50)]
+
+
+# Покупка с несколькими тегами учитывается в каждом теге
+def test_spending_by_tag(req):
+    user = req.get_new_user()
+    create_purchases(req, user, [
+        ('2024-01-05T00:00:00Z', 100, 1, 'Shop', ['food', 'fruit']),
+        ('2024-01-06T00:00:00Z', 40, 1, 'Shop', []),
+    ])
+
+    r = req.get('analytics/spending?dimension=tag', user=user)
+    assert r.status_code == 200
+    groups = {g['key']: g['total'] for g in r.json()['buckets'][0]['groups']}
+    assert groups == {'food': 100, 'fruit': 100, '': 40}
+
+
+# В группе траты можно разбить по участникам
+def test_spending_by_member(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+    req.post('invite', json={'login': user2.login}, user=user1)
+    r = req.post('invite', json={'login': user1.login}, user=user2)
+    assert r.status_code == 200
+
+    create_purchases(req, user1, [('2024-05-01T00:00:00Z', 100, 1, 'Shop', [])])
+    create_purchases(req, user2, [('2024-05-02T00:00:00Z', 70, 1, 'Shop', [])])
+
+    r = req.get('analytics/spending?dimension=member&bucket=week', user=user1)
+    assert r.status_code == 200
+    data = r.json()
+    assert data['total'] == 170
+    assert len(data['buckets']) == 1
+    assert data['buckets'][0]['start'] == '2024-04-29T00:00:00Z'
+    labels = {g['label']: g['total'] for g in data['buckets'][0]['groups']}
+    assert labels == {user1.login: 100, user2.login: 70}
+
+
+# Невалидные параметры возвращают 400
+def test_spending_invalid_params(req):
+    user = req.get_new_user()
+    for query in ['dimension=color', 'bucket=hour', 'date_from=abc', 'date_from=2024-02-01&date_to=2024-01-01']:
+        r = req.get(f'analytics/spending?{query}', user=user)
+        assert r.status_code == 400, query
+
+
+# Без токена доступ запрещен
+def test_spending_unauthorized(req):
+    r = req.get('analytics/spending')
+    assert r.status_code == 401
+
+
+# История цены продукта: точки по датам, статистика и изменение за окна
+def test_price_history(req):
+    user = req.get_new_user()
+    product_id = create_purchases(req, user, [
+        ('2024-01-01T00:00:00Z', 100, 1, 'Shop A', []),
+        ('2024-02-01T00:00:00Z', 110, 3, 'Shop B', []),
+        ('2024-03-01T00:00:00Z', 125, 1, 'Shop A', []),
+    ])
+
+    r = req.get(f'analytics/prices?product_id={product_id}&windows=29,45,365', user=user)
+    assert r.status_code == 200
+    data = r.json()
+    assert [(p['price'], p['store']) for p in data['points']] == [(100, 'Shop A'), (110, 'Shop B'), (125, 'Shop A')]
+    assert data['min'] == 100
+    assert data['max'] == 125
+    assert data['median'] == 110
+
+    changes = {c['window_days']: c for c in data['changes']}
+    assert changes[29]['from_price'] == 110
+    assert changes[29]['percent'] == 13.64
+    assert changes[45]['from_price'] == 100
+    assert changes[45]['percent'] == 25
+    assert changes[365]['from_price'] is None
+    assert changes[365]['percent'] is None
+
+
+# История цены продукта без покупок пустая
+def test_price_history_empty(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'Pears', 'volume': '1kg', 'brand': 'Farm'}, user=user)
+    product_id = r.json()['id']
+
+    r = req.get(f'analytics/prices?product_id={product_id}', user=user)
+    assert r.status_code == 200
+    data = r.json()
+    assert data['points'] == []
+    assert data['min'] is None
+    assert len(data['changes']) == 4
+
+
+# Историю цены чужого продукта получить нельзя
+def test_price_history_other_user_product(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+    product_id = create_purchases(req, user1, [('2024-01-01T00:00:00Z', 100, 1, 'Shop', [])])
+
+    r = req.get(f'analytics/prices?product_id={product_id}', user=user2)
+    assert r.status_code == 404
+
+
+# Невалидные параметры истории цены возвращают 400
+def test_price_history_invalid_params(req):
+    user = req.get_new_user()
+    product_id = create_purchases(req, user, [('2024-01-01T00:00:00Z', 100, 1, 'Shop', [])])
+    for query in ['', 'product_id=abc', f'product_id={product_id}&windows=0', f'product_id={product_id}&windows=7,x']:
+        r = req.get(f'analytics/prices?{query}', user=user)
+        assert r.status_code == 400, query
+
+
+# В аналитике по продуктам возвращаются купленный объем и цена за единицу
+def test_spending_by_product_unit_price(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'Milk', 'volume': '0,5л', 'brand': 'Farm'}, user=user)
+    product_id = r.json()['id']
+    for price, quantity in [(50, 2), (60, 1)]:
+        purchase = {
+            'product_id': product_id,
+            'quantity': quantity,
+            'price': price,
+            'date': '2024-01-10T00:00:00Z',
+            'store': 'Shop',
+        }
+        assert req.post('purchases', json=purchase, user=user).status_code == 200
+
+    r = req.get('analytics/spending?dimension=product', user=user)
+    assert r.status_code == 200
+    group = r.json()['buckets'][0]['groups'][0]
+    assert group['label'] == 'Milk'
+    assert group['total'] == 160
+    assert group['unit_amount'] == 1.5
+    assert group['unit'] == 'l'
+    assert group['price_per_unit'] == 106.67
+
+    r = req.get(f'analytics/prices?product_id={product_id}', user=user)
+    data = r.json()
+    assert data['unit'] == 'l'
+    assert sorted(p['price_per_unit'] for p in data['points']) == [100, 120]
+
+
+# Аналитика переводит покупки в валюту отчета по курсу на дату покупки
+def test_spending_currency_conversion(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'Souvenir', 'volume': '1pc', 'brand': 'Shop'}, user=user)
+    product_id = r.json()['id']
+    for date, price, currency in [
+        ('2024-01-15T00:00:00Z', 1000, 'USD'),
+        ('2024-06-10T00:00:00Z', 1000, 'EUR'),
+        ('2024-06-11T00:00:00Z', 1000, 'GBP'),
+    ]:
+        purchase = {
+            'product_id': product_id,
+            'quantity': 1,
+            'price': price,
+            'currency': currency,
+            'date': date,
+            'store': 'Shop',
+        }
+        assert req.post('purchases', json=purchase, user=user).status_code == 200
+
+    r = req.get('analytics/spending?bucket=year', user=user)
+    assert r.status_code == 200
+    data = r.json()
+    assert data['currency'] == 'RUB'
+    assert data['total'] == 90000 + 95000
+    assert data['unconverted'] == 1
+
+    r = req.get('analytics/spending?bucket=year&currency=usd', user=user)
+    data = r.json()
+    assert data['currency'] == 'USD'
+    assert data['total'] == 1000 + 1188
+
+    r = req.get('analytics/spending?currency=XXX', user=user)
+    assert r.status_code == 400
+
+
+
+# Валюта отчетов группы - валюта ее первого участника: пользователя, который создал группу, ответив на инвайт.
+# Новые участники ее не меняют, а после ухода первого участника первым становится следующий
+def test_group_reporting_currency(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+    user3 = req.get_new_user()
+
+    r = req.get('currency', user=user1)
+    assert r.status_code == 200
+    assert r.json() == {'currency': 'RUB', 'reporting_currency': 'RUB'}
+
+    r = req.put('currency', json={'currency': 'usd'}, user=user2)
+    assert r.status_code == 200
+    assert r.json()['currency'] == 'USD'
+    assert req.put('currency', json={'currency': 'EUR'}, user=user3).status_code == 200
+
+    req.post('invite', json={'login': user2.login}, user=user1)
+    assert req.post('invite', json={'login': user1.login}, user=user2).status_code == 200
+
+    members = {m['login']: m['member_number'] for m in req.get('group', user=user1).json()['members']}
+    assert members == {user2.login: 1, user1.login: 2}
+    for user in [user1, user2]:
+        assert req.get('currency', user=user).json()['reporting_currency'] == 'USD'
+
+    req.post('invite', json={'login': user3.login}, user=user1)
+    assert req.post('invite', json={'login': user1.login}, user=user3).status_code == 200
+    assert req.get('currency', user=user3).json() == {'currency': 'EUR', 'reporting_currency': 'USD'}
+
+    assert req.delete('group', user=user2).status_code == 200
+    members = {m['login']: m['member_number'] for m in req.get('group', user=user1).json()['members']}
+    assert members == {user1.login: 1, user3.login: 2}
+    assert req.get('currency', user=user3).json()['reporting_currency'] == 'RUB'
+
+    r = req.put('currency', json={'currency': 'ABC'}, user=user1)
+    assert r.status_code == 400
+
+
+# Экономия на скидках считается по магазинам, месяцам и типам акций
+def test_savings_summary(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'Juice', 'volume': '1L', 'brand': 'Garden'}, user=user)
+    product_id = r.json()['id']
+    for date, price, regular_price, quantity, store, promo_type in [
+        ('2024-01-10T00:00:00Z', 120, 150, 2, 'Shop A', 'loyalty_card'),
+        ('2024-01-20T00:00:00Z', 100, 200, 2, 'Shop B', 'multi_buy'),
+        ('2024-02-05T00:00:00Z', 90, 100, 1, 'shop a', 'percentage'),
+        ('2024-02-06T00:00:00Z', 100, None, 1, 'Shop B', None),
+    ]:
+        purchase = {
+            'product_id': product_id,
+            'quantity': quantity,
+            'price': price,
+            'date': date,
+            'store': store,
+        }
+        if regular_price:
+            purchase.update(regular_price=regular_price, promo_type=promo_type)
+        assert req.post('purchases', json=purchase, user=user).status_code == 200
+
+    r = req.get('analytics/savings', user=user)
+    assert r.status_code == 200
+    data = r.json()
+    assert data['total'] == 60 + 200 + 10
+    assert data['count'] == 3
+    assert [(g['key'], g['total']) for g in data['by_store']] == [('shop b', 200), ('shop a', 70)]
+    assert [(g['key'], g['total']) for g in data['by_month']] == [('2024-01', 260), ('2024-02', 10)]
+    assert [g['key'] for g in data['by_promo_type']] == ['multi_buy', 'loyalty_card', 'percentage']
+
+    r = req.get('analytics/savings?date_from=2024-02-01', user=user)
+    assert r.json()['total'] == 10
+
+    r = req.get('analytics/savings?currency=XXX', user=user)
+    assert r.status_code == 400
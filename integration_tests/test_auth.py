@@ -1,3 +1,12 @@
+import base64
+import hashlib
+import hmac
+import json
+import time
+
+from utils.request_manager import bearer
+
+
 def test_register_user(db, req):
     r = req.post('register', json={'login': 'new', 'password': 'password'})
     assert r.status_code == 200, f"Registration failed: {r.status_code} {r.text}"
@@ -52,3 +61,120 @@ def test_method_not_allowed(req):
 
     r = req.get('login')
     assert r.status_code == 405
+
+
+def login_session(req, user):
+    r = req.post('login', json={'login': user.login, 'password': user.password})
+    assert r.status_code == 200
+    return r.json()
+
+
+# Вход выдает короткоживущий access-токен и токен обновления
+def test_login_returns_refresh_token(req):
+    user = req.get_new_user()
+    data = login_session(req, user)
+    assert data['token']
+    assert data['refresh_token']
+    assert data['expires_in'] == 900
+
+
+# Токен обновления одноразовый: при обновлении выдается новая пара токенов
+def test_refresh_token_rotation(req):
+    user = req.get_new_user()
+    session = login_session(req, user)
+
+    r = req.post('token/refresh', json={'refresh_token': session['refresh_token']})
+    assert r.status_code == 200
+    refreshed = r.json()
+    assert refreshed['refresh_token'] != session['refresh_token']
+    assert refreshed['token'] != session['token']
+    assert req.get('products', user=bearer(refreshed['token'])).status_code == 200
+
+    r = req.post('token/refresh', json={'refresh_token': refreshed['refresh_token']})
+    assert r.status_code == 200
+    assert req.get('products', user=bearer(r.json()['token'])).status_code == 200
+
+
+# Повторное использование токена обновления отзывает все семейство вместе с access-токенами
+def test_refresh_token_reuse_revokes_family(req):
+    user = req.get_new_user()
+    session = login_session(req, user)
+    refreshed = req.post('token/refresh', json={'refresh_token': session['refresh_token']}).json()
+
+    r = req.post('token/refresh', json={'refresh_token': session['refresh_token']})
+    assert r.status_code == 401
+
+    r = req.post('token/refresh', json={'refresh_token': refreshed['refresh_token']})
+    assert r.status_code == 401
+    assert req.get('products', user=bearer(refreshed['token'])).status_code == 401
+    assert req.get('products', user=bearer(session['token'])).status_code == 401
+
+    # Другие входы пользователя продолжают работать
+    assert req.get('products', user=user).status_code == 200
+
+
+# Выход отзывает только текущий вход
+def test_logout(req):
+    user = req.get_new_user()
+    session = login_session(req, user)
+    other = login_session(req, user)
+
+    r = req.post('logout', user=bearer(session['token']))
+    assert r.status_code == 200
+
+    assert req.get('products', user=bearer(session['token'])).status_code == 401
+    r = req.post('token/refresh', json={'refresh_token': session['refresh_token']})
+    assert r.status_code == 401
+    assert req.post('logout', user=bearer(session['token'])).status_code == 401
+
+    assert req.get('products', user=bearer(other['token'])).status_code == 200
+    r = req.post('token/refresh', json={'refresh_token': other['refresh_token']})
+    assert r.status_code == 200
+
+
+# Невалидные запросы на обновление токена
+def test_refresh_token_invalid(req):
+    r = req.post('token/refresh', json={'refresh_token': 'unknown'})
+    assert r.status_code == 401
+    r = req.post('token/refresh', json={})
+    assert r.status_code == 400
+    r = req.get('token/refresh')
+    assert r.status_code == 405
+
+
+def b64(data):
+    return base64.urlsafe_b64encode(data).rstrip(b'=').decode()
+
+
+# Токен подписывается секретом режима разработки, с которым запущен сервер в тестах
+def make_token(user_id, header, secret=b'secret', digest=hashlib.sha256):
+    now = int(time.time())
+    payload = {'sub': str(user_id), 'iat': now, 'exp': now + 60}
+    signing_input = f'{b64(json.dumps(header).encode())}.{b64(json.dumps(payload).encode())}'
+    signature = hmac.new(secret, signing_input.encode(), digest).digest() if digest else b''
+    return f'{signing_input}.{b64(signature)}'
+
+
+# Принимаются только алгоритмы ключей сервера и только известные ключи
+def test_token_algorithm_check(req):
+    user = req.get_new_user()
+    user_id = req.get('group', user=user).json()['current_user_id']
+
+    token = make_token(user_id, {'alg': 'HS256', 'typ': 'JWT'})
+    assert req.get('products', user=bearer(token)).status_code == 200
+
+    for token in [
+        make_token(user_id, {'alg': 'none', 'typ': 'JWT'}, digest=None),
+        make_token(user_id, {'alg': 'HS384', 'typ': 'JWT'}, digest=hashlib.sha384),
+        make_token(user_id, {'alg': 'HS256', 'typ': 'JWT', 'kid': 'unknown'}),
+        make_token(user_id, {'alg': 'HS256', 'typ': 'JWT'}, secret=b'wrong'),
+    ]:
+        assert req.get('products', user=bearer(token)).status_code == 401, token
+
+
+# HS256-секреты не публикуются в JWKS
+def test_jwks(req):
+    r = req.get('.well-known/jwks.json')
+    assert r.status_code == 200
+    assert r.json() == {'keys': []}
+    assert req.post('.well-known/jwks.json').status_code == 405
@@ -148,7 +148,6 @@ def test_purchases_shared_in_group(req):
         'date': '2024-01-01T00:00:00Z',
         'store': 'StoreOne',
         'tags': ['buy2024'],
-        'receipt_id': 100,
     }
     r = req.post('purchases', json=purchase1, user=user1)
     purchase1_id = r.json()['id']
@@ -160,7 +159,6 @@ def test_purchases_shared_in_group(req):
         'date': '2024-01-02T00:00:00Z',
         'store': 'Store2',
         'tags': ['sale'],
-        'receipt_id': 200,
     }
     r = req.post('purchases', json=purchase2, user=user2)
     purchase2_id = r.json()['id']
@@ -508,4 +506,235 @@ def test_member_number_in_range(req):
 
     for member in members:
         assert 'member_number' in member
-        assert 1 <= member['member_number'] <= 5, f'Member number {member["member_number"]} is out of range'
\ No newline at end of file
+        assert 1 <= member['member_number'] <= 5, f'Member number {member["member_number"]} is out of range'
+
+
+# Инвайт сохраняется в БД с выданным БД id
+def test_invite_persisted(db, req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+
+    r = req.post('invite', json={'login': user2.login}, user=user1)
+    assert r.status_code == 200
+    invite_id = r.json()['invite_id']
+
+    db_result = db.execute('SELECT i.id FROM invites i JOIN users u ON i.to_user_id = u.id WHERE u.login = %s', (user2.login,))
+    assert len(db_result) == 1
+    assert db_result[0]['id'] == invite_id
+
+    r = req.get('invite', user=user2)
+    invite = next(inv for inv in r.json()['invites'] if inv['id'] == invite_id)
+    assert invite['created_at'] != ''
+
+
+# Повторный инвайт тому же пользователю должен вернуть 409
+def test_duplicate_invite_conflict(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+
+    r = req.post('invite', json={'login': user2.login}, user=user1)
+    assert r.status_code == 200
+
+    r = req.post('invite', json={'login': user2.login}, user=user1)
+    assert r.status_code == 409
+
+
+# Отправитель видит свои исходящие инвайты
+def test_outgoing_invites(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+
+    r = req.post('invite', json={'login': user2.login}, user=user1)
+    invite_id = r.json()['invite_id']
+
+    r = req.get('invite/outgoing', user=user1)
+    assert r.status_code == 200
+    invites = r.json()['invites']
+    assert [inv['id'] for inv in invites] == [invite_id]
+    assert invites[0]['to_login'] == user2.login
+
+    r = req.get('invite/outgoing', user=user2)
+    assert r.json()['invites'] == []
+
+
+# Получатель может отклонить входящий инвайт, а отправитель не может
+def test_decline_invite(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+
+    r = req.post('invite', json={'login': user2.login}, user=user1)
+    invite_id = r.json()['invite_id']
+
+    r = req.delete('invite/incoming', json={'id': invite_id}, user=user1)
+    assert r.status_code == 404
+
+    r = req.delete('invite/incoming', json={'id': invite_id}, user=user2)
+    assert r.status_code == 204
+
+    r = req.get('invite', user=user2)
+    assert not any(inv['id'] == invite_id for inv in r.json()['invites'])
+
+    r = req.get('invite/outgoing', user=user1)
+    assert r.json()['invites'] == []
+
+
+# Отправитель может отозвать исходящий инвайт, а получатель не может
+def test_cancel_invite(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+
+    r = req.post('invite', json={'login': user2.login}, user=user1)
+    invite_id = r.json()['invite_id']
+
+    r = req.delete('invite/outgoing', json={'id': invite_id}, user=user2)
+    assert r.status_code == 404
+
+    r = req.delete('invite/outgoing', json={'id': invite_id}, user=user1)
+    assert r.status_code == 204
+
+    r = req.get('invite', user=user2)
+    assert r.json()['invites'] == []
+
+    # После отзыва можно отправить инвайт повторно
+    r = req.post('invite', json={'login': user2.login}, user=user1)
+    assert r.status_code == 200
+
+
+# Пользователь может вступить в группу по ссылке-приглашению
+def test_invite_link_creates_group(db, req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+
+    r = req.post('invite/links', json={}, user=user1)
+    assert r.status_code == 200, r.text
+    token = r.json()['token']
+    link_id = r.json()['link']['id']
+
+    # В БД хранится только хеш токена
+    db_result = db.execute('SELECT token_hash FROM invite_links WHERE id = %s', (link_id,))
+    assert db_result[0]['token_hash'] != token
+
+    r = req.post('invite/redeem', json={'token': token}, user=user2)
+    assert r.status_code == 200, r.text
+
+    r = req.get('group', user=user1)
+    member_logins = [m['login'] for m in r.json()['members']]
+    assert sorted(member_logins) == sorted([user1.login, user2.login])
+
+
+# Одноразовую ссылку нельзя использовать повторно
+def test_invite_link_single_use(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+    user3 = req.get_new_user()
+
+    r = req.post('invite/links', json={'max_uses': 1}, user=user1)
+    token = r.json()['token']
+
+    r = req.post('invite/redeem', json={'token': token}, user=user2)
+    assert r.status_code == 200
+
+    r = req.post('invite/redeem', json={'token': token}, user=user3)
+    assert r.status_code == 404
+
+    r = req.get('group', user=user3)
+    assert r.json()['members'] == []
+
+
+# Отозванная или неверная ссылка не работает, свою ссылку использовать нельзя
+def test_invite_link_revoked_and_invalid(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+
+    r = req.post('invite/links', json={'max_uses': 2, 'expires_in_hours': 1}, user=user1)
+    token = r.json()['token']
+    link_id = r.json()['link']['id']
+
+    r = req.post('invite/redeem', json={'token': token}, user=user1)
+    assert r.status_code == 400
+
+    r = req.get('invite/links', user=user1)
+    assert [link['id'] for link in r.json()['links']] == [link_id]
+    assert 'token' not in r.json()['links'][0]
+
+    r = req.delete('invite/links', json={'id': link_id}, user=user2)
+    assert r.status_code == 404
+
+    r = req.delete('invite/links', json={'id': link_id}, user=user1)
+    assert r.status_code == 204
+
+    r = req.post('invite/redeem', json={'token': token}, user=user2)
+    assert r.status_code == 404
+
+    r = req.post('invite/redeem', json={'token': 'not_a_real_token'}, user=user2)
+    assert r.status_code == 404
+
+
+# Ссылка с невалидными параметрами не создаётся
+def test_invite_link_invalid_params(req):
+    user = req.get_new_user()
+
+    r = req.post('invite/links', json={'max_uses': 0}, user=user)
+    assert r.status_code == 400
+
+    r = req.post('invite/links', json={'expires_in_hours': 1000}, user=user)
+    assert r.status_code == 400
+
+
+# Дубликаты продуктов участников группы объединяются в один
+def test_merge_group_products(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+    req.post('invite', json={'login': user2.login}, user=user1)
+    req.post('invite', json={'login': user1.login}, user=user2)
+
+    r = req.post('products', json={'name': 'Milk', 'volume': '1L', 'brand': 'Dairy', 'default_tags': ['dairy']}, user=user1)
+    survivor_id = r.json()['id']
+    r = req.post('products', json={'name': 'Milk', 'volume': '1L', 'brand': 'Dairy', 'default_tags': ['breakfast']}, user=user2)
+    duplicate_id = r.json()['id']
+
+    purchase = {
+        'product_id': duplicate_id,
+        'quantity': 1,
+        'price': 90,
+        'date': '2024-03-01T00:00:00Z',
+        'store': 'Store',
+    }
+    r = req.post('purchases', json=purchase, user=user2)
+    purchase_id = r.json()['id']
+
+    r = req.post('products/merge', json={'survivor_id': survivor_id, 'product_ids': [duplicate_id]}, user=user1)
+    assert r.status_code == 200, r.text
+    data = r.json()
+    assert data['merged_ids'] == [duplicate_id]
+    assert data['reassigned_purchases'] == 1
+    assert sorted(data['product']['default_tags']) == ['breakfast', 'dairy']
+
+    r = req.get('products', user=user2)
+    product_ids = [p['id'] for p in r.json()['products']]
+    assert survivor_id in product_ids
+    assert duplicate_id not in product_ids
+
+    r = req.get('purchases', user=user2)
+    purchase = next(p for p in r.json()['purchases'] if p['id'] == purchase_id)
+    assert purchase['product_id'] == survivor_id
+
+
+# Нельзя объединить продукты вне своей группы
+def test_merge_foreign_products(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+
+    r = req.post('products', json={'name': 'Bread', 'volume': '1pc', 'brand': 'Bakery'}, user=user1)
+    own_id = r.json()['id']
+    r = req.post('products', json={'name': 'Bread', 'volume': '1pc', 'brand': 'Bakery'}, user=user2)
+    foreign_id = r.json()['id']
+
+    r = req.post('products/merge', json={'survivor_id': own_id, 'product_ids': [foreign_id]}, user=user1)
+    assert r.status_code == 404
+
+    r = req.post('products/merge', json={'survivor_id': own_id, 'product_ids': [own_id]}, user=user1)
+    assert r.status_code == 400
+
+    r = req.get('products', user=user2)
+    assert any(p['id'] == foreign_id for p in r.json()['products'])
@@ -0,0 +1,89 @@
+from utils.factories import create_product, purchase_json
+from utils.request_manager import bearer
+
+
+def create_token(req, user, scope, name='Script'):
+    r = req.post('account/tokens', json={'name': name, 'scope': scope}, user=user)
+    assert r.status_code == 200
+    data = r.json()
+    token = data['token']
+    assert token.startswith('ybl_pat_')
+    return data['personal_access_token'], bearer(token, login=user.login)
+
+
+# Токены можно создать, посмотреть и отозвать, сам токен возвращается только при создании
+def test_personal_token_lifecycle(req, db):
+    user = req.get_new_user()
+    other = req.get_new_user()
+    meta, script = create_token(req, user, 'read', name='  Bank  ')
+    assert meta['name'] == 'Bank'
+    assert meta['scope'] == 'read'
+
+    r = req.get('account/tokens', user=user)
+    assert r.status_code == 200
+    assert r.json()['tokens'] == [meta]
+    assert req.get('account/tokens', user=other).json()['tokens'] == []
+
+    # В БД хранится только хеш
+    rows = db.execute('SELECT token_hash FROM personal_access_tokens WHERE id = %s', (meta['id'],))
+    assert len(rows) == 1 and rows[0]['token_hash'] != script.token
+
+    assert req.get('products', user=script).status_code == 200
+
+    assert req.delete('account/tokens', json={'id': meta['id']}, user=other).status_code == 404
+    assert req.delete('account/tokens', json={}, user=user).status_code == 400
+    assert req.delete('account/tokens', json={'id': meta['id']}, user=user).status_code == 204
+    assert req.get('products', user=script).status_code == 401
+    assert req.get('account/tokens', user=user).json()['tokens'] == []
+
+
+def test_personal_token_validation(req):
+    user = req.get_new_user()
+    for body in [{'name': '', 'scope': 'read'}, {'name': 'x' * 101, 'scope': 'read'},
+                 {'name': 'Script', 'scope': 'write'}, {'name': 'Script'}]:
+        r = req.post('account/tokens', json=body, user=user)
+        assert r.status_code == 400, body
+
+    r = req.get('products', user=bearer('ybl_pat_unknown'))
+    assert r.status_code == 401
+
+
+# Scope ограничивает запросы: read - только чтение, purchases - еще и покупки, admin - все
+def test_personal_token_scopes(req):
+    user = req.get_new_user()
+    product_id = create_product(req, user)
+    _, reader = create_token(req, user, 'read')
+    _, writer = create_token(req, user, 'purchases')
+    _, admin = create_token(req, user, 'admin')
+
+    assert req.get('purchases', user=reader).status_code == 200
+    assert req.post('purchases', json=purchase_json(product_id), user=reader).status_code == 403
+
+    r = req.post('purchases', json=purchase_json(product_id), user=writer)
+    assert r.status_code == 200
+    purchase_id = r.json()['id']
+    assert req.post('products', json={'name': 'Bread', 'volume': '1L', 'brand': 'Bakery'}, user=writer).status_code == 403
+    assert req.post('account/tokens', json={'name': 'Escalate', 'scope': 'admin'}, user=writer).status_code == 403
+
+    assert req.post('products', json={'name': 'Bread', 'volume': '1L', 'brand': 'Bakery'}, user=admin).status_code == 200
+    assert req.delete('purchases', json={'id': purchase_id}, user=admin).status_code == 204
+
+    # Токены пользователя отзываются вместе с аккаунтом
+    assert req.delete('account', json={'password': user.password}, user=user).status_code == 200
+    assert req.get('purchases', user=reader).status_code == 401
+
+
+# Персональный токен не является входом: выйти с ним нельзя, и текущей сессии у него нет
+def test_personal_token_is_not_session(req):
+    user = req.get_new_user()
+    _, admin = create_token(req, user, 'admin')
+
+    assert req.post('logout', user=admin).status_code == 400
+    assert req.get('products', user=admin).status_code == 200
+
+    r = req.get('sessions', user=admin)
+    assert r.status_code == 200
+    assert r.json()['sessions'] and not any(s['current'] for s in r.json()['sessions'])
+
+    assert req.delete('sessions/others', user=admin).status_code == 400
+    assert req.get('products', user=user).status_code == 200
@@ -1,3 +1,6 @@
+from utils.factories import create_purchase
+
+
 # Пользователь может создать продукт
 def test_create_product(req):
     user = req.get_new_user()
@@ -400,7 +403,6 @@ def test_product_with_digits_and_spaces(req):
         'date': '2024-01-15T00:00:00Z',
         'store': 'Store 24',
         'tags': ['discount50', 'promo2024'],
-        'receipt_id': 999,
     }
     r = req.post('purchases', json=purchase, user=user)
     assert r.status_code == 200, f'Failed to create purchase with digits: {r.status_code} {r.text}'
@@ -411,4 +413,139 @@ def test_product_with_digits_and_spaces(req):
     created_purchase = next(p for p in purchases if p['id'] == purchase_id)
     assert created_purchase['store'] == 'Store 24'
     assert 'discount50' in created_purchase['tags']
-    assert 'promo2024' in created_purchase['tags']
\ No newline at end of file
+    assert 'promo2024' in created_purchase['tags']
+
+
+# Пользователь может удалить неиспользуемый продукт
+def test_delete_product_success(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'Unused', 'volume': '1L', 'brand': 'Brand'}, user=user)
+    product_id = r.json()['id']
+
+    r = req.delete('products', json={'id': product_id}, user=user)
+    assert r.status_code == 204
+
+    r = req.get('products', user=user)
+    assert not any(p['id'] == product_id for p in r.json()['products'])
+
+
+# Удаление продукта с покупками без reassign_to возвращает 409 и количество покупок
+def test_delete_product_in_use(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'Used', 'volume': '1L', 'brand': 'Brand'}, user=user)
+    product_id = r.json()['id']
+    create_purchase(req, user, product_id)
+    create_purchase(req, user, product_id)
+
+    r = req.delete('products', json={'id': product_id}, user=user)
+    assert r.status_code == 409
+    assert r.json()['purchase_count'] == 2
+
+    r = req.get('products', user=user)
+    assert any(p['id'] == product_id for p in r.json()['products'])
+
+
+# При удалении с reassign_to покупки переносятся на другой продукт
+def test_delete_product_reassign(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'OldMilk', 'volume': '1L', 'brand': 'Brand'}, user=user)
+    old_id = r.json()['id']
+    r = req.post('products', json={'name': 'NewMilk', 'volume': '1L', 'brand': 'Brand'}, user=user)
+    new_id = r.json()['id']
+    purchase_id = create_purchase(req, user, old_id)
+
+    r = req.delete('products', json={'id': old_id, 'reassign_to': old_id}, user=user)
+    assert r.status_code == 400
+
+    r = req.delete('products', json={'id': old_id, 'reassign_to': 999999}, user=user)
+    assert r.status_code == 400
+
+    r = req.delete('products', json={'id': old_id, 'reassign_to': new_id}, user=user)
+    assert r.status_code == 200
+    assert r.json()['reassigned_purchases'] == 1
+
+    r = req.get('purchases', user=user)
+    purchase = next(p for p in r.json()['purchases'] if p['id'] == purchase_id)
+    assert purchase['product_id'] == new_id
+
+    r = req.get('products', user=user)
+    assert not any(p['id'] == old_id for p in r.json()['products'])
+
+
+# Пользователь не может удалить чужой продукт
+def test_delete_product_different_user(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+    r = req.post('products', json={'name': 'Foreign', 'volume': '1L', 'brand': 'Brand'}, user=user1)
+    product_id = r.json()['id']
+
+    r = req.delete('products', json={'id': product_id}, user=user2)
+    assert r.status_code == 404
+
+    r = req.delete('products', json={'id': 0}, user=user2)
+    assert r.status_code == 400
+
+
+# Похожие продукты предлагаются как дубликаты, продукты с другим объемом - нет
+def test_product_duplicates(req):
+    user = req.get_new_user()
+    products = [
+        {'name': 'Молоко', 'volume': '1л', 'brand': 'Простоквашино'},
+        {'name': 'Moloko', 'volume': '1 L', 'brand': 'Prostokvashino'},
+        {'name': 'Молоко', 'volume': '2л', 'brand': 'Простоквашино'},
+        {'name': 'Coffee', 'volume': '1л', 'brand': 'CoffeeCo'},
+    ]
+    ids = []
+    for product in products:
+        r = req.post('products', json=product, user=user)
+        assert r.status_code == 200, r.text
+        ids.append(r.json()['id'])
+
+    r = req.get('products/duplicates', user=user)
+    assert r.status_code == 200
+    clusters = r.json()['clusters']
+    assert len(clusters) == 1
+    assert sorted(p['id'] for p in clusters[0]['products']) == sorted(ids[:2])
+    assert clusters[0]['score'] == 1
+
+    r = req.get('products/duplicates?threshold=2', user=user)
+    assert r.status_code == 400
+
+
+# Объем продукта нормализуется в литры, килограммы или штуки
+def test_product_volume_normalized(req):
+    user = req.get_new_user()
+
+    cases = [
+        ('1л', 1, 'l'),
+        ('500g', 0.5, 'kg'),
+        ('6x0.33', 1.98, 'l'),
+        ('6х330мл', 1.98, 'l'),
+        ('10 шт', 10, 'pcs'),
+    ]
+    for volume, amount, unit in cases:
+        r = req.post('products', json={'name': 'Beer', 'volume': volume, 'brand': 'Brewery'}, user=user)
+        assert r.status_code == 200
+        assert r.json()['unit_amount'] == amount, volume
+        assert r.json()['unit'] == unit, volume
+
+    r = req.post('products', json={'name': 'Beer', 'volume': 'big', 'brand': 'Brewery'}, user=user)
+    assert r.status_code == 200
+    assert 'unit_amount' not in r.json()
+    assert 'unit' not in r.json()
+
+
+# При изменении объема нормализованный объем пересчитывается
+def test_product_volume_normalized_on_update(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'Juice', 'volume': '1L', 'brand': 'Garden'}, user=user)
+    product = r.json()
+
+    product['volume'] = '2 кг'
+    r = req.put('products', json=product, user=user)
+    assert r.status_code == 200
+
+    r = req.get('products', user=user)
+    updated = next(p for p in r.json()['products'] if p['id'] == product['id'])
+    assert updated['unit_amount'] == 2
+    assert updated['unit'] == 'kg'
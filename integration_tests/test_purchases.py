@@ -1,3 +1,10 @@
+import csv
+import io
+import json
+
+from utils.factories import create_product, create_purchase
+
+
 # Пользователь может создать покупку
 def test_create_purchase(req):
     user = req.get_new_user()
@@ -16,7 +23,6 @@ def test_create_purchase(req):
         'price': 150,
         'date': '2024-01-15T00:00:00Z',
         'store': 'Store 7',
-        'receipt_id': 1,
     }
     r = req.post('purchases', json=purchase, user=user)
     assert r.status_code == 200
@@ -41,7 +47,6 @@ def test_get_own_purchases(req):
         'price': 50,
         'date': '2024-01-20T00:00:00Z',
         'store': 'LocalStore',
-        'receipt_id': 100,
     }
     r = req.post('purchases', json=purchase, user=user)
     purchase_id = r.json()['id']
@@ -70,7 +75,6 @@ def test_create_purchase_invalid(req):
         'price': 100,
         'date': '2024-01-01',
         'store': 'Store',
-        'receipt_id': 1,
     }
     r = req.post('purchases', json=purchase, user=user)
     assert r.status_code == 400
@@ -94,7 +98,6 @@ def test_delete_purchase_success(req):
         'price': 100,
         'date': '2024-01-25T00:00:00Z',
         'store': 'Supermarket',
-        'receipt_id': 500,
     }
     r = req.post('purchases', json=purchase, user=user)
     assert r.status_code == 200
@@ -141,7 +144,6 @@ def test_delete_purchase_different_user(req):
         'price': 50,
         'date': '2024-01-26T00:00:00Z',
         'store': 'Store',
-        'receipt_id': 600,
     }
     r = req.post('purchases', json=purchase, user=user1)
     assert r.status_code == 200
@@ -197,7 +199,6 @@ def test_delete_multiple_purchases(req):
             'price': 200 + (i * 50),
             'date': f'2024-01-{27 + i:02d}T00:00:00Z',
             'store': stores[i],
-            'receipt_id': 700 + i,
         }
         r = req.post('purchases', json=purchase, user=user)
         assert r.status_code == 200
@@ -211,4 +212,566 @@ def test_delete_multiple_purchases(req):
     assert r.status_code == 200
     purchases = r.json()['purchases']
     for purchase_id in purchase_ids:
-        assert not any(p['id'] == purchase_id for p in purchases)
\ No newline at end of file
+        assert not any(p['id'] == purchase_id for p in purchases)
+
+
+# Пользователь может исправить свою покупку, id покупки сохраняется
+def test_update_purchase_success(req):
+    user = req.get_new_user()
+
+    r = req.post('products', json={'name': 'Cheese', 'volume': '200g', 'brand': 'CheeseCo'}, user=user)
+    product_id = r.json()['id']
+
+    purchase = {
+        'product_id': product_id,
+        'quantity': 1,
+        'price': 300,
+        'date': '2024-02-01T00:00:00Z',
+        'store': 'Store',
+        'tags': ['typo'],
+    }
+    r = req.post('purchases', json=purchase, user=user)
+    purchase_id = r.json()['id']
+
+    purchase.update({'id': purchase_id, 'quantity': 2, 'price': 350, 'tags': ['fixed']})
+    r = req.put('purchases', json=purchase, user=user)
+    assert r.status_code == 200, r.text
+    assert r.json()['id'] == purchase_id
+
+    r = req.get('purchases', user=user)
+    updated = next(p for p in r.json()['purchases'] if p['id'] == purchase_id)
+    assert updated['quantity'] == 2
+    assert updated['price'] == 350
+    assert updated['tags'] == ['fixed']
+
+
+# Исправление покупки из чека без receipt_id оставляет ее в чеке с датой и магазином чека
+def test_update_purchase_keeps_receipt(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'Kefir', 'volume': '1L', 'brand': 'Farm'}, user=user)
+    product_id = r.json()['id']
+    receipt = {
+        'date': '2024-02-01T00:00:00Z',
+        'store': 'Store 1',
+        'purchases': [{'product_id': product_id, 'quantity': 1, 'price': 100}],
+    }
+    r = req.post('receipts', json=receipt, user=user)
+    assert r.status_code == 200, r.text
+    receipt_id = r.json()['receipt']['id']
+    purchase_id = r.json()['purchases'][0]['id']
+
+    purchase = {
+        'id': purchase_id,
+        'product_id': product_id,
+        'quantity': 1,
+        'price': 120,
+        'date': '2024-02-05T00:00:00Z',
+        'store': 'Other Store',
+    }
+    r = req.put('purchases', json=purchase, user=user)
+    assert r.status_code == 200, r.text
+    updated = r.json()
+    assert (updated['receipt_id'], updated['date'], updated['store']) == (receipt_id, '2024-02-01T00:00:00Z', 'Store 1')
+
+    receipts = req.get('receipts', user=user).json()['receipts']
+    updated_receipt = next(rc for rc in receipts if rc['id'] == receipt_id)
+    assert updated_receipt['purchase_ids'] == [purchase_id]
+    assert updated_receipt['total'] == 120
+
+    # Явный receipt_id = 0 убирает покупку из чека
+    r = req.put('purchases', json={**purchase, 'receipt_id': 0}, user=user)
+    assert r.status_code == 200, r.text
+    assert r.json()['receipt_id'] == 0
+    assert r.json()['store'] == 'Other Store'
+
+
+# Исправление покупки с невалидными данными или без id должно вернуть 400
+def test_update_purchase_invalid(req):
+    user = req.get_new_user()
+
+    r = req.post('products', json={'name': 'Butter', 'volume': '180g', 'brand': 'DairyBest'}, user=user)
+    product_id = r.json()['id']
+
+    purchase = {
+        'product_id': product_id,
+        'quantity': 1,
+        'price': 200,
+        'date': '2024-02-01T00:00:00Z',
+        'store': 'Store',
+    }
+    r = req.post('purchases', json=purchase, user=user)
+    purchase_id = r.json()['id']
+
+    r = req.put('purchases', json=purchase, user=user)
+    assert r.status_code == 400
+
+    purchase.update({'id': purchase_id, 'price': 0})
+    r = req.put('purchases', json=purchase, user=user)
+    assert r.status_code == 400
+
+
+# Пользователь не может исправить чужую покупку
+def test_update_purchase_different_user(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+
+    r = req.post('products', json={'name': 'Eggs', 'volume': '10pc', 'brand': 'Farm'}, user=user1)
+    product_id = r.json()['id']
+
+    purchase = {
+        'product_id': product_id,
+        'quantity': 1,
+        'price': 120,
+        'date': '2024-02-01T00:00:00Z',
+        'store': 'Store',
+    }
+    r = req.post('purchases', json=purchase, user=user1)
+    purchase['id'] = r.json()['id']
+    purchase['price'] = 1
+
+    r = req.put('purchases', json=purchase, user=user2)
+    assert r.status_code == 404
+
+    r = req.get('purchases', user=user1)
+    original = next(p for p in r.json()['purchases'] if p['id'] == purchase['id'])
+    assert original['price'] == 120
+
+
+def _create_purchases(req, user, items):
+    product_id = create_product(req, user, name='Tea', volume='100g', brand='TeaCo')
+    ids = [
+        create_purchase(req, user, product_id, date=date, price=price, store=store, tags=tags)
+        for date, price, store, tags in items
+    ]
+    return product_id, ids
+
+
+# Покупки можно фильтровать по дате, магазину, тегу и цене
+def test_get_purchases_filters(req):
+    user = req.get_new_user()
+    _, ids = _create_purchases(req, user, [
+        ('2024-01-10T00:00:00Z', 100, 'Shop A', ['food']),
+        ('2024-02-10T00:00:00Z', 200, 'Shop B', ['food', 'tea']),
+        ('2024-03-10T00:00:00Z', 300, 'Shop A', ['gift']),
+    ])
+
+    r = req.get('purchases?date_from=2024-02-01&date_to=2024-03-31', user=user)
+    assert r.status_code == 200
+    assert {p['id'] for p in r.json()['purchases']} == {ids[1], ids[2]}
+
+    r = req.get('purchases?store=shop%20a', user=user)
+    assert {p['id'] for p in r.json()['purchases']} == {ids[0], ids[2]}
+
+    r = req.get('purchases?tag=food&price_min=150', user=user)
+    assert [p['id'] for p in r.json()['purchases']] == [ids[1]]
+
+
+# По умолчанию покупки отсортированы по дате, новые первыми; можно сортировать по цене
+def test_get_purchases_sort(req):
+    user = req.get_new_user()
+    _, ids = _create_purchases(req, user, [
+        ('2024-01-10T00:00:00Z', 300, 'Shop', []),
+        ('2024-03-10T00:00:00Z', 100, 'Shop', []),
+        ('2024-02-10T00:00:00Z', 200, 'Shop', []),
+    ])
+
+    r = req.get('purchases', user=user)
+    assert [p['id'] for p in r.json()['purchases']] == [ids[1], ids[2], ids[0]]
+
+    r = req.get('purchases?sort=price', user=user)
+    assert [p['id'] for p in r.json()['purchases']] == [ids[1], ids[2], ids[0]]
+
+    r = req.get('purchases?sort=-price', user=user)
+    assert [p['id'] for p in r.json()['purchases']] == [ids[0], ids[2], ids[1]]
+
+
+# Курсорная пагинация отдает все покупки ровно по одному разу
+def test_get_purchases_pagination(req):
+    user = req.get_new_user()
+    _, ids = _create_purchases(req, user, [
+        ('2024-01-10T00:00:00Z', 100, 'Shop', []),
+        ('2024-01-10T00:00:00Z', 100, 'Shop', []),
+        ('2024-01-11T00:00:00Z', 100, 'Shop', []),
+        ('2024-01-12T00:00:00Z', 100, 'Shop', []),
+        ('2024-01-13T00:00:00Z', 100, 'Shop', []),
+    ])
+
+    seen = []
+    page = 'purchases?limit=2'
+    while True:
+        r = req.get(page, user=user)
+        assert r.status_code == 200
+        body = r.json()
+        assert len(body['purchases']) <= 2
+        seen += [p['id'] for p in body['purchases']]
+        if 'next_cursor' not in body:
+            break
+        page = f'purchases?limit=2&cursor={body["next_cursor"]}'
+
+    assert sorted(seen) == sorted(ids)
+    assert len(seen) == len(ids)
+
+
+# Невалидные параметры запроса возвращают 400
+def test_get_purchases_invalid_params(req):
+    user = req.get_new_user()
+
+    for query in ['sort=name', 'limit=0', 'limit=501', 'date_from=yesterday', 'cursor=@@@', 'user_id=999999']:
+        r = req.get(f'purchases?{query}', user=user)
+        assert r.status_code == 400, query
+
+
+# В покупке возвращается цена за литр, килограмм или штуку
+def test_purchase_price_per_unit(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'Cheese', 'volume': '250г', 'brand': 'Dairy'}, user=user)
+    product_id = r.json()['id']
+
+    purchase = {
+        'product_id': product_id,
+        'quantity': 1,
+        'price': 300,
+        'date': '2024-01-15T00:00:00Z',
+        'store': 'Store',
+        'price_per_unit': 1,
+    }
+    r = req.post('purchases', json=purchase, user=user)
+    assert r.status_code == 200
+    assert r.json()['price_per_unit'] == 1200
+    assert r.json()['unit'] == 'kg'
+
+    r = req.get(f'purchases?product_id={product_id}', user=user)
+    assert r.json()['purchases'][0]['price_per_unit'] == 1200
+
+
+# Весовой товар можно купить в дробном количестве, цена указана за килограмм
+def test_create_weighed_purchase(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'Cheese', 'volume': 'weight', 'brand': 'Dairy'}, user=user)
+    product_id = r.json()['id']
+
+    purchase = {
+        'product_id': product_id,
+        'quantity': 0.734,
+        'quantity_unit': 'kg',
+        'price': 1200,
+        'date': '2024-01-15T00:00:00Z',
+        'store': 'Store',
+    }
+    r = req.post('purchases', json=purchase, user=user)
+    assert r.status_code == 200
+    data = r.json()
+    assert data['quantity'] == 0.734
+    assert data['quantity_unit'] == 'kg'
+    assert data['price_per_unit'] == 1200
+    assert data['unit'] == 'kg'
+
+    r = req.get('analytics/spending', user=user)
+    assert r.json()['total'] == 881
+
+
+# Клиент без единицы количества создает покупку в штуках, дробное количество штук запрещено
+def test_purchase_quantity_unit_default(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'Bread', 'volume': '1pc', 'brand': 'Bakery'}, user=user)
+    product_id = r.json()['id']
+
+    purchase = {
+        'product_id': product_id,
+        'quantity': 2,
+        'price': 50,
+        'date': '2024-01-15T00:00:00Z',
+        'store': 'Store',
+    }
+    r = req.post('purchases', json=purchase, user=user)
+    assert r.status_code == 200
+    assert r.json()['quantity_unit'] == 'pcs'
+    assert r.json()['quantity'] == 2
+    assert '"quantity":2,' in r.text
+
+    for quantity, unit in [(1.5, None), (1.5, 'pcs'), (0.0001, 'kg'), (1, 'box'), (0, 'l')]:
+        invalid = dict(purchase, quantity=quantity)
+        if unit:
+            invalid['quantity_unit'] = unit
+        r = req.post('purchases', json=invalid, user=user)
+        assert r.status_code == 400, (quantity, unit)
+
+
+# Покупка без валюты создается в валюте пользователя, невалидная валюта отклоняется
+def test_purchase_default_currency(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'Water', 'volume': '1L', 'brand': 'Spring'}, user=user)
+    product_id = r.json()['id']
+    purchase = {
+        'product_id': product_id,
+        'quantity': 1,
+        'price': 100,
+        'date': '2024-01-15T00:00:00Z',
+        'store': 'Store',
+    }
+
+    r = req.post('purchases', json=purchase, user=user)
+    assert r.status_code == 200
+    assert r.json()['currency'] == 'RUB'
+
+    assert req.put('currency', json={'currency': 'EUR'}, user=user).status_code == 200
+    r = req.post('purchases', json=purchase, user=user)
+    assert r.json()['currency'] == 'EUR'
+
+    r = req.post('purchases', json=dict(purchase, currency='usd'), user=user)
+    assert r.status_code == 400
+
+
+# Скидка вычисляется из обычной цены и наоборот, несогласованные значения отклоняются
+def test_purchase_discount(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'Cheese', 'volume': '200g', 'brand': 'Farm'}, user=user)
+    product_id = r.json()['id']
+    purchase = {
+        'product_id': product_id,
+        'quantity': 2,
+        'price': 1200,
+        'date': '2024-01-15T00:00:00Z',
+        'store': 'Store',
+    }
+
+    r = req.post('purchases', json=dict(purchase, regular_price=1500, promo_type='loyalty_card'), user=user)
+    assert r.status_code == 200
+    data = r.json()
+    assert (data['regular_price'], data['discount'], data['promo_type']) == (1500, 300, 'loyalty_card')
+
+    r = req.post('purchases', json=dict(purchase, discount=1200, promo_type='multi_buy'), user=user)
+    assert r.status_code == 200
+    assert r.json()['regular_price'] == 2400
+
+    r = req.post('purchases', json=purchase, user=user)
+    assert r.status_code == 200
+    assert 'discount' not in r.json() and 'promo_type' not in r.json()
+
+    for invalid in [
+        {'regular_price': 1000, 'promo_type': 'percentage'},
+        {'regular_price': 1500, 'discount': 100, 'promo_type': 'percentage'},
+        {'regular_price': 1500},
+        {'regular_price': 1500, 'promo_type': 'lottery'},
+        {'promo_type': 'coupon'},
+    ]:
+        r = req.post('purchases', json=dict(purchase, **invalid), user=user)
+        assert r.status_code == 400, invalid
+
+
+PURCHASES_CSV = (
+    'Дата;Товар;Бренд;Объем;Цена;Кол-во;Ед;Магазин;Теги\n'
+    '15.01.2024;Молоко;Простоквашино;930мл;89,90;2;;Пятерочка;dairy|weekly\n'
+    '2024-01-20;Молоко;Простоквашино;930мл;95.50;1;;Магнит;\n'
+    '2024-02-01;Яблоки;Сад;1кг;120;0.5;kg;Рынок;\n'
+)
+
+PURCHASES_CSV_MAPPING = {
+    'date': 'Дата', 'name': 'Товар', 'brand': 'Бренд', 'volume': 'Объем',
+    'price': 'Цена', 'quantity': 'Кол-во', 'quantity_unit': 'Ед', 'store': 'Магазин', 'tags': 'Теги',
+}
+
+
+# Пробный импорт CSV проверяет строки, находит продукты и ничего не сохраняет
+def test_import_purchases_dry_run(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'Молоко', 'volume': '930мл', 'brand': 'Простоквашино'}, user=user)
+    assert r.status_code == 200
+
+    request = {'csv': PURCHASES_CSV, 'delimiter': ';', 'mapping': PURCHASES_CSV_MAPPING, 'dry_run': True}
+    r = req.post('purchases/import', json=request, user=user)
+    assert r.status_code == 200, r.text
+    data = r.json()
+    assert data['rows'] == 3
+    assert data['imported'] == 0
+    assert data['errors'] == []
+    assert data['matched_products'] == 2
+    assert [p['name'] for p in data['new_products']] == ['Яблоки']
+    assert data['new_products'][0]['id'] == 0
+
+    assert req.get('purchases', user=user).json()['purchases'] == []
+
+
+# Цена без скидки, равная цене, означает покупку без скидки, а скидка без типа акции считается прочей
+def test_import_purchases_regular_price(req):
+    user = req.get_new_user()
+    content = (
+        'date,name,price,regular_price,store\n'
+        '2024-01-15,Bread,45,45,Bakery\n'
+        '2024-01-16,Bread,40,45,Bakery\n'
+    )
+    r = req.post('purchases/import', json={'csv': content, 'dry_run': True}, user=user)
+    assert r.status_code == 200, r.text
+    assert r.json()['errors'] == []
+
+    r = req.post('purchases/import', json={'csv': content}, user=user)
+    assert r.status_code == 200, r.text
+    purchases = req.get('purchases?sort=date', user=user).json()['purchases']
+    assert [(p['price'], p.get('regular_price'), p.get('discount'), p.get('promo_type')) for p in purchases] == [
+        (4500, None, None, None),
+        (4000, 4500, 500, 'other'),
+    ]
+
+
+# Импорт CSV создает покупки и новые продукты
+def test_import_purchases_commit(req):
+    user = req.get_new_user()
+    request = {'csv': PURCHASES_CSV, 'delimiter': ';', 'mapping': PURCHASES_CSV_MAPPING}
+    r = req.post('purchases/import', json=request, user=user)
+    assert r.status_code == 200, r.text
+    data = r.json()
+    assert data['imported'] == 3
+    assert sorted(p['name'] for p in data['new_products']) == ['Молоко', 'Яблоки']
+    assert all(p['id'] > 0 for p in data['new_products'])
+
+    purchases = req.get('purchases?sort=date', user=user).json()['purchases']
+    assert [(p['date'], p['price'], p['quantity'], p['store']) for p in purchases] == [
+        ('2024-01-15T00:00:00Z', 8990, 2, 'Пятерочка'),
+        ('2024-01-20T00:00:00Z', 9550, 1, 'Магнит'),
+        ('2024-02-01T00:00:00Z', 12000, 0.5, 'Рынок'),
+    ]
+    assert purchases[0]['product_id'] == purchases[1]['product_id']
+    assert purchases[0]['tags'] == ['dairy', 'weekly']
+    assert purchases[2]['quantity_unit'] == 'kg'
+    assert all(p['receipt_id'] == 0 for p in purchases)
+
+
+# Если хотя бы одна строка невалидна, файл не импортируется, ошибки возвращаются по строкам
+def test_import_purchases_all_or_nothing(req):
+    user = req.get_new_user()
+    content = (
+        'date,name,price,store,currency\n'
+        '2024-01-15,Bread,45.00,Bakery,\n'
+        '2024-01-16,Bread,45.123,Bakery,\n'
+        'yesterday,Bread,45,Bakery,\n'
+        '2024-01-17,Bread,45,Bakery,XXX\n'
+        '2024-01-18,Bread,45\n'
+    )
+    r = req.post('purchases/import', json={'csv': content}, user=user)
+    assert r.status_code == 400
+    data = r.json()
+    assert data['rows'] == 5
+    assert data['imported'] == 0
+    assert [(e['row'], e['error']) for e in data['errors']] == [
+        (3, 'invalid price'),
+        (4, 'invalid date'),
+        (5, 'invalid currency'),
+        (6, 'wrong number of fields'),
+    ]
+
+    assert req.get('purchases', user=user).json()['purchases'] == []
+    assert req.get('products', user=user).json()['products'] == []
+
+
+# Невалидные запросы импорта CSV
+def test_import_purchases_invalid(req):
+    user = req.get_new_user()
+    other = req.get_new_user()
+    r = req.post('products', json={'name': 'Bread', 'volume': '1pc', 'brand': 'Bakery'}, user=other)
+    other_product_id = r.json()['id']
+
+    for request in [
+        {'csv': ''},
+        {'csv': 'date,name,price\n2024-01-15,Bread,45\n'},
+        {'csv': 'date,name,price,store\n'},
+        {'csv': 'date,name,price,store\n2024-01-15,Bread,45,Bakery\n', 'mapping': {'price': 'Cost'}},
+        {'csv': 'date,name,price,store\n2024-01-15,Bread,45,Bakery\n', 'mapping': {'weight': 'price'}},
+        {'csv': 'date,name,price,store\n2024-01-15,Bread,45,Bakery\n', 'delimiter': ';;'},
+    ]:
+        r = req.post('purchases/import', json=request, user=user)
+        assert r.status_code == 400, request
+
+    content = 'date,product_id,price,store\n2024-01-15,%d,45,Bakery\n' % other_product_id
+    r = req.post('purchases/import', json={'csv': content, 'dry_run': True}, user=user)
+    assert r.status_code == 200
+    assert r.json()['errors'] == [{'row': 2, 'error': 'product %d not found' % other_product_id}]
+
+
+def create_export_purchases(req, user):
+    product_id = create_product(req, user)
+    for date, price, quantity, currency, tags in [
+        ('2024-01-15T00:00:00Z', 8990, 2, 'RUB', ['dairy', 'weekly']),
+        ('2024-02-01T00:00:00Z', 150, 1, 'USD', []),
+        ('2024-03-01T00:00:00Z', 9500, 1, 'RUB', []),
+    ]:
+        create_purchase(req, user, product_id, date=date, price=price, quantity=quantity,
+                        currency=currency, store='Store & Co', tags=tags)
+    return product_id
+
+
+# Выгрузка в CSV с продуктом, суммами в основных единицах и фильтром по датам
+def test_export_purchases_csv(req):
+    user = req.get_new_user()
+    product_id = create_export_purchases(req, user)
+
+    r = req.get('purchases/export?date_to=2024-02-15', user=user)
+    assert r.status_code == 200
+    assert r.headers['Content-Type'].startswith('text/csv')
+    rows = list(csv.DictReader(io.StringIO(r.text)))
+    assert [(row['date'], row['price'], row['currency'], row['tags']) for row in rows] == [
+        ('2024-01-15', '89.90', 'RUB', 'dairy|weekly'),
+        ('2024-02-01', '1.50', 'USD', ''),
+    ]
+    assert rows[0]['product_id'] == str(product_id)
+    assert (rows[0]['name'], rows[0]['brand'], rows[0]['volume']) == ('Milk', 'Farm', '1L')
+
+    # Выгрузку без product_id можно импортировать в другой аккаунт
+    other = req.get_new_user()
+    exported = r.text.replace('product_id', 'source_product')
+    r = req.post('purchases/import', json={'csv': exported}, user=other)
+    assert r.status_code == 200, r.text
+    assert r.json()['imported'] == 2
+
+
+# Выгрузка в NDJSON: одна покупка с продуктом в строке
+def test_export_purchases_ndjson(req):
+    user = req.get_new_user()
+    create_export_purchases(req, user)
+
+    r = req.get('purchases/export?format=ndjson&date_from=2024-02-01', user=user)
+    assert r.status_code == 200
+    lines = [json.loads(line) for line in r.text.splitlines()]
+    assert [line['price'] for line in lines] == [150, 9500]
+    assert lines[0]['product_name'] == 'Milk'
+    assert lines[0]['product_volume'] == '1L'
+    assert lines[0]['tags'] == []
+
+
+# Выгрузка в OFX в валюте отчета, покупки в другой валюте переводятся по курсу
+def test_export_purchases_ofx(req):
+    user = req.get_new_user()
+    create_export_purchases(req, user)
+
+    r = req.get('purchases/export?format=ofx', user=user)
+    assert r.status_code == 200
+    assert '<CURDEF>RUB</CURDEF>' in r.text
+    assert r.text.count('<STMTTRN>') == 3
+    assert '<TRNAMT>-179.80</TRNAMT>' in r.text
+    assert '<TRNAMT>-135.00</TRNAMT>' in r.text
+    assert '<CURSYM>USD</CURSYM>' in r.text
+    assert '<NAME>Store &amp; Co</NAME>' in r.text
+    assert '<DTSTART>20240115000000</DTSTART>' in r.text
+
+
+# Большая выгрузка отдается несколькими страницами без пропусков и повторов, с одинаковыми датами на границах страниц
+def test_export_purchases_many(req):
+    user = req.get_new_user()
+    rows = ''.join(f'2024-01-{i % 28 + 1:02d},Bread,45,Bakery\n' for i in range(1201))
+    r = req.post('purchases/import', json={'csv': 'date,name,price,store\n' + rows}, user=user)
+    assert r.status_code == 200, r.text
+
+    r = req.get('purchases/export?format=ndjson', user=user)
+    assert r.status_code == 200
+    lines = [json.loads(line) for line in r.text.splitlines()]
+    assert len(lines) == 1201
+    assert len({line['id'] for line in lines}) == 1201
+    keys = [(line['date'], line['id']) for line in lines]
+    assert keys == sorted(keys)
+
+
+# Невалидные параметры выгрузки
+def test_export_purchases_invalid(req):
+    user = req.get_new_user()
+    for query in ['format=xml', 'date_from=yesterday', 'format=ofx&currency=XXX']:
+        r = req.get('purchases/export?' + query, user=user)
+        assert r.status_code == 400, query
@@ -0,0 +1,284 @@
+from utils.factories import create_product
+
+
+# Пользователь может создать чек вместе с покупками
+def test_create_receipt(req):
+    user = req.get_new_user()
+    product_id = create_product(req, user)
+
+    receipt = {
+        'date': '2024-02-01T00:00:00Z',
+        'store': 'Store 1',
+        'common_tags': ['weekly'],
+        'purchases': [
+            {'product_id': product_id, 'quantity': 2, 'price': 100, 'tags': ['milk']},
+            {'product_id': product_id, 'quantity': 1, 'price': 300},
+        ],
+    }
+    r = req.post('receipts', json=receipt, user=user)
+    assert r.status_code == 200, r.text
+    data = r.json()
+    receipt_id = data['receipt']['id']
+    assert data['receipt']['total'] == 500
+    assert len(data['purchases']) == 2
+    for p in data['purchases']:
+        assert p['receipt_id'] == receipt_id
+        assert p['store'] == 'Store 1'
+        assert 'weekly' in p['tags']
+
+    r = req.get('receipts', user=user)
+    assert r.status_code == 200
+    receipts = r.json()['receipts']
+    created = next(rc for rc in receipts if rc['id'] == receipt_id)
+    assert sorted(created['purchase_ids']) == sorted(p['id'] for p in data['purchases'])
+    assert created['total'] == 500
+
+
+# Чек без покупок или с невалидной покупкой не создаётся
+def test_create_receipt_invalid(req):
+    user = req.get_new_user()
+    product_id = create_product(req, user)
+
+    receipt = {'date': '2024-02-01T00:00:00Z', 'store': 'Store', 'purchases': []}
+    r = req.post('receipts', json=receipt, user=user)
+    assert r.status_code == 400
+
+    receipt['purchases'] = [
+        {'product_id': product_id, 'quantity': 1, 'price': 100},
+        {'product_id': product_id, 'quantity': 0, 'price': 100},
+    ]
+    r = req.post('receipts', json=receipt, user=user)
+    assert r.status_code == 400
+
+    r = req.get('purchases', user=user)
+    assert r.json()['purchases'] == []
+
+
+# Изменение чека переносится во все его покупки
+def test_update_receipt(req):
+    user = req.get_new_user()
+    product_id = create_product(req, user)
+
+    receipt = {
+        'date': '2024-02-01T00:00:00Z',
+        'store': 'OldStore',
+        'common_tags': ['old'],
+        'purchases': [{'product_id': product_id, 'quantity': 1, 'price': 100, 'tags': ['own']}],
+    }
+    r = req.post('receipts', json=receipt, user=user)
+    receipt_id = r.json()['receipt']['id']
+
+    update = {'id': receipt_id, 'date': '2024-02-03T00:00:00Z', 'store': 'NewStore', 'common_tags': ['new']}
+    r = req.put('receipts', json=update, user=user)
+    assert r.status_code == 200, r.text
+    assert r.json()['store'] == 'NewStore'
+
+    r = req.get('purchases', user=user)
+    purchase = next(p for p in r.json()['purchases'] if p['receipt_id'] == receipt_id)
+    assert purchase['store'] == 'NewStore'
+    assert purchase['date'].startswith('2024-02-03')
+    assert sorted(purchase['tags']) == ['new', 'own']
+
+
+# Удаление чека удаляет все его покупки
+def test_delete_receipt_cascade(req):
+    user = req.get_new_user()
+    product_id = create_product(req, user)
+
+    receipt = {
+        'date': '2024-02-01T00:00:00Z',
+        'store': 'Store',
+        'purchases': [
+            {'product_id': product_id, 'quantity': 1, 'price': 100},
+            {'product_id': product_id, 'quantity': 1, 'price': 200},
+        ],
+    }
+    r = req.post('receipts', json=receipt, user=user)
+    receipt_id = r.json()['receipt']['id']
+
+    r = req.delete('receipts', json={'id': receipt_id}, user=user)
+    assert r.status_code == 204
+
+    r = req.get('receipts', user=user)
+    assert not any(rc['id'] == receipt_id for rc in r.json()['receipts'])
+
+    r = req.get('purchases', user=user)
+    assert r.json()['purchases'] == []
+
+
+# Нельзя изменить, удалить или дополнить чужой чек
+def test_receipt_ownership(req):
+    user1 = req.get_new_user()
+    user2 = req.get_new_user()
+    product_id = create_product(req, user1)
+    other_product_id = create_product(req, user2)
+
+    receipt = {
+        'date': '2024-02-01T00:00:00Z',
+        'store': 'Store',
+        'purchases': [{'product_id': product_id, 'quantity': 1, 'price': 100}],
+    }
+    r = req.post('receipts', json=receipt, user=user1)
+    receipt_id = r.json()['receipt']['id']
+
+    update = {'id': receipt_id, 'date': '2024-02-01T00:00:00Z', 'store': 'Hacked'}
+    r = req.put('receipts', json=update, user=user2)
+    assert r.status_code == 404
+
+    r = req.delete('receipts', json={'id': receipt_id}, user=user2)
+    assert r.status_code == 404
+
+    purchase = {
+        'product_id': other_product_id,
+        'quantity': 1,
+        'price': 100,
+        'date': '2024-02-01T00:00:00Z',
+        'store': 'Store',
+        'receipt_id': receipt_id,
+    }
+    r = req.post('purchases', json=purchase, user=user2)
+    assert r.status_code == 400
+
+
+def fns_export(fiscal_document_number=12345):
+    return {
+        'ticket': {'document': {'receipt': {
+            'dateTime': '2024-01-15T12:30:00',
+            'totalSum': 22480,
+            'operationType': 1,
+            'retailPlace': 'Пятерочка',
+            'fiscalDriveNumber': '9999078900004792',
+            'fiscalDocumentNumber': fiscal_document_number,
+            'fiscalSign': 1234567890,
+            'items': [
+                {'name': 'Молоко ПРОСТОКВАШИНО 2,5% 930мл', 'price': 8990, 'quantity': 2, 'sum': 17980},
+                {'name': 'Яблоки', 'price': 12000, 'quantity': 0.375, 'sum': 4500, 'itemsQuantityMeasure': 11},
+            ],
+        }}},
+    }
+
+
+# Предпросмотр выгрузки ФНС находит существующий продукт и ничего не сохраняет
+def test_import_receipt_preview(req):
+    user = req.get_new_user()
+    r = req.post('products', json={'name': 'Молоко', 'volume': '930мл', 'brand': 'Простоквашино'}, user=user)
+    milk_id = r.json()['id']
+
+    r = req.post('receipts/import', json={'export': fns_export()}, user=user)
+    assert r.status_code == 200, r.text
+    data = r.json()
+    assert data['receipt']['date'] == '2024-01-15T00:00:00Z'
+    assert data['receipt']['store'] == 'Пятерочка'
+    assert data['receipt']['fiscal_id'] == '9999078900004792-12345-1234567890'
+    assert data['items_total'] == data['total'] == 22480
+    assert data['warnings'] == []
+
+    milk, apples = data['items']
+    assert milk['product']['id'] == milk_id and not milk['new_product']
+    assert apples['new_product'] and apples['product']['id'] == 0
+    assert apples['quantity_unit'] == 'kg'
+    assert (apples['product']['name'], apples['product']['volume']) == ('Яблоки', '1kg')
+
+    assert req.get('receipts', user=user).json()['receipts'] == []
+    assert len(req.get('products', user=user).json()['products']) == 1
+
+
+# Импорт создает чек, покупки и новые продукты, повторный импорт того же чека отклоняется
+def test_import_receipt_commit(req):
+    user = req.get_new_user()
+    request = {'export': fns_export(), 'common_tags': ['weekly'], 'commit': True}
+
+    r = req.post('receipts/import', json=request, user=user)
+    assert r.status_code == 200, r.text
+    data = r.json()
+    receipt = data['receipt']
+    assert receipt['total'] == 22480
+    assert len(receipt['purchase_ids']) == 2
+    assert len(data['products']) == 2
+    for purchase in data['purchases']:
+        assert purchase['receipt_id'] == receipt['id']
+        assert purchase['currency'] == 'RUB'
+        assert 'weekly' in purchase['tags']
+    assert {p['product_id'] for p in data['purchases']} == {p['id'] for p in data['products']}
+
+    r = req.get('receipts', user=user)
+    assert [rc['fiscal_id'] for rc in r.json()['receipts']] == [receipt['fiscal_id']]
+
+    r = req.post('receipts/import', json=request, user=user)
+    assert r.status_code == 409
+
+    r = req.post('receipts/import', json=dict(request, commit=False), user=user)
+    assert r.status_code == 200
+    assert len(r.json()['warnings']) == 1
+    assert all(not item['new_product'] for item in r.json()['items'])
+
+
+# Чек из QR-кода с вставленными строками: скидка по сумме строки и общий продукт для одинаковых строк
+def test_import_receipt_qr(req):
+    user = req.get_new_user()
+    request = {
+        'qr': 't=20240115T1230&s=150.00&fn=9999078900004792&i=777&fp=42&n=1',
+        'items': 'Хлеб бородинский 45.00\nСок яблочный 1л 100.00 x 1 = 80.00\nХлеб бородинский 45.00',
+        'store': 'Bakery',
+        'commit': True,
+    }
+    r = req.post('receipts/import', json=request, user=user)
+    assert r.status_code == 200, r.text
+    data = r.json()
+    assert len(data['products']) == 2
+    bread1, juice, bread2 = data['purchases']
+    assert bread1['product_id'] == bread2['product_id']
+    assert (juice['price'], juice['regular_price'], juice['discount'], juice['promo_type']) == (8000, 10000, 2000, 'other')
+    assert data['receipt']['store'] == 'Bakery'
+    assert data['receipt']['total'] == 17000
+
+    r = req.post('receipts/import', json=dict(request, commit=False), user=user)
+    assert 'items total 17000 does not match receipt total 15000' in r.json()['warnings']
+
+
+# Ручной выбор продукта для строки чека
+def test_import_receipt_overrides(req):
+    user = req.get_new_user()
+    other = req.get_new_user()
+    product_id = create_product(req, user, name='Milk')
+    other_product_id = create_product(req, other, name='Milk')
+
+    overrides = [
+        {'index': 0, 'product_id': product_id},
+        {'index': 1, 'product': {'name': 'Apples', 'volume': '1kg', 'brand': 'Farm'}},
+    ]
+    r = req.post('receipts/import', json={'export': fns_export(), 'overrides': overrides}, user=user)
+    assert r.status_code == 200, r.text
+    milk, apples = r.json()['items']
+    assert milk['product']['id'] == product_id
+    assert apples['new_product'] and apples['product']['name'] == 'Apples'
+
+    for invalid in [
+        [{'index': 0, 'product_id': other_product_id}],
+        [{'index': 5, 'product_id': product_id}],
+        [{'index': 1, 'product': {'name': 'Apples!', 'volume': '1kg', 'brand': 'Farm'}}],
+    ]:
+        r = req.post('receipts/import', json={'export': fns_export(), 'overrides': invalid}, user=user)
+        assert r.status_code == 400, invalid
+
+
+# Невалидные данные чека отклоняются
+def test_import_receipt_invalid(req):
+    user = req.get_new_user()
+    qr = 't=20240115T1230&s=150.00&fn=1&i=2&fp=3'
+    zero_quantity = fns_export()
+    zero_quantity['ticket']['document']['receipt']['items'][0]['quantity'] = 0
+    for request in [
+        {},
+        {'qr': qr, 'items': 'Хлеб 45.00'},
+        {'qr': qr, 'items': '', 'store': 'Bakery'},
+        {'qr': qr, 'items': 'Хлеб сорок', 'store': 'Bakery'},
+        {'qr': 't=20240115T1230&s=150.00&fn=1&i=2', 'items': 'Хлеб 45.00', 'store': 'Bakery'},
+        {'qr': qr + '&n=2', 'items': 'Хлеб 45.00', 'store': 'Bakery'},
+        {'export': {'dateTime': 'yesterday', 'items': []}},
+        {'export': [fns_export(), fns_export(54321)]},
+        {'export': zero_quantity},
+    ]:
+        r = req.post('receipts/import', json=request, user=user)
+        assert r.status_code == 400, request
+    assert req.get('receipts', user=user).json()['receipts'] == []
@@ -0,0 +1,82 @@
+from utils.request_manager import User
+
+
+def login_from(req, user, user_agent):
+    headers = {'User-Agent': user_agent}
+    r = req.post('login', json={'login': user.login, 'password': user.password},
+                 user=User(login='', password='', token='', headers=headers))
+    assert r.status_code == 200
+    token = r.json()['token']
+    session_user = User(login=user.login, password=user.password, token=token,
+                        headers={**headers, 'Authorization': f'Bearer {token}'})
+    return session_user, r.json()['refresh_token']
+
+
+# Список сессий содержит устройство каждого входа и отмечает текущую
+def test_list_sessions(req):
+    user = req.get_new_user()
+    phone, _ = login_from(req, user, 'Phone/1.0')
+    laptop, laptop_refresh = login_from(req, user, 'Laptop/1.0')
+
+    r = req.get('sessions', user=phone)
+    assert r.status_code == 200
+    sessions = r.json()['sessions']
+    # Сессия регистрации и два входа
+    assert len(sessions) == 3
+    assert [s['user_agent'] for s in sessions if s['current']] == ['Phone/1.0']
+    assert 'Laptop/1.0' in [s['user_agent'] for s in sessions]
+    assert all(s['ip'] for s in sessions)
+
+    # Обновление токена поднимает сессию наверх и обновляет устройство
+    laptop.headers['User-Agent'] = 'Laptop/2.0'
+    r = req.post('token/refresh', json={'refresh_token': laptop_refresh}, user=laptop)
+    assert r.status_code == 200
+    sessions = req.get('sessions', user=phone).json()['sessions']
+    assert sessions[0]['user_agent'] == 'Laptop/2.0'
+
+    # Завершенная сессия не показывается
+    req.post('logout', user=user)
+    assert len(req.get('sessions', user=phone).json()['sessions']) == 2
+
+
+# Отозванная сессия сразу перестает работать, чужую сессию отозвать нельзя
+def test_revoke_session(req):
+    user = req.get_new_user()
+    other = req.get_new_user()
+    phone, phone_refresh = login_from(req, user, 'Phone/1.0')
+    sessions = req.get('sessions', user=user).json()['sessions']
+    phone_id = [s['id'] for s in sessions if s['user_agent'] == 'Phone/1.0'][0]
+
+    r = req.delete('sessions', json={'id': phone_id}, user=other)
+    assert r.status_code == 404
+    r = req.delete('sessions', json={}, user=user)
+    assert r.status_code == 400
+
+    r = req.delete('sessions', json={'id': phone_id}, user=user)
+    assert r.status_code == 204
+    assert req.get('products', user=phone).status_code == 401
+    r = req.post('token/refresh', json={'refresh_token': phone_refresh})
+    assert r.status_code == 401
+    r = req.delete('sessions', json={'id': phone_id}, user=user)
+    assert r.status_code == 404
+
+    assert req.get('products', user=user).status_code == 200
+
+
+# Отзыв всех сессий, кроме текущей
+def test_revoke_other_sessions(req):
+    user = req.get_new_user()
+    phone, _ = login_from(req, user, 'Phone/1.0')
+    laptop, _ = login_from(req, user, 'Laptop/1.0')
+
+    r = req.delete('sessions/others', user=laptop)
+    assert r.status_code == 200
+    assert r.json()['revoked'] == 2
+
+    assert req.get('products', user=user).status_code == 401
+    assert req.get('products', user=phone).status_code == 401
+    assert req.get('products', user=laptop).status_code == 200
+    sessions = req.get('sessions', user=laptop).json()['sessions']
+    assert [(s['user_agent'], s['current']) for s in sessions] == [('Laptop/1.0', True)]
+
+    assert req.get('sessions/others', user=laptop).status_code == 405
@@ -0,0 +1,154 @@
+import base64
+import hashlib
+import hmac
+import struct
+import time
+
+from utils.request_manager import bearer
+
+
+def totp(secret, offset=0):
+    key = base64.b32decode(secret + '=' * (-len(secret) % 8))
+    counter = int(time.time() + offset) // 30
+    digest = hmac.new(key, struct.pack('>Q', counter), hashlib.sha1).digest()
+    start = digest[-1] & 0x0f
+    value = struct.unpack('>I', digest[start:start + 4])[0] & 0x7fffffff
+    return f'{value % 1000000:06d}'
+
+
+def enable_two_factor(req, user):
+    r = req.post('account/2fa/setup', json={'password': user.password}, user=user)
+    assert r.status_code == 200
+    secret = r.json()['secret']
+    r = req.post('account/2fa/enable', json={'code': totp(secret)}, user=user)
+    assert r.status_code == 200
+    return secret, r.json()['recovery_codes']
+
+
+def login_challenge(req, user):
+    r = req.post('login', json={'login': user.login, 'password': user.password})
+    assert r.status_code == 200
+    data = r.json()
+    assert data['two_factor_required'] is True
+    assert 'token' not in data
+    return data['challenge_token']
+
+
+# Подключение: секрет и URI для QR-кода, включение первым кодом, коды восстановления
+def test_enable_two_factor(req):
+    user = req.get_new_user()
+    assert req.get('account/2fa', user=user).json() == {'enabled': False, 'recovery_codes_left': 0}
+
+    r = req.post('account/2fa/enable', json={'code': '123456'}, user=user)
+    assert r.status_code == 400
+    r = req.post('account/2fa/setup', json={'password': 'wrong'}, user=user)
+    assert r.status_code == 403
+
+    r = req.post('account/2fa/setup', json={'password': user.password}, user=user)
+    assert r.status_code == 200
+    secret = r.json()['secret']
+    assert r.json()['provisioning_uri'].startswith('otpauth://totp/')
+    assert f'secret={secret}' in r.json()['provisioning_uri']
+
+    # Пока подключение не подтверждено, вход работает по паролю
+    r = req.post('login', json={'login': user.login, 'password': user.password})
+    assert 'token' in r.json()
+
+    r = req.post('account/2fa/enable', json={'code': '000000' if totp(secret) != '000000' else '111111'}, user=user)
+    assert r.status_code == 403
+    r = req.post('account/2fa/enable', json={'code': totp(secret)}, user=user)
+    assert r.status_code == 200
+    codes = r.json()['recovery_codes']
+    assert len(codes) == 10 and len(set(codes)) == 10
+
+    assert req.get('account/2fa', user=user).json() == {'enabled': True, 'recovery_codes_left': 10}
+    r = req.post('account/2fa/setup', json={'password': user.password}, user=user)
+    assert r.status_code == 409
+
+
+# Вход в два шага: пароль дает токен подтверждения, который вместе с кодом обменивается на токены
+def test_login_with_two_factor(req):
+    user = req.get_new_user()
+    secret, _ = enable_two_factor(req, user)
+    challenge = login_challenge(req, user)
+
+    # Токен подтверждения не работает как access-токен
+    assert req.get('products', user=bearer(challenge)).status_code == 401
+
+    r = req.post('login/2fa', json={'challenge_token': challenge, 'code': '12345'})
+    assert r.status_code == 401
+    r = req.post('login/2fa', json={'challenge_token': 'invalid', 'code': totp(secret, 30)})
+    assert r.status_code == 401
+    r = req.post('login/2fa', json={'challenge_token': challenge})
+    assert r.status_code == 400
+
+    # Код, которым подключение было подтверждено, уже использован, поэтому берем код следующего шага
+    r = req.post('login/2fa', json={'challenge_token': challenge, 'code': totp(secret, 30)})
+    assert r.status_code == 200
+    data = r.json()
+    assert data['refresh_token'] and data['expires_in'] == 900
+    assert req.get('products', user=bearer(data['token'])).status_code == 200
+
+    # Токен подтверждения и код одноразовые
+    r = req.post('login/2fa', json={'challenge_token': challenge, 'code': totp(secret, 30)})
+    assert r.status_code == 401
+    r = req.post('login/2fa', json={'challenge_token': login_challenge(req, user), 'code': totp(secret, 30)})
+    assert r.status_code == 401
+
+
+# Коды восстановления одноразовые и заменяются новыми
+def test_login_with_recovery_code(req):
+    user = req.get_new_user()
+    _, codes = enable_two_factor(req, user)
+
+    r = req.post('login/2fa', json={'challenge_token': login_challenge(req, user), 'code': codes[0].upper()})
+    assert r.status_code == 200
+    r = req.post('login/2fa', json={'challenge_token': login_challenge(req, user), 'code': codes[0]})
+    assert r.status_code == 401
+    assert req.get('account/2fa', user=user).json()['recovery_codes_left'] == 9
+
+    r = req.post('account/2fa/recovery-codes', json={'password': 'wrong'}, user=user)
+    assert r.status_code == 403
+    r = req.post('account/2fa/recovery-codes', json={'password': user.password}, user=user)
+    assert r.status_code == 200
+    new_codes = r.json()['recovery_codes']
+    assert req.get('account/2fa', user=user).json()['recovery_codes_left'] == 10
+
+    r = req.post('login/2fa', json={'challenge_token': login_challenge(req, user), 'code': codes[1]})
+    assert r.status_code == 401
+    r = req.post('login/2fa', json={'challenge_token': login_challenge(req, user), 'code': new_codes[0].replace('-', '')})
+    assert r.status_code == 200
+
+
+# После 5 неверных кодов подряд проверка блокируется даже для верного кода
+def test_two_factor_attempts_limit(req):
+    user = req.get_new_user()
+    secret, _ = enable_two_factor(req, user)
+    challenge = login_challenge(req, user)
+
+    wrong = '000000' if totp(secret, 30) != '000000' else '111111'
+    for _ in range(5):
+        r = req.post('login/2fa', json={'challenge_token': challenge, 'code': wrong})
+        assert r.status_code == 401
+    r = req.post('login/2fa', json={'challenge_token': challenge, 'code': totp(secret, 30)})
+    assert r.status_code == 429
+
+
+# Выключение требует пароль и код, после него вход снова работает по паролю
+def test_disable_two_factor(req):
+    user = req.get_new_user()
+    _, codes = enable_two_factor(req, user)
+
+    r = req.delete('account/2fa', json={'password': 'wrong', 'code': codes[0]}, user=user)
+    assert r.status_code == 403
+    r = req.delete('account/2fa', json={'password': user.password, 'code': 'wrong-code'}, user=user)
+    assert r.status_code == 403
+    r = req.delete('account/2fa', json={'password': user.password, 'code': codes[0]}, user=user)
+    assert r.status_code == 200
+
+    assert req.get('account/2fa', user=user).json() == {'enabled': False, 'recovery_codes_left': 0}
+    r = req.post('login', json={'login': user.login, 'password': user.password})
+    assert r.status_code == 200
+    assert 'token' in r.json()
+    r = req.post('account/2fa/recovery-codes', json={'password': user.password}, user=user)
+    assert r.status_code == 400
@@ -0,0 +1,22 @@
+def create_product(req, user, name='Milk', volume='1L', brand='Farm'):
+    r = req.post('products', json={'name': name, 'volume': volume, 'brand': brand}, user=user)
+    assert r.status_code == 200
+    return r.json()['id']
+
+
+def purchase_json(product_id, **fields):
+    purchase = {
+        'product_id': product_id,
+        'quantity': 1,
+        'price': 100,
+        'date': '2024-03-01T00:00:00Z',
+        'store': 'Store',
+    }
+    purchase.update(fields)
+    return purchase
+
+
+def create_purchase(req, user, product_id, **fields):
+    r = req.post('purchases', json=purchase_json(product_id, **fields), user=user)
+    assert r.status_code == 200, r.text
+    return r.json()['id']
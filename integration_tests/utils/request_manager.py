@@ -10,6 +10,10 @@ class User:
     headers: dict[str, str]
 
 
+def bearer(token, login=''):
+    return User(login=login, password='', token=token, headers={'Authorization': f'Bearer {token}'})
+
+
 class RequestManager:
     def __init__(self, url):
         self.url = url
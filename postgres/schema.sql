@@ -1,15 +1,25 @@
 DROP TABLE IF EXISTS invites CASCADE;
+DROP TABLE IF EXISTS invite_links CASCADE;
 DROP TABLE IF EXISTS groups CASCADE;
 DROP TABLE IF EXISTS purchases CASCADE;
+DROP TABLE IF EXISTS receipts CASCADE;
 DROP TABLE IF EXISTS products CASCADE;
 DROP TABLE IF EXISTS users CASCADE;
 DROP TABLE IF EXISTS group_members CASCADE;
+DROP TABLE IF EXISTS exchange_rates CASCADE;
+DROP TABLE IF EXISTS token_families CASCADE;
+DROP TABLE IF EXISTS refresh_tokens CASCADE;
+DROP TABLE IF EXISTS revoked_tokens CASCADE;
+DROP TABLE IF EXISTS personal_access_tokens CASCADE;
+DROP TABLE IF EXISTS user_totp CASCADE;
+DROP TABLE IF EXISTS totp_recovery_codes CASCADE;
 
 -- Create tables
 CREATE TABLE users (
     id SERIAL PRIMARY KEY,
     login VARCHAR(50) UNIQUE NOT NULL,
-    password_hash VARCHAR(60) NOT NULL
+    password_hash VARCHAR(60) NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'RUB'
 );
 
 CREATE TABLE products (
@@ -18,18 +28,37 @@ CREATE TABLE products (
     volume VARCHAR(10) NOT NULL,
     brand VARCHAR(30) NOT NULL,
     default_tags VARCHAR(250) NOT NULL,
-    user_id INTEGER REFERENCES users(id)
+    user_id INTEGER REFERENCES users(id),
+    unit_amount DOUBLE PRECISION NOT NULL DEFAULT 0,
+    unit VARCHAR(3) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE receipts (
+    id SERIAL PRIMARY KEY,
+    date DATE NOT NULL,
+    store VARCHAR(30) NOT NULL,
+    tags TEXT[],
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    fiscal_id VARCHAR(64) NOT NULL DEFAULT ''
 );
 
+-- Один и тот же фискальный чек нельзя импортировать дважды
+CREATE UNIQUE INDEX receipts_user_fiscal_id ON receipts (user_id, fiscal_id) WHERE fiscal_id <> '';
+
 CREATE TABLE purchases (
     id SERIAL PRIMARY KEY,
     product_id INTEGER NOT NULL REFERENCES products(id),
-    quantity INTEGER NOT NULL,
+    quantity NUMERIC(12, 3) NOT NULL,
+    quantity_unit VARCHAR(3) NOT NULL DEFAULT 'pcs',
     price INTEGER NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'RUB',
+    regular_price INTEGER NOT NULL DEFAULT 0,
+    discount INTEGER NOT NULL DEFAULT 0,
+    promo_type VARCHAR(20) NOT NULL DEFAULT '',
     date DATE NOT NULL,
     store VARCHAR(30) NOT NULL,
     tags TEXT[],
-    receipt_id INTEGER,
+    receipt_id INTEGER REFERENCES receipts(id) ON DELETE CASCADE,
     user_id INTEGER REFERENCES users(id)
 );
 
@@ -50,3 +79,82 @@ CREATE TABLE invites (
     CHECK (from_user_id != to_user_id)
 );
 
+CREATE TABLE invite_links (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    max_uses INTEGER NOT NULL CHECK (max_uses >= 1),
+    uses INTEGER NOT NULL DEFAULT 0 CHECK (uses <= max_uses),
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE exchange_rates (
+    date DATE NOT NULL,
+    currency CHAR(3) NOT NULL,
+    rate NUMERIC(20, 10) NOT NULL CHECK (rate > 0),
+    PRIMARY KEY (date, currency)
+);
+
+-- Семейство токенов обновления: создается при входе, при каждом обновлении выдается новый токен того же семейства.
+-- Для пользователя это сессия: устройство (user agent), IP и время последнего обновления токена
+CREATE TABLE token_families (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+-- Хранится только хеш токена. access_jti - access-токен, выданный вместе с токеном обновления,
+-- он попадает в revoked_tokens при отзыве семейства
+CREATE TABLE refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    family_id INTEGER NOT NULL REFERENCES token_families(id) ON DELETE CASCADE,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    access_jti VARCHAR(64) NOT NULL,
+    access_expires_at TIMESTAMP NOT NULL
+);
+
+-- Отозванные до истечения срока access-токены
+CREATE TABLE revoked_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Персональные токены для скриптов и интеграций. Хранится только хеш токена, scope ограничивает доступ:
+-- read - только чтение, purchases - чтение и запись покупок и чеков, admin - полный доступ
+CREATE TABLE personal_access_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    scope VARCHAR(20) NOT NULL CHECK (scope IN ('read', 'purchases', 'admin')),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Двухфакторная аутентификация (TOTP). Секрет хранится открыто, он нужен для проверки кодов.
+-- Секрет действует только после подтверждения кодом (enabled). last_used_step защищает от повторного
+-- использования кода, после 5 ошибок подряд проверка кодов блокируется до locked_until
+CREATE TABLE user_totp (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret VARCHAR(32) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    failed_attempts INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Одноразовые коды восстановления на случай потери устройства, хранятся только хеши
+CREATE TABLE totp_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP,
+    UNIQUE (user_id, code_hash)
+);
@@ -6,6 +6,16 @@ INSERT INTO products (name, volume, brand, default_tags) VALUES
 ('Tea','500ml','Brand1','healthy,drink'),
 ('Coffee','250g','Brand2','energy,drink');
 
+INSERT INTO receipts (date, store, tags, user_id) VALUES
+('2023-03-01', 'Store', '{"drink"}', 1),
+('2023-03-02', 'Store', '{"drink"}', 2);
+
 INSERT INTO purchases (product_id, quantity, price, date, store, tags, receipt_id, user_id) VALUES
 (1, 2, 100, '2023-03-01', 'Store', '{"healthy","drink","morning"}', 1, 1),
-(2, 1, 200, '2023-03-02', 'Store', '{"energy","drink","work"}', 1, 2);
+(2, 1, 200, '2023-03-02', 'Store', '{"energy","drink","work"}', 2, 2);
+
+INSERT INTO exchange_rates (date, currency, rate) VALUES
+('2024-01-01', 'USD', 90),
+('2024-01-01', 'EUR', 100),
+('2024-06-01', 'USD', 80),
+('2024-06-01', 'EUR', 95);
@@ -12,14 +12,20 @@ import (
 	_ "github.com/lib/pq"
 
 	"yuki_buy_log/internal/auth"
+	"yuki_buy_log/internal/domain"
 	"yuki_buy_log/internal/handlers"
+	"yuki_buy_log/internal/stores"
 	"yuki_buy_log/internal/tasks"
+	"yuki_buy_log/internal/utils"
 )
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	authenticator := auth.NewAuthenticator()
+	authenticator, err := auth.NewAuthenticator(stores.GetTokenStore(), stores.GetPersonalAccessTokenStore())
+	if err != nil {
+		log.Fatalf("Failed to create authenticator: %v", err)
+	}
 
 	mux := newServeMux(authenticator)
 	srv := newHTTPServer(mux)
@@ -61,12 +67,41 @@ func newServeMux(authenticator *auth.Authenticator) *http.ServeMux {
 	})
 
 	mux.Handle("/products", authenticator.Middleware(handlers.ProductsHandler(authenticator)))
-	mux.Handle("/purchases", authenticator.Middleware(handlers.PurchasesHandler(authenticator)))
+	mux.Handle("/products/merge", authenticator.Middleware(handlers.ProductsMergeHandler(authenticator)))
+	mux.Handle("/products/duplicates", authenticator.Middleware(handlers.ProductDuplicatesHandler(authenticator)))
+	mux.Handle("/purchases", authenticator.MiddlewareWithScope(domain.ScopePurchases, handlers.PurchasesHandler(authenticator)))
+	mux.Handle("/purchases/import", authenticator.MiddlewareWithScope(domain.ScopePurchases, handlers.PurchaseImportHandler(authenticator)))
+	mux.Handle("/purchases/export", authenticator.Middleware(handlers.PurchaseExportHandler(authenticator)))
+	mux.Handle("/receipts", authenticator.MiddlewareWithScope(domain.ScopePurchases, handlers.ReceiptsHandler(authenticator)))
+	mux.Handle("/receipts/import", authenticator.MiddlewareWithScope(domain.ScopePurchases, handlers.ReceiptImportHandler(authenticator)))
+	mux.Handle("/analytics/spending", authenticator.Middleware(handlers.SpendingSummaryHandler(authenticator)))
+	mux.Handle("/analytics/prices", authenticator.Middleware(handlers.PriceHistoryHandler(authenticator)))
+	mux.Handle("/analytics/savings", authenticator.Middleware(handlers.SavingsSummaryHandler(authenticator)))
+	mux.Handle("/currency", authenticator.Middleware(handlers.CurrencyHandler(authenticator)))
 	mux.Handle("/group", authenticator.Middleware(handlers.GroupHandler(authenticator)))
+	mux.Handle("/account", authenticator.Middleware(handlers.AccountHandler(authenticator)))
+	mux.Handle("/account/export", authenticator.Middleware(handlers.AccountExportHandler(authenticator)))
+	mux.Handle("/account/password", authenticator.Middleware(handlers.AccountPasswordHandler(authenticator)))
+	mux.Handle("/account/login", authenticator.Middleware(handlers.AccountLoginHandler(authenticator)))
+	mux.Handle("/account/tokens", authenticator.Middleware(handlers.PersonalAccessTokensHandler(authenticator)))
+	mux.Handle("/account/2fa", authenticator.Middleware(handlers.TwoFactorHandler(authenticator)))
+	mux.Handle("/account/2fa/setup", authenticator.Middleware(handlers.TwoFactorSetupHandler(authenticator)))
+	mux.Handle("/account/2fa/enable", authenticator.Middleware(handlers.TwoFactorEnableHandler(authenticator)))
+	mux.Handle("/account/2fa/recovery-codes", authenticator.Middleware(handlers.TwoFactorRecoveryCodesHandler(authenticator)))
 	mux.Handle("/invite", authenticator.Middleware(handlers.InviteHandler(authenticator)))
+	mux.Handle("/invite/incoming", authenticator.Middleware(handlers.IncomingInvitesHandler(authenticator)))
+	mux.Handle("/invite/outgoing", authenticator.Middleware(handlers.OutgoingInvitesHandler(authenticator)))
+	mux.Handle("/invite/links", authenticator.Middleware(handlers.InviteLinksHandler(authenticator)))
+	mux.Handle("/invite/redeem", authenticator.Middleware(handlers.RedeemInviteLinkHandler(authenticator)))
 
 	mux.HandleFunc("/register", handlers.RegisterHandler(authenticator))
 	mux.HandleFunc("/login", handlers.LoginHandler(authenticator))
+	mux.HandleFunc("/login/2fa", handlers.LoginTwoFactorHandler(authenticator))
+	mux.HandleFunc("/token/refresh", handlers.RefreshTokenHandler(authenticator))
+	mux.HandleFunc("/.well-known/jwks.json", handlers.JWKSHandler(authenticator))
+	mux.Handle("/logout", authenticator.Middleware(handlers.LogoutHandler(authenticator)))
+	mux.Handle("/sessions", authenticator.Middleware(handlers.SessionsHandler(authenticator)))
+	mux.Handle("/sessions/others", authenticator.Middleware(handlers.OtherSessionsHandler(authenticator)))
 
 	return mux
 }
@@ -95,6 +130,25 @@ func newScheduler() *tasks.Scheduler {
 		Interval: 5 * time.Minute,
 		Run:      tasks.CleanupOldInvites(),
 	})
+	scheduler.AddTask(tasks.Task{
+		Name:     "cleanup_expired_invite_links",
+		Interval: 5 * time.Minute,
+		Run:      tasks.CleanupExpiredInviteLinks(),
+	})
+	scheduler.AddTask(tasks.Task{
+		Name:     "cleanup_expired_tokens",
+		Interval: time.Hour,
+		Run:      tasks.CleanupExpiredTokens(),
+	})
+	if utils.ExchangeRatesFile != "" {
+		loadExchangeRates := tasks.LoadExchangeRates(utils.ExchangeRatesFile)
+		loadExchangeRates()
+		scheduler.AddTask(tasks.Task{
+			Name:     "load_exchange_rates",
+			Interval: time.Hour,
+			Run:      loadExchangeRates,
+		})
+	}
 	return scheduler
 }
 
@@ -2,6 +2,10 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -9,35 +13,194 @@ import (
 	"strings"
 	"time"
 	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/utils"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Lifetimes of access and refresh tokens. An access token is short-lived and is renewed
+// with a refresh token, each refresh issues a new refresh token of the same family.
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// ChallengeTokenTTL is the lifetime of a login challenge token: after the password is checked
+// a user with two-factor authentication has this long to enter a code.
+const ChallengeTokenTTL = 5 * time.Minute
+
+// challengeAudience is the audience of login challenge tokens. Access tokens have no audience,
+// so a challenge token can never be used as an access token.
+const challengeAudience = "login_challenge"
+
+// Denylist reports whether an access token was revoked before it expired.
+type Denylist interface {
+	IsRevoked(jti string) bool
+}
+
+// PersonalAccessTokens looks up personal access tokens by their hash.
+type PersonalAccessTokens interface {
+	GetPersonalAccessToken(tokenHash string) *domain.PersonalAccessToken
+}
+
+// scopeLevels orders the scopes of personal access tokens, a scope includes all lower ones.
+var scopeLevels = map[domain.TokenScope]int{
+	domain.ScopeRead:      1,
+	domain.ScopePurchases: 2,
+	domain.ScopeAdmin:     3,
+}
+
+// accessClaims are the claims of an access token: the subject is the user id,
+// the token id (jti) is used to revoke the token and fid is its refresh token family.
+type accessClaims struct {
+	FamilyId domain.TokenFamilyId `json:"fid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// defaultSecret is the JWT secret used in dev mode when no keys are configured.
+const defaultSecret = "secret"
+
 // Authenticator handles token generation and verification.
 type Authenticator struct {
-	secret []byte
+	keys           *KeySet
+	denylist       Denylist
+	personalTokens PersonalAccessTokens
 }
 
-func NewAuthenticator() *Authenticator {
+// NewAuthenticator creates an authenticator with the keys from the environment:
+// JWT_KEYS_DIR is a directory of keys (see LoadKeySet) and JWT_SIGNING_KEY_ID is the key
+// new tokens are signed with. JWT_SECRET is an HS256 secret, it signs tokens when there is
+// no key directory and otherwise only verifies tokens without a kid. Like the secrets of key
+// files it must be at least 32 bytes long, shorter secrets (e.g. the default one) are allowed
+// only in dev mode.
+func NewAuthenticator(denylist Denylist, personalTokens PersonalAccessTokens) (*Authenticator, error) {
+	dir := os.Getenv("JWT_KEYS_DIR")
 	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "secret" // TODO: use secure secret in production
+	if secret != "" && len(secret) < minSecretLength && !utils.DevMode {
+		return nil, fmt.Errorf("JWT_SECRET is shorter than %d bytes, short secrets are allowed only in dev mode", minSecretLength)
 	}
-	return &Authenticator{secret: []byte(secret)}
+
+	var keys *KeySet
+	switch {
+	case dir != "":
+		var err error
+		keys, err = LoadKeySet(dir, os.Getenv("JWT_SIGNING_KEY_ID"))
+		if err != nil {
+			return nil, err
+		}
+		if secret != "" {
+			if err := keys.addKey(newSecretKey(legacyKeyId, []byte(secret))); err != nil {
+				return nil, err
+			}
+		}
+	case secret != "":
+		keys = NewSecretKeySet([]byte(secret))
+	case utils.DevMode:
+		log.Println("No JWT keys configured, using the default secret in dev mode")
+		keys = NewSecretKeySet([]byte(defaultSecret))
+	default:
+		return nil, errors.New("no JWT keys configured: set JWT_KEYS_DIR or JWT_SECRET")
+	}
+
+	log.Printf("Loaded JWT keys, signing with %q (%s), accepted algorithms: %v",
+		keys.Current().Id, keys.Current().Method.Alg(), keys.Methods())
+	return &Authenticator{keys: keys, denylist: denylist, personalTokens: personalTokens}, nil
 }
 
-// GenerateToken creates a signed JWT for the given user id.
-func (a *Authenticator) GenerateToken(userId domain.UserId) (string, error) {
+// JWKS returns the public keys tokens can be verified with.
+func (a *Authenticator) JWKS() JWKSet {
+	return a.keys.JWKS()
+}
+
+// GenerateToken creates a signed access token for the given user id and refresh token family.
+func (a *Authenticator) GenerateToken(userId domain.UserId, familyId domain.TokenFamilyId) (domain.AccessToken, error) {
+	jti, err := newTokenId()
+	if err != nil {
+		return domain.AccessToken{}, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(AccessTokenTTL)
+	claims := accessClaims{
+		FamilyId: familyId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   strconv.FormatInt(int64(userId), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token, err := a.keys.sign(claims)
+	if err != nil {
+		return domain.AccessToken{}, err
+	}
+	// Срок округляется до секунд, как в самом токене
+	return domain.AccessToken{
+		Token:     token,
+		Jti:       jti,
+		UserId:    userId,
+		FamilyId:  familyId,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
+
+// GenerateChallengeToken creates a login challenge token for the user whose password was checked.
+func (a *Authenticator) GenerateChallengeToken(userId domain.UserId) (domain.AccessToken, error) {
+	jti, err := newTokenId()
+	if err != nil {
+		return domain.AccessToken{}, err
+	}
+	now := time.Now()
 	claims := jwt.RegisteredClaims{
+		ID:        jti,
 		Subject:   strconv.FormatInt(int64(userId), 10),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+		Audience:  jwt.ClaimStrings{challengeAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ChallengeTokenTTL)),
+	}
+	token, err := a.keys.sign(claims)
+	if err != nil {
+		return domain.AccessToken{}, err
+	}
+	return domain.AccessToken{Token: token, Jti: jti, UserId: userId, ExpiresAt: claims.ExpiresAt.Time}, nil
+}
+
+// ParseChallengeToken verifies a login challenge token. A used token is revoked by the caller,
+// so it is checked against the denylist like an access token.
+func (a *Authenticator) ParseChallengeToken(tokenStr string) (domain.AccessToken, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, a.keys.keyFunc,
+		jwt.WithValidMethods(a.keys.Methods()), jwt.WithAudience(challengeAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return domain.AccessToken{}, err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(a.secret)
+	if claims.ID == "" || a.denylist.IsRevoked(claims.ID) {
+		return domain.AccessToken{}, errors.New("challenge token revoked")
+	}
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return domain.AccessToken{}, fmt.Errorf("invalid subject: %w", err)
+	}
+	return domain.AccessToken{Token: tokenStr, Jti: claims.ID, UserId: domain.UserId(id), ExpiresAt: claims.ExpiresAt.Time}, nil
+}
+
+// newTokenId generates a random access token id.
+func newTokenId() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
 }
 
-// Middleware verifies the Authorization header and adds user id to the context.
+// Middleware verifies the Authorization header and adds user id and the access token (or the personal
+// access token) to the context. Personal access tokens need the admin scope to change data.
 func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return a.MiddlewareWithScope(domain.ScopeAdmin, next)
+}
+
+// MiddlewareWithScope is Middleware for routes where personal access tokens with writeScope may change
+// data. Reading (GET and HEAD requests) is allowed to every personal access token.
+func (a *Authenticator) MiddlewareWithScope(writeScope domain.TokenScope, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Auth middleware processing request: %s %s", r.Method, r.URL.Path)
 		auth := r.Header.Get("Authorization")
@@ -47,34 +210,71 @@ func (a *Authenticator) Middleware(next http.Handler) http.Handler {
 			return
 		}
 		tokenStr := strings.TrimPrefix(auth, "Bearer ")
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			return a.secret, nil
-		})
+		if strings.HasPrefix(tokenStr, PersonalAccessTokenPrefix) {
+			a.servePersonalAccessToken(w, r, tokenStr, writeScope, next)
+			return
+		}
+		claims := &accessClaims{}
+		token, err := jwt.ParseWithClaims(tokenStr, claims, a.keys.keyFunc, jwt.WithValidMethods(a.keys.Methods()))
 		if err != nil || !token.Valid {
 			log.Printf("Invalid token for %s: %v", r.URL.Path, err)
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			log.Printf("Invalid token claims for %s", r.URL.Path)
+		if len(claims.Audience) > 0 {
+			log.Printf("Token with audience %v used as access token for %s", claims.Audience, r.URL.Path)
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		sub, ok := claims["sub"].(string)
-		if !ok {
+		if claims.Subject == "" {
 			log.Printf("Missing subject in token claims for %s", r.URL.Path)
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		id, err := strconv.ParseInt(sub, 10, 64)
+		id, err := strconv.ParseInt(claims.Subject, 10, 64)
 		if err != nil {
 			log.Printf("Invalid user ID in token for %s: %v", r.URL.Path, err)
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
+		// Токены, выданные до появления отзыва, не имеют jti и действуют до истечения срока
+		if claims.ID != "" && a.denylist.IsRevoked(claims.ID) {
+			log.Printf("Revoked token %s of user %d for %s", claims.ID, id, r.URL.Path)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		accessToken := domain.AccessToken{Jti: claims.ID, UserId: domain.UserId(id), FamilyId: claims.FamilyId}
+		if claims.ExpiresAt != nil {
+			accessToken.ExpiresAt = claims.ExpiresAt.Time
+		}
 		log.Printf("Successfully authenticated user %d for %s", id, r.URL.Path)
 		ctx := context.WithValue(r.Context(), "userId", domain.UserId(id))
+		ctx = context.WithValue(ctx, "accessToken", accessToken)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// servePersonalAccessToken authenticates the request with a personal access token and checks its scope.
+func (a *Authenticator) servePersonalAccessToken(w http.ResponseWriter, r *http.Request, tokenStr string, writeScope domain.TokenScope, next http.Handler) {
+	token := a.personalTokens.GetPersonalAccessToken(HashToken(tokenStr))
+	if token == nil {
+		log.Printf("Invalid personal access token for %s", r.URL.Path)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	required := writeScope
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		required = domain.ScopeRead
+	}
+	if scopeLevels[token.Scope] < scopeLevels[required] {
+		log.Printf("Personal access token %d of user %d has scope %s, %s required for %s %s",
+			token.Id, token.UserId, token.Scope, required, r.Method, r.URL.Path)
+		http.Error(w, "insufficient scope", http.StatusForbidden)
+		return
+	}
+	log.Printf("Successfully authenticated user %d with personal access token %d for %s", token.UserId, token.Id, r.URL.Path)
+	// The request has no access token and belongs to no session, handlers tell it by the personal access token
+	ctx := context.WithValue(r.Context(), "userId", token.UserId)
+	ctx = context.WithValue(ctx, "personalAccessToken", *token)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
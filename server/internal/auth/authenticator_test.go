@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"yuki_buy_log/internal/utils"
+)
+
+func TestNewAuthenticatorSecretLength(t *testing.T) {
+	devMode := utils.DevMode
+	t.Cleanup(func() { utils.DevMode = devMode })
+	t.Setenv("JWT_KEYS_DIR", "")
+
+	tests := []struct {
+		name    string
+		secret  string
+		devMode bool
+		wantErr bool
+	}{
+		{name: "short secret", secret: "abc", wantErr: true},
+		{name: "default secret", secret: defaultSecret, wantErr: true},
+		{name: "secret of minimal length", secret: strings.Repeat("s", minSecretLength)},
+		{name: "short secret in dev mode", secret: "abc", devMode: true},
+		{name: "no secret in dev mode", devMode: true},
+		{name: "no secret", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			utils.DevMode = tt.devMode
+			t.Setenv("JWT_SECRET", tt.secret)
+
+			_, err := NewAuthenticator(nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewAuthenticator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Minimal sizes of keys loaded from files.
+const (
+	minSecretLength = 32
+	minRSAKeyBits   = 2048
+)
+
+// legacyKeyId is the id of the JWT_SECRET key. Tokens signed with it have no kid header,
+// as the tokens issued before the key set appeared.
+const legacyKeyId = ""
+
+// Key is a key of the key set. Keys without a private part (public keys of retired
+// asymmetric keys) only verify tokens.
+type Key struct {
+	Id     string
+	Method jwt.SigningMethod
+	// signKey is the HMAC secret or the private key, nil for verification-only keys
+	signKey interface{}
+	// verifyKey is the HMAC secret or the public key
+	verifyKey interface{}
+}
+
+// KeySet holds the keys access tokens are verified with and the current key new tokens
+// are signed with. To rotate keys add a new key, make it current and remove the old key
+// once the tokens signed with it have expired (AccessTokenTTL).
+type KeySet struct {
+	keys    map[string]*Key
+	current *Key
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewSecretKeySet creates a key set with a single HS256 key without a kid.
+func NewSecretKeySet(secret []byte) *KeySet {
+	key := newSecretKey(legacyKeyId, secret)
+	return &KeySet{keys: map[string]*Key{key.Id: key}, current: key}
+}
+
+// LoadKeySet loads keys from the files of dir, the file name without extension is the kid:
+//   - *.key is an HS256 secret of at least 32 bytes (a trailing newline is ignored);
+//   - *.pem is a PKCS#8, PKCS#1 or PKIX PEM key: Ed25519 keys are used with EdDSA and
+//     RSA keys with RS256. Public keys only verify tokens.
+//
+// The key currentId signs new tokens. It may be empty if the directory has only one
+// private key.
+func LoadKeySet(dir string, currentId string) (*KeySet, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key directory: %w", err)
+	}
+
+	set := &KeySet{keys: make(map[string]*Key)}
+	var signing []*Key
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".key" && ext != ".pem") {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ext)
+		if _, ok := set.keys[id]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", id)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key %q: %w", id, err)
+		}
+		var key *Key
+		if ext == ".key" {
+			key, err = parseSecretKey(id, data)
+		} else {
+			key, err = parsePEMKey(id, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		set.keys[id] = key
+		if key.signKey != nil {
+			signing = append(signing, key)
+		}
+	}
+
+	switch {
+	case currentId != "":
+		set.current = set.keys[currentId]
+		if set.current == nil || set.current.signKey == nil {
+			return nil, fmt.Errorf("signing key %q not found or has no private key", currentId)
+		}
+	case len(signing) == 1:
+		set.current = signing[0]
+	case len(signing) == 0:
+		return nil, errors.New("no signing keys found")
+	default:
+		return nil, errors.New("several signing keys found, choose the current one")
+	}
+	return set, nil
+}
+
+// addKey adds a key that only verifies tokens, e.g. the legacy JWT_SECRET key.
+func (s *KeySet) addKey(key *Key) error {
+	if _, ok := s.keys[key.Id]; ok {
+		return fmt.Errorf("duplicate key id %q", key.Id)
+	}
+	s.keys[key.Id] = key
+	return nil
+}
+
+// Current returns the key new tokens are signed with.
+func (s *KeySet) Current() *Key {
+	return s.current
+}
+
+// Methods returns the names of the algorithms of all keys, tokens signed with other
+// algorithms are rejected.
+func (s *KeySet) Methods() []string {
+	seen := make(map[string]bool)
+	var methods []string
+	for _, key := range s.keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// sign signs the claims with the current key and sets the kid header.
+func (s *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.current.Method, claims)
+	if s.current.Id != legacyKeyId {
+		token.Header["kid"] = s.current.Id
+	}
+	return token.SignedString(s.current.signKey)
+}
+
+// keyFunc finds the verification key by the kid header. The algorithm of the token must
+// be the algorithm of the key, otherwise e.g. a public RSA key could be used as an HMAC secret.
+func (s *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	id := legacyKeyId
+	if kid, ok := token.Header["kid"]; ok {
+		id, ok = kid.(string)
+		if !ok || id == legacyKeyId {
+			return nil, errors.New("invalid kid header")
+		}
+	}
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), id)
+	}
+	return key.verifyKey, nil
+}
+
+// JWKS returns the public keys of the asymmetric keys. HMAC secrets are never published.
+func (s *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range s.keys {
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.Id,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.Id,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// newSecretKey creates an HS256 key.
+func newSecretKey(id string, secret []byte) *Key {
+	return &Key{Id: id, Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+}
+
+func parseSecretKey(id string, data []byte) (*Key, error) {
+	secret := []byte(strings.TrimRight(string(data), "\r\n"))
+	if len(secret) < minSecretLength {
+		return nil, fmt.Errorf("secret %q is shorter than %d bytes", id, minSecretLength)
+	}
+	return newSecretKey(id, secret), nil
+}
+
+func parsePEMKey(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %q is not a PEM file", id)
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("key %q has unsupported PEM type %q", id, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key %q: %w", id, err)
+	}
+
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		return &Key{Id: id, Method: jwt.SigningMethodEdDSA, signKey: k, verifyKey: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{Id: id, Method: jwt.SigningMethodEdDSA, verifyKey: k}, nil
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA key %q is shorter than %d bits", id, minRSAKeyBits)
+		}
+		return &Key{Id: id, Method: jwt.SigningMethodRS256, signKey: k, verifyKey: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA key %q is shorter than %d bits", id, minRSAKeyBits)
+		}
+		return &Key{Id: id, Method: jwt.SigningMethodRS256, verifyKey: k}, nil
+	default:
+		return nil, fmt.Errorf("key %q has unsupported type %T", id, parsed)
+	}
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// PersonalAccessTokenPrefix starts every personal access token, so that the middleware can tell
+// it from a JWT and secret scanners can find leaked tokens.
+const PersonalAccessTokenPrefix = "ybl_pat_"
+
+// NewOpaqueToken generates a random URL-safe token and its hash.
+// Only the hash should be stored, the token itself is shown to the user once.
+func NewOpaqueToken() (token string, tokenHash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex encoded SHA-256 hash of an opaque token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewPersonalAccessToken generates a personal access token and its hash.
+func NewPersonalAccessToken() (token string, tokenHash string, err error) {
+	token, _, err = NewOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	token = PersonalAccessTokenPrefix + token
+	return token, HashToken(token), nil
+}
@@ -2,10 +2,26 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"sync"
 	"time"
 	"yuki_buy_log/internal/utils"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrUniqueViolation возвращается, когда запись нарушает ограничение уникальности
+	ErrUniqueViolation = errors.New("unique violation")
+	// ErrForeignKeyViolation возвращается, когда на удаляемую запись еще ссылаются другие таблицы
+	ErrForeignKeyViolation = errors.New("foreign key violation")
+)
+
+// Коды ошибок PostgreSQL
+const (
+	uniqueViolationCode     = "23505"
+	foreignKeyViolationCode = "23503"
 )
 
 type DatabaseManager struct {
@@ -48,3 +64,18 @@ func (d *DatabaseManager) Close() {
 		d.db.Close()
 	}
 }
+
+// queryRower позволяет выполнять одни и те же запросы как через *sql.DB, так и внутри *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolationCode
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolationCode
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"yuki_buy_log/internal/domain"
+)
+
+func (d *DatabaseManager) GetAllExchangeRates() ([]domain.ExchangeRate, error) {
+	rows, err := d.db.Query(`SELECT date, currency, rate FROM exchange_rates ORDER BY currency, date`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all exchange rates: %w", err)
+	}
+	defer rows.Close()
+
+	var rates []domain.ExchangeRate
+	for rows.Next() {
+		var rate domain.ExchangeRate
+		if err := rows.Scan(&rate.Date, &rate.Currency, &rate.Rate); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
+
+// UpsertExchangeRates сохраняет курсы в одной транзакции, курс на ту же дату перезаписывается
+func (d *DatabaseManager) UpsertExchangeRates(rates []domain.ExchangeRate) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO exchange_rates (date, currency, rate) VALUES ($1, $2, $3)
+		ON CONFLICT (date, currency) DO UPDATE SET rate = EXCLUDED.rate`)
+	if err != nil {
+		log.Printf("Failed to prepare exchange rates upsert: %v", err)
+		return err
+	}
+	defer stmt.Close()
+
+	for _, rate := range rates {
+		if _, err := stmt.Exec(rate.Date, rate.Currency, rate.Rate); err != nil {
+			log.Printf("Failed to upsert exchange rate %s on %s: %v", rate.Currency, rate.Date.Format("2006-01-02"), err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+	"yuki_buy_log/internal/domain"
+)
+
+func (d *DatabaseManager) GetAllInviteLinks() ([]domain.InviteLink, error) {
+	rows, err := d.db.Query(`SELECT id, user_id, token_hash, max_uses, uses, expires_at, created_at FROM invite_links`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all invite links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []domain.InviteLink
+	for rows.Next() {
+		var l domain.InviteLink
+		err := rows.Scan(&l.Id, &l.UserId, &l.TokenHash, &l.MaxUses, &l.Uses, &l.ExpiresAt, &l.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		links = append(links, l)
+	}
+	return links, nil
+}
+
+func (d *DatabaseManager) CreateInviteLink(link *domain.InviteLink) error {
+	err := d.db.QueryRow(`INSERT INTO invite_links (user_id, token_hash, max_uses, expires_at) VALUES ($1,$2,$3,$4) RETURNING id, created_at`,
+		link.UserId, link.TokenHash, link.MaxUses, link.ExpiresAt).Scan(&link.Id, &link.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to insert invite link: %v", err)
+		return err
+	}
+	return nil
+}
+
+// UseInviteLink атомарно увеличивает счетчик использований ссылки.
+// Возвращает новое значение счетчика или sql.ErrNoRows, если ссылка истекла или исчерпана.
+func (d *DatabaseManager) UseInviteLink(id domain.InviteLinkId, now time.Time) (uses int, err error) {
+	err = d.db.QueryRow(`UPDATE invite_links SET uses = uses + 1 WHERE id = $1 AND uses < max_uses AND expires_at > $2 RETURNING uses`,
+		id, now).Scan(&uses)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to use invite link %d: %v", id, err)
+	}
+	return uses, err
+}
+
+// ReleaseInviteLinkUse возвращает использование ссылки, если вступление в группу не удалось
+func (d *DatabaseManager) ReleaseInviteLinkUse(id domain.InviteLinkId) (uses int, err error) {
+	err = d.db.QueryRow(`UPDATE invite_links SET uses = uses - 1 WHERE id = $1 AND uses > 0 RETURNING uses`, id).Scan(&uses)
+	if err != nil {
+		log.Printf("Failed to release invite link %d: %v", id, err)
+	}
+	return uses, err
+}
+
+func (d *DatabaseManager) DeleteInviteLink(id domain.InviteLinkId, userId domain.UserId) error {
+	result, err := d.db.Exec(`DELETE FROM invite_links WHERE id = $1 AND user_id = $2`, id, userId)
+	if err != nil {
+		log.Printf("Failed to delete invite link: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to check rows affected: %v", err)
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("invite link with id %d not found for user %d", id, userId)
+	}
+
+	return nil
+}
+
+// DeleteExpiredInviteLinks удаляет истекшие и полностью использованные ссылки
+func (d *DatabaseManager) DeleteExpiredInviteLinks(now time.Time) (int64, error) {
+	result, err := d.db.Exec(`DELETE FROM invite_links WHERE expires_at <= $1 OR uses >= max_uses`, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
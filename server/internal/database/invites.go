@@ -2,6 +2,8 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
+	"log"
 	"time"
 	"yuki_buy_log/internal/domain"
 )
@@ -79,6 +81,26 @@ func (d *DatabaseManager) DeleteInvitesBetweenUsers(userId1, userId2 domain.User
 	return err
 }
 
+func (d *DatabaseManager) DeleteInvite(id domain.InviteId) error {
+	result, err := d.db.Exec(`DELETE FROM invites WHERE id = $1`, id)
+	if err != nil {
+		log.Printf("Failed to delete invite: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to check rows affected: %v", err)
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("invite with id %d not found", id)
+	}
+
+	return nil
+}
+
 func (d *DatabaseManager) GetAllInvites() ([]domain.Invite, error) {
 	rows, err := d.db.Query(`
 		SELECT i.id, i.from_user_id, i.to_user_id, u_from.login, u_to.login, i.created_at
@@ -93,10 +115,19 @@ func (d *DatabaseManager) GetAllInvites() ([]domain.Invite, error) {
 	return scanRowsToInvites(rows)
 }
 
-func (d *DatabaseManager) CreateInvite(fromUserId, toUserId domain.UserId) (domain.InviteId, error) {
-	var inviteId domain.InviteId
-	err := d.db.QueryRow(`INSERT INTO invites (from_user_id, to_user_id) VALUES ($1, $2) RETURNING id`, fromUserId, toUserId).Scan(&inviteId)
-	return inviteId, err
+// CreateInvite сохраняет инвайт и проставляет в него выданные БД id и created_at.
+// Если такой инвайт уже есть, возвращает ErrUniqueViolation.
+func (d *DatabaseManager) CreateInvite(invite *domain.Invite) error {
+	err := d.db.QueryRow(`INSERT INTO invites (from_user_id, to_user_id) VALUES ($1, $2) RETURNING id, created_at`,
+		invite.FromUserId, invite.ToUserId).Scan(&invite.Id, &invite.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("invite from user %d to user %d: %w", invite.FromUserId, invite.ToUserId, ErrUniqueViolation)
+		}
+		log.Printf("Failed to insert invite: %v", err)
+		return err
+	}
+	return nil
 }
 
 func (d *DatabaseManager) DeleteOldInvites(cutoffTime time.Time) (int64, error) {
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"yuki_buy_log/internal/domain"
+)
+
+func (d *DatabaseManager) GetAllPersonalAccessTokens() ([]domain.PersonalAccessToken, error) {
+	rows, err := d.db.Query(`SELECT id, user_id, name, token_hash, scope, created_at FROM personal_access_tokens`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all personal access tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []domain.PersonalAccessToken
+	for rows.Next() {
+		var t domain.PersonalAccessToken
+		err := rows.Scan(&t.Id, &t.UserId, &t.Name, &t.TokenHash, &t.Scope, &t.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, nil
+}
+
+func (d *DatabaseManager) CreatePersonalAccessToken(token *domain.PersonalAccessToken) error {
+	err := d.db.QueryRow(`INSERT INTO personal_access_tokens (user_id, name, token_hash, scope) VALUES ($1,$2,$3,$4) RETURNING id, created_at`,
+		token.UserId, token.Name, token.TokenHash, token.Scope).Scan(&token.Id, &token.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to insert personal access token: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (d *DatabaseManager) DeletePersonalAccessToken(id domain.PersonalAccessTokenId, userId domain.UserId) error {
+	result, err := d.db.Exec(`DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2`, id, userId)
+	if err != nil {
+		log.Printf("Failed to delete personal access token: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to check rows affected: %v", err)
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("personal access token with id %d not found for user %d", id, userId)
+	}
+
+	return nil
+}
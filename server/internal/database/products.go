@@ -5,10 +5,12 @@ import (
 	"log"
 	"strings"
 	"yuki_buy_log/internal/domain"
+
+	"github.com/lib/pq"
 )
 
 func (d *DatabaseManager) GetAllProducts() ([]domain.Product, error) {
-	rows, err := d.db.Query(`SELECT id, name, volume, brand, default_tags, user_id FROM products`)
+	rows, err := d.db.Query(`SELECT id, name, volume, brand, default_tags, COALESCE(user_id, 0), unit_amount, unit FROM products`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get all products: %w", err)
 	}
@@ -18,7 +20,7 @@ func (d *DatabaseManager) GetAllProducts() ([]domain.Product, error) {
 	for rows.Next() {
 		var p domain.Product
 		var defaultTagsStr string
-		err := rows.Scan(&p.Id, &p.Name, &p.Volume, &p.Brand, &defaultTagsStr, &p.UserId)
+		err := rows.Scan(&p.Id, &p.Name, &p.Volume, &p.Brand, &defaultTagsStr, &p.UserId, &p.UnitAmount, &p.Unit)
 		if err != nil {
 			return nil, fmt.Errorf("scan error: %w", err)
 		}
@@ -35,8 +37,8 @@ func (d *DatabaseManager) GetAllProducts() ([]domain.Product, error) {
 func (d *DatabaseManager) GetProductById(id domain.ProductId) (*domain.Product, error) {
 	var p domain.Product
 	var defaultTagsStr string
-	err := d.db.QueryRow(`SELECT id, name, volume, brand, default_tags, user_id FROM products WHERE id = $1`, id).
-		Scan(&p.Id, &p.Name, &p.Volume, &p.Brand, &defaultTagsStr, &p.UserId)
+	err := d.db.QueryRow(`SELECT id, name, volume, brand, default_tags, COALESCE(user_id, 0), unit_amount, unit FROM products WHERE id = $1`, id).
+		Scan(&p.Id, &p.Name, &p.Volume, &p.Brand, &defaultTagsStr, &p.UserId, &p.UnitAmount, &p.Unit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find product with id %d: %w", id, err)
 	}
@@ -48,10 +50,15 @@ func (d *DatabaseManager) GetProductById(id domain.ProductId) (*domain.Product,
 	return &p, nil
 }
 
-func (d *DatabaseManager) CreateProduct(product *domain.Product) error {
+// insertProduct добавляет продукт, id проставляется в переданную структуру
+func insertProduct(q queryRower, product *domain.Product) error {
 	defaultTagsStr := strings.Join(product.DefaultTags, ",")
-	err := d.db.QueryRow(`INSERT INTO products (name, volume, brand, default_tags, user_id) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
-		product.Name, product.Volume, product.Brand, defaultTagsStr, product.UserId).Scan(&product.Id)
+	return q.QueryRow(`INSERT INTO products (name, volume, brand, default_tags, user_id, unit_amount, unit) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		product.Name, product.Volume, product.Brand, defaultTagsStr, product.UserId, product.UnitAmount, product.Unit).Scan(&product.Id)
+}
+
+func (d *DatabaseManager) CreateProduct(product *domain.Product) error {
+	err := insertProduct(d.db, product)
 	if err != nil {
 		log.Printf("Failed to insert product: %v", err)
 		return err
@@ -61,8 +68,8 @@ func (d *DatabaseManager) CreateProduct(product *domain.Product) error {
 
 func (d *DatabaseManager) UpdateProduct(product *domain.Product) error {
 	defaultTagsStr := strings.Join(product.DefaultTags, ",")
-	result, err := d.db.Exec(`UPDATE products SET name=$1, volume=$2, brand=$3, default_tags=$4 WHERE id=$5 AND user_id=$6`,
-		product.Name, product.Volume, product.Brand, defaultTagsStr, product.Id, product.UserId)
+	result, err := d.db.Exec(`UPDATE products SET name=$1, volume=$2, brand=$3, default_tags=$4, unit_amount=$5, unit=$6 WHERE id=$7 AND user_id=$8`,
+		product.Name, product.Volume, product.Brand, defaultTagsStr, product.UnitAmount, product.Unit, product.Id, product.UserId)
 	if err != nil {
 		log.Printf("Failed to update product: %v", err)
 		return err
@@ -84,6 +91,9 @@ func (d *DatabaseManager) UpdateProduct(product *domain.Product) error {
 func (d *DatabaseManager) DeleteProduct(id domain.ProductId, userId domain.UserId) error {
 	result, err := d.db.Exec(`DELETE FROM products WHERE id = $1 AND user_id = $2`, id, userId)
 	if err != nil {
+		if isForeignKeyViolation(err) {
+			return fmt.Errorf("product %d is used by purchases: %w", id, ErrForeignKeyViolation)
+		}
 		log.Printf("Failed to delete product: %v", err)
 		return err
 	}
@@ -100,3 +110,91 @@ func (d *DatabaseManager) DeleteProduct(id domain.ProductId, userId domain.UserI
 
 	return nil
 }
+
+// ReassignPurchasesAndDeleteProduct переносит все покупки продукта на другой продукт
+// и удаляет продукт в одной транзакции. Возвращает количество перенесенных покупок.
+func (d *DatabaseManager) ReassignPurchasesAndDeleteProduct(id domain.ProductId, targetId domain.ProductId, userId domain.UserId) (int64, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE purchases SET product_id = $1 WHERE product_id = $2`, targetId, id)
+	if err != nil {
+		log.Printf("Failed to reassign purchases from product %d to %d: %v", id, targetId, err)
+		return 0, err
+	}
+
+	moved, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to check rows affected: %v", err)
+		return 0, err
+	}
+
+	result, err = tx.Exec(`DELETE FROM products WHERE id = $1 AND user_id = $2`, id, userId)
+	if err != nil {
+		log.Printf("Failed to delete product: %v", err)
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to check rows affected: %v", err)
+		return 0, err
+	}
+
+	if rowsAffected == 0 {
+		return 0, fmt.Errorf("product with id %d not found for user %d", id, userId)
+	}
+
+	return moved, tx.Commit()
+}
+
+// MergeProducts переносит покупки объединяемых продуктов на survivor, сохраняет его теги по умолчанию
+// и удаляет объединенные продукты в одной транзакции. Возвращает количество перенесенных покупок.
+func (d *DatabaseManager) MergeProducts(survivor *domain.Product, mergedIds []domain.ProductId) (int64, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE purchases SET product_id = $1 WHERE product_id = ANY($2)`, survivor.Id, pq.Array(mergedIds))
+	if err != nil {
+		log.Printf("Failed to reassign purchases to product %d: %v", survivor.Id, err)
+		return 0, err
+	}
+
+	moved, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to check rows affected: %v", err)
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE products SET default_tags = $1 WHERE id = $2`, strings.Join(survivor.DefaultTags, ","), survivor.Id)
+	if err != nil {
+		log.Printf("Failed to update default tags of product %d: %v", survivor.Id, err)
+		return 0, err
+	}
+
+	result, err = tx.Exec(`DELETE FROM products WHERE id = ANY($1)`, pq.Array(mergedIds))
+	if err != nil {
+		log.Printf("Failed to delete merged products: %v", err)
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to check rows affected: %v", err)
+		return 0, err
+	}
+
+	if rowsAffected != int64(len(mergedIds)) {
+		return 0, fmt.Errorf("merged products not found: deleted %d of %d", rowsAffected, len(mergedIds))
+	}
+
+	return moved, tx.Commit()
+}
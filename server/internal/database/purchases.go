@@ -8,7 +8,7 @@ import (
 )
 
 func (d *DatabaseManager) GetAllPurchases() ([]domain.Purchase, error) {
-	rows, err := d.db.Query(`SELECT id, product_id, quantity, price, date, store, tags, receipt_id, user_id FROM purchases`)
+	rows, err := d.db.Query(`SELECT id, product_id, quantity, quantity_unit, price, currency, regular_price, discount, promo_type, date, store, tags, COALESCE(receipt_id, 0), COALESCE(user_id, 0) FROM purchases`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get all purchases: %w", err)
 	}
@@ -17,7 +17,7 @@ func (d *DatabaseManager) GetAllPurchases() ([]domain.Purchase, error) {
 	var purchases []domain.Purchase
 	for rows.Next() {
 		var p domain.Purchase
-		err := rows.Scan(&p.Id, &p.ProductId, &p.Quantity, &p.Price, &p.Date, &p.Store, pq.Array(&p.Tags), &p.ReceiptId, &p.UserId)
+		err := rows.Scan(&p.Id, &p.ProductId, &p.Quantity, &p.QuantityUnit, &p.Price, &p.Currency, &p.RegularPrice, &p.Discount, &p.PromoType, &p.Date, &p.Store, pq.Array(&p.Tags), &p.ReceiptId, &p.UserId)
 		if err != nil {
 			return nil, fmt.Errorf("scan error: %w", err)
 		}
@@ -31,7 +31,7 @@ func (d *DatabaseManager) GetPurchasesByUserIds(userIds []domain.UserId) ([]doma
 		return []domain.Purchase{}, nil
 	}
 
-	rows, err := d.db.Query(`SELECT id, product_id, quantity, price, date, store, tags, receipt_id, user_id FROM purchases WHERE user_id = ANY($1)`, pq.Array(userIds))
+	rows, err := d.db.Query(`SELECT id, product_id, quantity, quantity_unit, price, currency, regular_price, discount, promo_type, date, store, tags, COALESCE(receipt_id, 0), user_id FROM purchases WHERE user_id = ANY($1)`, pq.Array(userIds))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get purchases for users: %w", err)
 	}
@@ -40,7 +40,7 @@ func (d *DatabaseManager) GetPurchasesByUserIds(userIds []domain.UserId) ([]doma
 	var purchases []domain.Purchase
 	for rows.Next() {
 		var p domain.Purchase
-		err := rows.Scan(&p.Id, &p.ProductId, &p.Quantity, &p.Price, &p.Date, &p.Store, pq.Array(&p.Tags), &p.ReceiptId, &p.UserId)
+		err := rows.Scan(&p.Id, &p.ProductId, &p.Quantity, &p.QuantityUnit, &p.Price, &p.Currency, &p.RegularPrice, &p.Discount, &p.PromoType, &p.Date, &p.Store, pq.Array(&p.Tags), &p.ReceiptId, &p.UserId)
 		if err != nil {
 			return nil, fmt.Errorf("scan error: %w", err)
 		}
@@ -49,9 +49,14 @@ func (d *DatabaseManager) GetPurchasesByUserIds(userIds []domain.UserId) ([]doma
 	return purchases, nil
 }
 
+// insertPurchase добавляет покупку, receipt_id = 0 сохраняется как NULL (покупка без чека)
+func insertPurchase(q queryRower, purchase *domain.Purchase) error {
+	return q.QueryRow(`INSERT INTO purchases (product_id, quantity, quantity_unit, price, currency, regular_price, discount, promo_type, date, store, tags, receipt_id, user_id) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,NULLIF($12, 0),$13) RETURNING id`,
+		purchase.ProductId, purchase.Quantity, purchase.QuantityUnit, purchase.Price, purchase.Currency, purchase.RegularPrice, purchase.Discount, purchase.PromoType, purchase.Date, purchase.Store, pq.Array(purchase.Tags), purchase.ReceiptId, purchase.UserId).Scan(&purchase.Id)
+}
+
 func (d *DatabaseManager) AddPurchase(purchase *domain.Purchase) error {
-	err := d.db.QueryRow(`INSERT INTO purchases (product_id, quantity, price, date, store, tags, receipt_id, user_id) VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING id`,
-		purchase.ProductId, purchase.Quantity, purchase.Price, purchase.Date, purchase.Store, pq.Array(purchase.Tags), purchase.ReceiptId, purchase.UserId).Scan(&purchase.Id)
+	err := insertPurchase(d.db, purchase)
 	if err != nil {
 		log.Printf("Failed to insert purchase: %v", err)
 		return err
@@ -59,6 +64,68 @@ func (d *DatabaseManager) AddPurchase(purchase *domain.Purchase) error {
 	return nil
 }
 
+// ImportPurchases создает импортированные покупки без чека вместе с новыми продуктами в одной транзакции:
+// либо создаются все покупки, либо ни одной. newProducts[i] - новый продукт покупки purchases[i]
+// или nil для существующего продукта; один новый продукт может быть у нескольких покупок.
+func (d *DatabaseManager) ImportPurchases(purchases []domain.Purchase, newProducts []*domain.Product) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertNewProducts(tx, purchases, newProducts); err != nil {
+		return err
+	}
+	for i := range purchases {
+		if err := insertPurchase(tx, &purchases[i]); err != nil {
+			log.Printf("Failed to insert imported purchase %d: %v", i, err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// insertNewProducts добавляет новые продукты импортированных покупок и проставляет их id в покупки.
+// Продукт, у которого уже есть id, повторно не добавляется
+func insertNewProducts(q queryRower, purchases []domain.Purchase, newProducts []*domain.Product) error {
+	for i, product := range newProducts {
+		if product == nil {
+			continue
+		}
+		if product.Id == 0 {
+			if err := insertProduct(q, product); err != nil {
+				log.Printf("Failed to insert product of imported purchase: %v", err)
+				return err
+			}
+		}
+		purchases[i].ProductId = product.Id
+	}
+	return nil
+}
+
+func (d *DatabaseManager) UpdatePurchase(purchase *domain.Purchase) error {
+	result, err := d.db.Exec(`UPDATE purchases SET product_id=$1, quantity=$2, quantity_unit=$3, price=$4, currency=$5, regular_price=$6, discount=$7, promo_type=$8, date=$9, store=$10, tags=$11, receipt_id=NULLIF($12, 0) WHERE id=$13 AND user_id=$14`,
+		purchase.ProductId, purchase.Quantity, purchase.QuantityUnit, purchase.Price, purchase.Currency, purchase.RegularPrice, purchase.Discount, purchase.PromoType, purchase.Date, purchase.Store, pq.Array(purchase.Tags), purchase.ReceiptId, purchase.Id, purchase.UserId)
+	if err != nil {
+		log.Printf("Failed to update purchase: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to check rows affected: %v", err)
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("purchase with id %d not found for user %d", purchase.Id, purchase.UserId)
+	}
+
+	return nil
+}
+
 func (d *DatabaseManager) DeletePurchase(purchaseId domain.PurchaseId, userId domain.UserId) error {
 	result, err := d.db.Exec(`DELETE FROM purchases WHERE id = $1 AND user_id = $2`, purchaseId, userId)
 	if err != nil {
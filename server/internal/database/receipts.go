@@ -0,0 +1,149 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"log"
+	"yuki_buy_log/internal/domain"
+)
+
+func (d *DatabaseManager) GetAllReceipts() ([]domain.Receipt, error) {
+	rows, err := d.db.Query(`SELECT id, date, store, tags, user_id, fiscal_id FROM receipts`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all receipts: %w", err)
+	}
+	defer rows.Close()
+
+	var receipts []domain.Receipt
+	for rows.Next() {
+		var r domain.Receipt
+		err := rows.Scan(&r.Id, &r.Date, &r.Store, pq.Array(&r.CommonTags), &r.UserId, &r.FiscalId)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		if r.CommonTags == nil {
+			r.CommonTags = []string{}
+		}
+		receipts = append(receipts, r)
+	}
+	return receipts, nil
+}
+
+// CreateReceipt создает чек вместе со всеми его покупками в одной транзакции.
+// Id чека и покупок проставляются в переданные структуры.
+func (d *DatabaseManager) CreateReceipt(receipt *domain.Receipt, purchases []domain.Purchase) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertReceipt(tx, receipt, purchases); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ImportReceipt создает импортированный чек вместе с покупками и новыми продуктами в одной транзакции.
+// newProducts[i] - новый продукт покупки purchases[i] или nil для существующего продукта; один новый
+// продукт может быть у нескольких покупок. Если чек с таким фискальным id уже есть, возвращает ErrUniqueViolation.
+func (d *DatabaseManager) ImportReceipt(receipt *domain.Receipt, purchases []domain.Purchase, newProducts []*domain.Product) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertNewProducts(tx, purchases, newProducts); err != nil {
+		return err
+	}
+
+	if err := insertReceipt(tx, receipt, purchases); err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("receipt %s: %w", receipt.FiscalId, ErrUniqueViolation)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertReceipt добавляет чек и его покупки, id проставляются в переданные структуры
+func insertReceipt(tx *sql.Tx, receipt *domain.Receipt, purchases []domain.Purchase) error {
+	err := tx.QueryRow(`INSERT INTO receipts (date, store, tags, user_id, fiscal_id) VALUES ($1,$2,$3,$4,$5) RETURNING id`,
+		receipt.Date, receipt.Store, pq.Array(receipt.CommonTags), receipt.UserId, receipt.FiscalId).Scan(&receipt.Id)
+	if err != nil {
+		log.Printf("Failed to insert receipt: %v", err)
+		return err
+	}
+
+	for i := range purchases {
+		purchases[i].ReceiptId = receipt.Id
+		if err := insertPurchase(tx, &purchases[i]); err != nil {
+			log.Printf("Failed to insert purchase for receipt %d: %v", receipt.Id, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateReceipt обновляет чек и переносит дату, магазин и теги в его покупки в одной транзакции
+func (d *DatabaseManager) UpdateReceipt(receipt *domain.Receipt, purchases []domain.Purchase) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE receipts SET date=$1, store=$2, tags=$3 WHERE id=$4 AND user_id=$5`,
+		receipt.Date, receipt.Store, pq.Array(receipt.CommonTags), receipt.Id, receipt.UserId)
+	if err != nil {
+		log.Printf("Failed to update receipt: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to check rows affected: %v", err)
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("receipt with id %d not found for user %d", receipt.Id, receipt.UserId)
+	}
+
+	for _, p := range purchases {
+		_, err := tx.Exec(`UPDATE purchases SET date=$1, store=$2, tags=$3 WHERE id=$4 AND receipt_id=$5`,
+			p.Date, p.Store, pq.Array(p.Tags), p.Id, receipt.Id)
+		if err != nil {
+			log.Printf("Failed to update purchase %d of receipt %d: %v", p.Id, receipt.Id, err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteReceipt удаляет чек, покупки удаляются каскадно
+func (d *DatabaseManager) DeleteReceipt(id domain.ReceiptId, userId domain.UserId) error {
+	result, err := d.db.Exec(`DELETE FROM receipts WHERE id = $1 AND user_id = $2`, id, userId)
+	if err != nil {
+		log.Printf("Failed to delete receipt: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Failed to check rows affected: %v", err)
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("receipt with id %d not found for user %d", id, userId)
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+	"yuki_buy_log/internal/domain"
+)
+
+func (d *DatabaseManager) CreateTokenFamily(family *domain.TokenFamily) error {
+	err := d.db.QueryRow(`INSERT INTO token_families (user_id, user_agent, ip, created_at, last_seen_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		family.UserId, family.UserAgent, family.Ip, family.CreatedAt, family.LastSeenAt).Scan(&family.Id)
+	if err != nil {
+		log.Printf("Failed to insert token family: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (d *DatabaseManager) GetTokenFamily(familyId domain.TokenFamilyId) (family domain.TokenFamily, err error) {
+	err = d.db.QueryRow(`SELECT id, user_id, user_agent, ip, created_at, last_seen_at, revoked_at FROM token_families WHERE id = $1`, familyId).
+		Scan(&family.Id, &family.UserId, &family.UserAgent, &family.Ip, &family.CreatedAt, &family.LastSeenAt, &family.RevokedAt)
+	return family, err
+}
+
+// GetActiveTokenFamilies возвращает неотозванные семейства пользователя, у которых есть неиспользованный
+// и не истекший токен обновления, то есть сессии, которые еще можно продолжить
+func (d *DatabaseManager) GetActiveTokenFamilies(userId domain.UserId, now time.Time) ([]domain.TokenFamily, error) {
+	rows, err := d.db.Query(`
+		SELECT f.id, f.user_id, f.user_agent, f.ip, f.created_at, f.last_seen_at FROM token_families f
+		WHERE f.user_id = $1 AND f.revoked_at IS NULL AND EXISTS (
+			SELECT 1 FROM refresh_tokens r WHERE r.family_id = f.id AND r.used_at IS NULL AND r.expires_at > $2)
+		ORDER BY f.last_seen_at DESC, f.id DESC`, userId, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token families: %w", err)
+	}
+	defer rows.Close()
+
+	var families []domain.TokenFamily
+	for rows.Next() {
+		var family domain.TokenFamily
+		if err := rows.Scan(&family.Id, &family.UserId, &family.UserAgent, &family.Ip, &family.CreatedAt, &family.LastSeenAt); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		families = append(families, family)
+	}
+	return families, nil
+}
+
+func (d *DatabaseManager) CreateRefreshToken(token *domain.RefreshToken) error {
+	_, err := d.db.Exec(`INSERT INTO refresh_tokens (family_id, token_hash, expires_at, access_jti, access_expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		token.FamilyId, token.TokenHash, token.ExpiresAt, token.AccessJti, token.AccessExpiresAt)
+	if err != nil {
+		log.Printf("Failed to insert refresh token: %v", err)
+		return err
+	}
+	return nil
+}
+
+// UseRefreshToken атомарно помечает токен обновления использованным, чтобы параллельные запросы
+// не получили два новых токена, и запоминает в семействе время обновления и устройство, с которого оно было.
+// Возвращает sql.ErrNoRows, если токен не найден, уже использован, истек или его семейство отозвано.
+func (d *DatabaseManager) UseRefreshToken(tokenHash string, userAgent string, ip string, now time.Time) (token domain.RefreshToken, err error) {
+	err = d.db.QueryRow(`
+		WITH used AS (
+			UPDATE refresh_tokens r SET used_at = $2
+			FROM token_families f
+			WHERE r.family_id = f.id AND r.token_hash = $1 AND r.used_at IS NULL AND r.expires_at > $2 AND f.revoked_at IS NULL
+			RETURNING r.family_id, f.user_id, r.token_hash, r.expires_at, r.access_jti, r.access_expires_at
+		), touched AS (
+			UPDATE token_families SET user_agent = $3, ip = $4, last_seen_at = $2
+			WHERE id IN (SELECT family_id FROM used)
+		)
+		SELECT family_id, user_id, token_hash, expires_at, access_jti, access_expires_at FROM used`, tokenHash, now, userAgent, ip).
+		Scan(&token.FamilyId, &token.UserId, &token.TokenHash, &token.ExpiresAt, &token.AccessJti, &token.AccessExpiresAt)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to use refresh token: %v", err)
+	}
+	return token, err
+}
+
+// GetReusedTokenFamily возвращает семейство уже использованного, но еще не истекшего токена обновления
+// из неотозванного семейства. Повторное использование означает, что токен украден.
+func (d *DatabaseManager) GetReusedTokenFamily(tokenHash string, now time.Time) (familyId domain.TokenFamilyId, err error) {
+	err = d.db.QueryRow(`
+		SELECT r.family_id FROM refresh_tokens r
+		JOIN token_families f ON r.family_id = f.id
+		WHERE r.token_hash = $1 AND r.used_at IS NOT NULL AND r.expires_at > $2 AND f.revoked_at IS NULL`, tokenHash, now).
+		Scan(&familyId)
+	return familyId, err
+}
+
+// RevokeTokenFamily отзывает семейство токенов обновления и все еще действующие access-токены,
+// выданные вместе с ними. Возвращает добавленные в revoked_tokens токены.
+func (d *DatabaseManager) RevokeTokenFamily(familyId domain.TokenFamilyId, now time.Time) ([]domain.RevokedToken, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE token_families SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL`, familyId, now); err != nil {
+		log.Printf("Failed to revoke token family %d: %v", familyId, err)
+		return nil, err
+	}
+	rows, err := tx.Query(`
+		INSERT INTO revoked_tokens (jti, expires_at)
+		SELECT access_jti, access_expires_at FROM refresh_tokens WHERE family_id = $1 AND access_expires_at > $2
+		ON CONFLICT (jti) DO NOTHING
+		RETURNING jti, expires_at`, familyId, now)
+	if err != nil {
+		log.Printf("Failed to revoke access tokens of family %d: %v", familyId, err)
+		return nil, err
+	}
+	var revoked []domain.RevokedToken
+	for rows.Next() {
+		var token domain.RevokedToken
+		if err := rows.Scan(&token.Jti, &token.ExpiresAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		revoked = append(revoked, token)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revoked, tx.Commit()
+}
+
+func (d *DatabaseManager) RevokeToken(token domain.RevokedToken) error {
+	_, err := d.db.Exec(`INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`, token.Jti, token.ExpiresAt)
+	if err != nil {
+		log.Printf("Failed to revoke token: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (d *DatabaseManager) GetAllRevokedTokens() ([]domain.RevokedToken, error) {
+	rows, err := d.db.Query(`SELECT jti, expires_at FROM revoked_tokens`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revoked tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []domain.RevokedToken
+	for rows.Next() {
+		var token domain.RevokedToken
+		if err := rows.Scan(&token.Jti, &token.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// DeleteExpiredTokens удаляет истекшие токены обновления, семейства без действующих токенов
+// и истекшие отозванные access-токены. Возвращает количество удаленных записей.
+func (d *DatabaseManager) DeleteExpiredTokens(now time.Time) (int64, error) {
+	statements := []string{
+		`DELETE FROM refresh_tokens WHERE expires_at <= $1`,
+		// Первый токен семейства сохраняется сразу после семейства, поэтому новые семейства не трогаем
+		`DELETE FROM token_families f WHERE f.created_at < $1::timestamp - INTERVAL '1 hour'
+			AND NOT EXISTS (SELECT 1 FROM refresh_tokens r WHERE r.family_id = f.id)`,
+		`DELETE FROM revoked_tokens WHERE expires_at <= $1`,
+	}
+	var total int64
+	for _, statement := range statements {
+		result, err := d.db.Exec(statement, now)
+		if err != nil {
+			log.Printf("Failed to delete expired tokens: %v", err)
+			return total, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += rowsAffected
+	}
+	return total, nil
+}
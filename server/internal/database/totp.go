@@ -0,0 +1,158 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+	"yuki_buy_log/internal/domain"
+)
+
+func (d *DatabaseManager) GetUserTotp(userId domain.UserId) (totp domain.UserTotp, err error) {
+	err = d.db.QueryRow(`SELECT user_id, secret, enabled, last_used_step, failed_attempts, locked_until FROM user_totp WHERE user_id = $1`, userId).
+		Scan(&totp.UserId, &totp.Secret, &totp.Enabled, &totp.LastUsedStep, &totp.FailedAttempts, &totp.LockedUntil)
+	return totp, err
+}
+
+// SetupUserTotp сохраняет новый неподтвержденный секрет. Возвращает sql.ErrNoRows, если
+// двухфакторная аутентификация уже включена
+func (d *DatabaseManager) SetupUserTotp(userId domain.UserId, secret string, now time.Time) error {
+	var id domain.UserId
+	err := d.db.QueryRow(`
+		INSERT INTO user_totp (user_id, secret, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET secret = $2, created_at = $3, last_used_step = 0, failed_attempts = 0, locked_until = NULL
+		WHERE NOT user_totp.enabled
+		RETURNING user_id`, userId, secret, now).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to setup TOTP for user %d: %v", userId, err)
+	}
+	return err
+}
+
+// EnableUserTotp включает двухфакторную аутентификацию, запоминает использованный для подтверждения
+// шаг кода и сохраняет коды восстановления
+func (d *DatabaseManager) EnableUserTotp(userId domain.UserId, step int64, codeHashes []string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var id domain.UserId
+	err = tx.QueryRow(`UPDATE user_totp SET enabled = TRUE, last_used_step = $2, failed_attempts = 0 WHERE user_id = $1 AND NOT enabled RETURNING user_id`,
+		userId, step).Scan(&id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to enable TOTP for user %d: %v", userId, err)
+		}
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, userId, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes заменяет все коды восстановления пользователя новыми
+func (d *DatabaseManager) ReplaceRecoveryCodes(userId domain.UserId, codeHashes []string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userId, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userId domain.UserId, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = $1`, userId); err != nil {
+		log.Printf("Failed to delete recovery codes of user %d: %v", userId, err)
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(`INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userId, hash); err != nil {
+			log.Printf("Failed to insert recovery code of user %d: %v", userId, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DatabaseManager) DeleteUserTotp(userId domain.UserId) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = $1`, userId); err != nil {
+		log.Printf("Failed to delete recovery codes of user %d: %v", userId, err)
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userId); err != nil {
+		log.Printf("Failed to delete TOTP of user %d: %v", userId, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTotpStep атомарно запоминает шаг принятого кода и сбрасывает счетчик ошибок. Возвращает
+// sql.ErrNoRows, если код этого или более позднего шага уже использовался
+func (d *DatabaseManager) UseTotpStep(userId domain.UserId, step int64) error {
+	var id domain.UserId
+	err := d.db.QueryRow(`UPDATE user_totp SET last_used_step = $2, failed_attempts = 0, locked_until = NULL WHERE user_id = $1 AND last_used_step < $2 RETURNING user_id`,
+		userId, step).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to use TOTP step for user %d: %v", userId, err)
+	}
+	return err
+}
+
+// UseRecoveryCode атомарно помечает код восстановления использованным и сбрасывает счетчик ошибок.
+// Возвращает sql.ErrNoRows, если кода нет или он уже использован
+func (d *DatabaseManager) UseRecoveryCode(userId domain.UserId, codeHash string, now time.Time) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`UPDATE totp_recovery_codes SET used_at = $3 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL RETURNING id`,
+		userId, codeHash, now).Scan(&id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to use recovery code for user %d: %v", userId, err)
+		}
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE user_totp SET failed_attempts = 0, locked_until = NULL WHERE user_id = $1`, userId); err != nil {
+		log.Printf("Failed to reset TOTP attempts for user %d: %v", userId, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddTotpFailedAttempt увеличивает счетчик ошибок подряд и блокирует проверку кодов до lockUntil,
+// когда счетчик достигает maxAttempts. Возвращает новое значение счетчика
+func (d *DatabaseManager) AddTotpFailedAttempt(userId domain.UserId, maxAttempts int, lockUntil time.Time) (attempts int, err error) {
+	err = d.db.QueryRow(`
+		UPDATE user_totp SET failed_attempts = failed_attempts + 1,
+			locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN $3 ELSE locked_until END
+		WHERE user_id = $1 RETURNING failed_attempts`, userId, maxAttempts, lockUntil).Scan(&attempts)
+	if err != nil {
+		log.Printf("Failed to count TOTP attempt for user %d: %v", userId, err)
+	}
+	return attempts, err
+}
+
+func (d *DatabaseManager) CountUnusedRecoveryCodes(userId domain.UserId) (count int, err error) {
+	err = d.db.QueryRow(`SELECT COUNT(*) FROM totp_recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userId).Scan(&count)
+	return count, err
+}
@@ -9,7 +9,7 @@ import (
 
 func (d *DatabaseManager) GetUserById(id *domain.UserId) (user *domain.User, err error) {
 	user = &domain.User{}
-	err = d.db.QueryRow(`SELECT id, login, password_hash FROM users WHERE id = $1`, id).Scan(&user.Id, &user.Login, &user.Password)
+	err = d.db.QueryRow(`SELECT id, login, password_hash, currency FROM users WHERE id = $1`, id).Scan(&user.Id, &user.Login, &user.Password, &user.Currency)
 	if err != nil {
 		return nil, fmt.Errorf("Cant find user with id: %d in db, err: %e", id, err)
 	}
@@ -52,7 +52,7 @@ func (d *DatabaseManager) GetUsersByGroupId(id *domain.GroupId) (users []domain.
 }
 
 func (d *DatabaseManager) AddUser(user *domain.User) (err error) {
-	err = d.db.QueryRow(`INSERT INTO users (login, password_hash) VALUES ($1,$2) RETURNING id`, user.Login, user.Password).Scan(&user.Id)
+	err = d.db.QueryRow(`INSERT INTO users (login, password_hash, currency) VALUES ($1,$2,$3) RETURNING id`, user.Login, user.Password, user.Currency).Scan(&user.Id)
 	if err != nil {
 		log.Printf("Failed to insert user: %v", err)
 		return err
@@ -60,26 +60,111 @@ func (d *DatabaseManager) AddUser(user *domain.User) (err error) {
 	return nil
 }
 
+// UpdateUser обновляет логин, хеш пароля и валюту пользователя.
+// Если логин уже занят, возвращает ErrUniqueViolation.
 func (d *DatabaseManager) UpdateUser(user *domain.User) error {
-	_, err := d.db.Exec(`UPDATE users SET login = $1, password_hash = $2 WHERE id = $3`, user.Login, user.Password, user.Id)
+	_, err := d.db.Exec(`UPDATE users SET login = $1, password_hash = $2, currency = $3 WHERE id = $4`, user.Login, user.Password, user.Currency, user.Id)
 	if err != nil {
 		log.Printf("Failed to update user: %v", err)
+		if isUniqueViolation(err) {
+			return fmt.Errorf("login %s: %w", user.Login, ErrUniqueViolation)
+		}
 		return err
 	}
 	return nil
 }
 
-func (d *DatabaseManager) DeleteUser(userId domain.UserId) error {
-	_, err := d.db.Exec(`DELETE FROM users WHERE id = $1`, userId)
+// DeleteUser удаляет пользователя вместе с его данными в одной транзакции: инвайты, ссылки-приглашения,
+// участие в группе и чеки. Покупки удаляются или, при anonymize, остаются без пользователя, чека и тегов.
+// Продукты, которые используются в покупках других пользователей, передаются одному из них,
+// остальные удаляются или обезличиваются вместе с покупками.
+// Возвращает новых владельцев переданных продуктов, у обезличенных продуктов владелец 0.
+func (d *DatabaseManager) DeleteUser(userId domain.UserId, anonymize bool) (map[domain.ProductId]domain.UserId, error) {
+	tx, err := d.db.Begin()
 	if err != nil {
-		log.Printf("Failed to delete user: %v", err)
-		return err
+		log.Printf("Failed to begin transaction: %v", err)
+		return nil, err
 	}
-	return nil
+	defer tx.Rollback()
+
+	statements := []string{
+		`DELETE FROM invites WHERE from_user_id = $1 OR to_user_id = $1`,
+		`DELETE FROM invite_links WHERE user_id = $1`,
+		`DELETE FROM group_members WHERE user_id = $1`,
+	}
+	if anonymize {
+		statements = append(statements, `UPDATE purchases SET user_id = NULL, receipt_id = NULL, tags = '{}' WHERE user_id = $1`)
+	} else {
+		statements = append(statements, `DELETE FROM purchases WHERE user_id = $1`)
+	}
+	statements = append(statements, `DELETE FROM receipts WHERE user_id = $1`)
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, userId); err != nil {
+			log.Printf("Failed to delete data of user %d: %v", userId, err)
+			return nil, err
+		}
+	}
+
+	// Продукт достается пользователю с наименьшим id среди тех, чьи покупки на него ссылаются
+	rows, err := tx.Query(`
+		UPDATE products p SET user_id = o.user_id
+		FROM (SELECT product_id, MIN(user_id) AS user_id FROM purchases WHERE user_id IS NOT NULL GROUP BY product_id) o
+		WHERE p.id = o.product_id AND p.user_id = $1
+		RETURNING p.id, p.user_id`, userId)
+	if err != nil {
+		log.Printf("Failed to hand over products of user %d: %v", userId, err)
+		return nil, err
+	}
+	handedOver := make(map[domain.ProductId]domain.UserId)
+	for rows.Next() {
+		var productId domain.ProductId
+		var ownerId domain.UserId
+		if err := rows.Scan(&productId, &ownerId); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		handedOver[productId] = ownerId
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// На оставшиеся продукты ссылаются только обезличенные покупки, они остаются без владельца
+	rows, err = tx.Query(`UPDATE products SET user_id = NULL WHERE user_id = $1 AND id IN (SELECT product_id FROM purchases) RETURNING id`, userId)
+	if err != nil {
+		log.Printf("Failed to anonymize products of user %d: %v", userId, err)
+		return nil, err
+	}
+	for rows.Next() {
+		var productId domain.ProductId
+		if err := rows.Scan(&productId); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		handedOver[productId] = 0
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statements = []string{
+		`DELETE FROM products WHERE user_id = $1`,
+		`DELETE FROM users WHERE id = $1`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, userId); err != nil {
+			log.Printf("Failed to delete user %d: %v", userId, err)
+			return nil, err
+		}
+	}
+
+	return handedOver, tx.Commit()
 }
 
 func (d *DatabaseManager) GetAllUsers() ([]domain.User, error) {
-	rows, err := d.db.Query(`SELECT id, login, password_hash FROM users`)
+	rows, err := d.db.Query(`SELECT id, login, password_hash, currency FROM users`)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get all users: %w", err)
 	}
@@ -88,7 +173,7 @@ func (d *DatabaseManager) GetAllUsers() ([]domain.User, error) {
 	var users []domain.User
 	for rows.Next() {
 		var user domain.User
-		err := rows.Scan(&user.Id, &user.Login, &user.Password)
+		err := rows.Scan(&user.Id, &user.Login, &user.Password, &user.Currency)
 		if err != nil {
 			return nil, fmt.Errorf("scan error: %w", err)
 		}
@@ -3,13 +3,16 @@ package domain
 import "time"
 
 type (
-	InviteId      int64
-	GroupId       int64
-	GroupMemberId int64
-	UserId        int64
-	ProductId     int64
-	PurchaseId    int64
-	ReceiptId     int64
+	InviteId              int64
+	InviteLinkId          int64
+	GroupId               int64
+	GroupMemberId         int64
+	UserId                int64
+	ProductId             int64
+	PurchaseId            int64
+	ReceiptId             int64
+	TokenFamilyId         int64
+	PersonalAccessTokenId int64
 )
 
 type Product struct {
@@ -19,24 +22,155 @@ type Product struct {
 	Brand       string    `json:"brand"`
 	DefaultTags []string  `json:"default_tags"`
 	UserId      UserId    `json:"user_id"`
+	// Объем, приведенный к литрам, килограммам или штукам. Пустой, если Volume не распознан
+	UnitAmount float64 `json:"unit_amount,omitempty"`
+	Unit       string  `json:"unit,omitempty"`
+}
+
+// DuplicateCluster группа продуктов, которые вероятно являются одним и тем же продуктом
+type DuplicateCluster struct {
+	Products []Product `json:"products"`
+	Score    float64   `json:"score"`
 }
 
 type Purchase struct {
 	Id        PurchaseId `json:"id"`
 	ProductId ProductId  `json:"product_id"`
-	Quantity  int        `json:"quantity"`
-	Price     int        `json:"price"`
-	Date      time.Time  `json:"date"`
-	Store     string     `json:"store"`
-	Tags      []string   `json:"tags"`
-	ReceiptId ReceiptId  `json:"receipt_id"`
-	UserId    UserId     `json:"user_id"`
+	Quantity  float64    `json:"quantity"`
+	// Единица количества: pcs (штуки, по умолчанию), kg или l для весовых товаров.
+	// Для весовых товаров Price - цена за килограмм или литр
+	QuantityUnit string `json:"quantity_unit"`
+	Price        int    `json:"price"`
+	// Код валюты ISO 4217, цена указана в ее минимальных единицах (копейках, центах)
+	Currency string `json:"currency"`
+	// Цена за единицу без скидки и скидка за единицу: Price = RegularPrice - Discount.
+	// Нулевые у покупки без скидки
+	RegularPrice int       `json:"regular_price,omitempty"`
+	Discount     int       `json:"discount,omitempty"`
+	PromoType    string    `json:"promo_type,omitempty"`
+	Date         time.Time `json:"date"`
+	Store        string    `json:"store"`
+	Tags         []string  `json:"tags"`
+	ReceiptId    ReceiptId `json:"receipt_id"`
+	UserId       UserId    `json:"user_id"`
+	// Цена за литр, килограмм или штуку по объему продукта. Не хранится, вычисляется при ответе
+	PricePerUnit float64 `json:"price_per_unit,omitempty"`
+	Unit         string  `json:"unit,omitempty"`
+}
+
+// SavingsSummary экономия на скидках за период по магазинам, месяцам и типам акций
+type SavingsSummary struct {
+	Currency    string         `json:"currency"`
+	DateFrom    *time.Time     `json:"date_from,omitempty"`
+	DateTo      *time.Time     `json:"date_to,omitempty"`
+	Total       int64          `json:"total"`
+	Count       int            `json:"count"`
+	ByStore     []SavingsGroup `json:"by_store"`
+	ByMonth     []SavingsGroup `json:"by_month"`
+	ByPromoType []SavingsGroup `json:"by_promo_type"`
+	// Покупки со скидкой, которые не удалось перевести в валюту отчета из-за отсутствия курса
+	Unconverted int `json:"unconverted"`
+}
+
+// SavingsGroup экономия по одному магазину, месяцу или типу акции
+type SavingsGroup struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+	Total int64  `json:"total"`
+	Count int    `json:"count"`
+}
+
+// SpendingSummary траты за период, сгруппированные по временным интервалам и измерению
+type SpendingSummary struct {
+	Dimension string           `json:"dimension"`
+	Bucket    string           `json:"bucket"`
+	Currency  string           `json:"currency"`
+	DateFrom  *time.Time       `json:"date_from,omitempty"`
+	DateTo    *time.Time       `json:"date_to,omitempty"`
+	Total     int64            `json:"total"`
+	Count     int              `json:"count"`
+	Buckets   []SpendingBucket `json:"buckets"`
+	// Покупки, которые не удалось перевести в валюту отчета из-за отсутствия курса
+	Unconverted int `json:"unconverted"`
+}
+
+// SpendingBucket траты за один временной интервал
+type SpendingBucket struct {
+	Start  time.Time       `json:"start"`
+	Total  int64           `json:"total"`
+	Count  int             `json:"count"`
+	Groups []SpendingGroup `json:"groups"`
+}
+
+// SpendingGroup траты по одному значению измерения (тегу, магазину, участнику, продукту)
+type SpendingGroup struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+	Total int64  `json:"total"`
+	Count int    `json:"count"`
+	// Только для измерения product с распознанным объемом: купленный объем и средняя цена за единицу
+	UnitAmount   float64 `json:"unit_amount,omitempty"`
+	Unit         string  `json:"unit,omitempty"`
+	PricePerUnit float64 `json:"price_per_unit,omitempty"`
+}
+
+// PriceHistory динамика цены продукта в покупках пользователя (группы)
+type PriceHistory struct {
+	ProductId ProductId     `json:"product_id"`
+	Currency  string        `json:"currency"`
+	Unit      string        `json:"unit,omitempty"`
+	Points    []PricePoint  `json:"points"`
+	Min       *int          `json:"min"`
+	Max       *int          `json:"max"`
+	Median    *float64      `json:"median"`
+	Changes   []PriceChange `json:"changes"`
+	// Покупки, которые не удалось перевести в валюту отчета из-за отсутствия курса
+	Unconverted int `json:"unconverted"`
+}
+
+// PricePoint цена за единицу в одной покупке
+type PricePoint struct {
+	PurchaseId PurchaseId `json:"purchase_id"`
+	Date       time.Time  `json:"date"`
+	Store      string     `json:"store"`
+	Price      int        `json:"price"`
+	// Цена за литр, килограмм или штуку, если объем продукта распознан
+	PricePerUnit float64 `json:"price_per_unit,omitempty"`
+}
+
+// PriceChange изменение цены за окно в днях. Percent равен nil, если история короче окна
+type PriceChange struct {
+	WindowDays int      `json:"window_days"`
+	FromPrice  *int     `json:"from_price"`
+	ToPrice    int      `json:"to_price"`
+	Percent    *float64 `json:"percent"`
+}
+
+type Receipt struct {
+	Id          ReceiptId    `json:"id"`
+	Date        time.Time    `json:"date"`
+	Store       string       `json:"store"`
+	CommonTags  []string     `json:"common_tags"`
+	UserId      UserId       `json:"user_id"`
+	PurchaseIds []PurchaseId `json:"purchase_ids"`
+	Total       int          `json:"total"`
+	// Номер фискального накопителя, номер документа и фискальный признак импортированного чека
+	FiscalId string `json:"fiscal_id,omitempty"`
 }
 
 type User struct {
 	Id       UserId `json:"id"`
 	Login    string `json:"login"`
 	Password string `json:"password,omitempty"`
+	// Валюта покупок по умолчанию
+	Currency string `json:"currency,omitempty"`
+}
+
+// ExchangeRate курс валюты на дату: стоимость одной единицы валюты в базовой валюте
+type ExchangeRate struct {
+	Date     time.Time `json:"date"`
+	Currency string    `json:"currency"`
+	Rate     float64   `json:"rate"`
 }
 
 type Group struct {
@@ -59,3 +193,84 @@ type Invite struct {
 	ToLogin    string    `json:"to_login"`
 	CreatedAt  time.Time `json:"created_at"`
 }
+
+type InviteLink struct {
+	Id        InviteLinkId `json:"id"`
+	UserId    UserId       `json:"user_id"`
+	TokenHash string       `json:"-"`
+	MaxUses   int          `json:"max_uses"`
+	Uses      int          `json:"uses"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// AccessToken подписанный JWT и его поля, нужные для отзыва. Токены без семейства выданы до появления
+// токенов обновления, у них FamilyId = 0
+type AccessToken struct {
+	Token     string
+	Jti       string
+	UserId    UserId
+	FamilyId  TokenFamilyId
+	ExpiresAt time.Time
+}
+
+// TokenFamily семейство токенов обновления одного входа пользователя, для пользователя это сессия.
+// IP и user agent обновляются вместе с LastSeenAt при каждом обновлении токена
+type TokenFamily struct {
+	Id         TokenFamilyId `json:"id"`
+	UserId     UserId        `json:"user_id"`
+	UserAgent  string        `json:"user_agent"`
+	Ip         string        `json:"ip"`
+	CreatedAt  time.Time     `json:"created_at"`
+	LastSeenAt time.Time     `json:"last_seen_at"`
+	RevokedAt  *time.Time    `json:"-"`
+}
+
+// RefreshToken одноразовый токен обновления, хранится только его хеш. Вместе с ним выдается access-токен AccessJti
+type RefreshToken struct {
+	FamilyId        TokenFamilyId
+	UserId          UserId
+	TokenHash       string
+	ExpiresAt       time.Time
+	AccessJti       string
+	AccessExpiresAt time.Time
+}
+
+// RevokedToken access-токен, отозванный до истечения срока
+type RevokedToken struct {
+	Jti       string
+	ExpiresAt time.Time
+}
+
+// TokenScope область доступа персонального токена. Каждая следующая включает предыдущие
+type TokenScope string
+
+const (
+	// ScopeRead только чтение
+	ScopeRead TokenScope = "read"
+	// ScopePurchases чтение, а также добавление и изменение покупок и чеков
+	ScopePurchases TokenScope = "purchases"
+	// ScopeAdmin полный доступ, как у входа по паролю
+	ScopeAdmin TokenScope = "admin"
+)
+
+// PersonalAccessToken долгоживущий токен для скриптов и интеграций, хранится только его хеш
+type PersonalAccessToken struct {
+	Id        PersonalAccessTokenId `json:"id"`
+	UserId    UserId                `json:"user_id"`
+	Name      string                `json:"name"`
+	TokenHash string                `json:"-"`
+	Scope     TokenScope            `json:"scope"`
+	CreatedAt time.Time             `json:"created_at"`
+}
+
+// UserTotp настройки двухфакторной аутентификации пользователя. Пока Enabled = false, секрет только
+// выдан пользователю и ждет подтверждения кодом
+type UserTotp struct {
+	UserId         UserId
+	Secret         string
+	Enabled        bool
+	LastUsedStep   int64
+	FailedAttempts int
+	LockedUntil    *time.Time
+}
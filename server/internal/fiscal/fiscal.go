@@ -0,0 +1,316 @@
+package fiscal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"yuki_buy_log/internal/units"
+)
+
+// Operation type of a sale receipt ("приход"); refunds and expenses cannot be imported
+const saleOperation = 1
+
+// Quantity measure codes of FFD 1.2 for weighed goods
+const (
+	measureKilogram = 11
+	measureLitre    = 41
+)
+
+// Receipt is a fiscal receipt parsed from a QR code or an FNS export. Amounts are in kopecks.
+type Receipt struct {
+	DateTime          time.Time
+	Total             int
+	Store             string
+	FiscalDriveNumber string
+	DocumentNumber    string
+	FiscalSign        string
+	Items             []Item
+}
+
+// FiscalId identifies a receipt by its fiscal drive number, document number and fiscal sign.
+func (r Receipt) FiscalId() string {
+	return r.FiscalDriveNumber + "-" + r.DocumentNumber + "-" + r.FiscalSign
+}
+
+// Item is a receipt line. Price is the price of one piece, kilogram or litre, Sum is the line total.
+type Item struct {
+	Name         string
+	Price        int
+	Quantity     float64
+	QuantityUnit string
+	Sum          int
+}
+
+// ParseQR parses the QR code string of a receipt: "t=20240115T1230&s=1234.50&fn=...&i=...&fp=...&n=1".
+// The QR code does not contain the store and the items.
+func ParseQR(s string) (Receipt, error) {
+	values, err := url.ParseQuery(strings.TrimSpace(s))
+	if err != nil {
+		return Receipt{}, errors.New("invalid qr")
+	}
+	for _, key := range []string{"t", "s", "fn", "i", "fp"} {
+		if values.Get(key) == "" {
+			return Receipt{}, fmt.Errorf("qr is missing %s", key)
+		}
+	}
+	if n := values.Get("n"); n != "" && n != strconv.Itoa(saleOperation) {
+		return Receipt{}, errors.New("only sale receipts can be imported")
+	}
+
+	var receipt Receipt
+	for _, layout := range []string{"20060102T150405", "20060102T1504"} {
+		if receipt.DateTime, err = time.Parse(layout, values.Get("t")); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return Receipt{}, errors.New("invalid qr date")
+	}
+	if receipt.Total, err = ParseAmount(values.Get("s")); err != nil {
+		return Receipt{}, errors.New("invalid qr sum")
+	}
+	receipt.FiscalDriveNumber = values.Get("fn")
+	receipt.DocumentNumber = values.Get("i")
+	receipt.FiscalSign = values.Get("fp")
+	return receipt, nil
+}
+
+// exportReceipt is a receipt in the JSON export of the FNS receipt checking app
+type exportReceipt struct {
+	DateTime             json.RawMessage `json:"dateTime"`
+	TotalSum             int             `json:"totalSum"`
+	OperationType        int             `json:"operationType"`
+	RetailPlace          string          `json:"retailPlace"`
+	User                 string          `json:"user"`
+	FiscalDriveNumber    json.Number     `json:"fiscalDriveNumber"`
+	FiscalDocumentNumber json.Number     `json:"fiscalDocumentNumber"`
+	FiscalSign           json.Number     `json:"fiscalSign"`
+	Items                []struct {
+		Name     string  `json:"name"`
+		Price    int     `json:"price"`
+		Quantity float64 `json:"quantity"`
+		Sum      int     `json:"sum"`
+		Measure  *int    `json:"itemsQuantityMeasure"`
+	} `json:"items"`
+}
+
+// ParseExport parses a receipt exported from the FNS receipt checking app. The receipt may be
+// wrapped into "ticket" and "document" objects and into an array with a single receipt.
+func ParseExport(data []byte) (Receipt, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var list []json.RawMessage
+		if err := json.Unmarshal(data, &list); err != nil {
+			return Receipt{}, errors.New("invalid export")
+		}
+		if len(list) != 1 {
+			return Receipt{}, errors.New("export must contain exactly one receipt")
+		}
+		data = list[0]
+	}
+	for _, key := range []string{"ticket", "document", "receipt"} {
+		var wrapper map[string]json.RawMessage
+		if err := json.Unmarshal(data, &wrapper); err != nil {
+			return Receipt{}, errors.New("invalid export")
+		}
+		if inner, ok := wrapper[key]; ok {
+			data = inner
+		}
+	}
+
+	var export exportReceipt
+	if err := json.Unmarshal(data, &export); err != nil {
+		return Receipt{}, errors.New("invalid export")
+	}
+	if export.OperationType != 0 && export.OperationType != saleOperation {
+		return Receipt{}, errors.New("only sale receipts can be imported")
+	}
+	dateTime, err := parseExportDateTime(export.DateTime)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	receipt := Receipt{
+		DateTime:          dateTime,
+		Total:             export.TotalSum,
+		Store:             export.RetailPlace,
+		FiscalDriveNumber: export.FiscalDriveNumber.String(),
+		DocumentNumber:    export.FiscalDocumentNumber.String(),
+		FiscalSign:        export.FiscalSign.String(),
+	}
+	if receipt.Store == "" {
+		receipt.Store = export.User
+	}
+	if receipt.FiscalDriveNumber == "" || receipt.DocumentNumber == "" || receipt.FiscalSign == "" {
+		return Receipt{}, errors.New("export is missing fiscal data")
+	}
+	for _, exportItem := range export.Items {
+		item := Item{
+			Name:     strings.TrimSpace(exportItem.Name),
+			Price:    exportItem.Price,
+			Quantity: exportItem.Quantity,
+			Sum:      exportItem.Sum,
+		}
+		if exportItem.Measure != nil {
+			item.QuantityUnit = measureUnit(*exportItem.Measure)
+		}
+		receipt.Items = append(receipt.Items, withQuantityUnit(item))
+	}
+	return receipt, nil
+}
+
+// parseExportDateTime parses the receipt date, a Unix timestamp or a local time without a time zone
+func parseExportDateTime(raw json.RawMessage) (time.Time, error) {
+	var seconds int64
+	if err := json.Unmarshal(raw, &seconds); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", time.RFC3339} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, errors.New("invalid export date")
+}
+
+// measureUnit maps an FFD 1.2 measure code to a quantity unit
+func measureUnit(measure int) string {
+	switch measure {
+	case measureKilogram:
+		return units.Kilogram
+	case measureLitre:
+		return units.Litre
+	default:
+		return units.Piece
+	}
+}
+
+// withQuantityUnit sets the quantity unit of an item without one: a fractional quantity means weighed goods
+func withQuantityUnit(item Item) Item {
+	if item.QuantityUnit == "" {
+		item.QuantityUnit = units.Piece
+		if item.Quantity != math.Trunc(item.Quantity) {
+			item.QuantityUnit = units.Kilogram
+		}
+	}
+	return item
+}
+
+var (
+	amountPattern = regexp.MustCompile(`^\d+(?:[.,]\d{1,2})?$`)
+	// "Молоко 1л 89.90 x 2 = 179.80", the sum is optional
+	itemLinePattern = regexp.MustCompile(`^(.+?)\s+(\d+(?:[.,]\d{1,2})?)\s*[xXхХ×*]\s*(\d+(?:[.,]\d{1,3})?)(?:\s*=?\s*(\d+(?:[.,]\d{1,2})?))?$`)
+	// "Хлеб 45.00", a single piece
+	itemSumPattern = regexp.MustCompile(`^(.+?)\s+(\d+[.,]\d{2})$`)
+)
+
+// ParseAmount parses an amount in roubles like "89.90" or "89,9" into kopecks.
+func ParseAmount(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if !amountPattern.MatchString(s) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	value, err := strconv.ParseFloat(strings.ReplaceAll(s, ",", "."), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	return int(math.Round(value * 100)), nil
+}
+
+// parseQuantity parses a quantity like "2" or "0,734"
+func parseQuantity(s string) (float64, error) {
+	quantity, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(s), ",", "."), 64)
+	if err != nil || quantity <= 0 {
+		return 0, fmt.Errorf("invalid quantity %q", s)
+	}
+	return quantity, nil
+}
+
+// ParseItems parses receipt lines pasted as text, one item per line. A line is either
+// tab or semicolon separated "name; price[; quantity[; sum]]", or "name price x quantity [= sum]",
+// or "name sum" for a single piece. Prices are in roubles.
+func ParseItems(text string) ([]Item, error) {
+	var items []Item
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		item, err := parseItemLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		items = append(items, withQuantityUnit(item))
+	}
+	if len(items) == 0 {
+		return nil, errors.New("no items")
+	}
+	return items, nil
+}
+
+// parseItemLine parses a single pasted receipt line
+func parseItemLine(line string) (Item, error) {
+	var name, price, quantity, sum string
+	if fields := strings.FieldsFunc(line, func(r rune) bool { return r == '\t' || r == ';' }); len(fields) > 1 {
+		if len(fields) > 4 {
+			return Item{}, errors.New("too many fields")
+		}
+		fields = append(fields, "", "")
+		name, price, quantity, sum = fields[0], fields[1], fields[2], fields[3]
+	} else if match := itemLinePattern.FindStringSubmatch(line); match != nil {
+		name, price, quantity, sum = match[1], match[2], match[3], match[4]
+	} else if match := itemSumPattern.FindStringSubmatch(line); match != nil {
+		name, price = match[1], match[2]
+	} else {
+		return Item{}, errors.New("unrecognized item")
+	}
+
+	item := Item{Name: strings.TrimSpace(name), Quantity: 1}
+	if item.Name == "" {
+		return Item{}, errors.New("missing name")
+	}
+	var err error
+	if item.Price, err = ParseAmount(price); err != nil {
+		return Item{}, err
+	}
+	if strings.TrimSpace(quantity) != "" {
+		if item.Quantity, err = parseQuantity(quantity); err != nil {
+			return Item{}, err
+		}
+	}
+	item.Sum = int(math.Round(float64(item.Price) * item.Quantity))
+	if strings.TrimSpace(sum) != "" {
+		if item.Sum, err = ParseAmount(sum); err != nil {
+			return Item{}, err
+		}
+	}
+	return item, nil
+}
+
+// volumePattern finds a volume like "930мл", "0,5 л" or "6x0.33l" inside an item name
+var volumePattern = regexp.MustCompile(`(?i)(?:^|[^\p{L}\d.,])(\d+(?:[.,]\d+)?(?:\s*[xх×*]\s*\d+(?:[.,]\d+)?)?\s*(?:мл|л|кг|гр|г|шт|ml|l|kg|gr|g|pcs))(?:$|[^\p{L}\d])`)
+
+// SplitVolume splits an item name into the name without the volume and the volume,
+// "Молоко 2,5% 930мл" -> "Молоко 2,5%", "930мл". The volume is empty if it is not found or not parsed.
+func SplitVolume(name string) (string, string) {
+	match := volumePattern.FindStringSubmatchIndex(name)
+	if match == nil {
+		return name, ""
+	}
+	volume := name[match[2]:match[3]]
+	if _, ok := units.ParseVolume(volume); !ok {
+		return name, ""
+	}
+	rest := strings.Join(strings.Fields(name[:match[2]]+" "+name[match[3]:]), " ")
+	return rest, strings.ReplaceAll(volume, " ", "")
+}
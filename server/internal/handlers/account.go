@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/stores"
+	"yuki_buy_log/internal/validators"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Что делать с покупками при удалении аккаунта
+const (
+	accountPurchasesDelete    = "delete"
+	accountPurchasesAnonymize = "anonymize"
+)
+
+func AccountHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Account handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodDelete {
+			log.Printf("Method not allowed for account: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		deleteAccount(w, r)
+	}
+}
+
+func AccountExportHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Account export handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodGet {
+			log.Printf("Method not allowed for account export: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		exportAccount(w, r)
+	}
+}
+
+func AccountPasswordHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Account password handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodPut {
+			log.Printf("Method not allowed for account password: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		changePassword(w, r)
+	}
+}
+
+func AccountLoginHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Account login handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodPut {
+			log.Printf("Method not allowed for account login: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		changeLogin(w, r)
+	}
+}
+
+func changePassword(w http.ResponseWriter, r *http.Request) {
+	log.Println("Changing password")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to change password")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode password JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validators.ValidatePassword(req.NewPassword); err != nil {
+		log.Printf("New password validation failed: %v", err)
+		http.Error(w, "invalid new_password", http.StatusBadRequest)
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+		log.Printf("Invalid current password of user %d", user.Id)
+		http.Error(w, "invalid password", http.StatusForbidden)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("Failed to hash password: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user.Password = string(hash)
+	if err := stores.GetUserStore().UpdateUser(user); err != nil {
+		log.Printf("Failed to change password of user %d: %v", user.Id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Украденный токен обновления не должен пережить смену пароля, поэтому остальные сессии отзываются.
+	// С персональным токеном текущей сессии нет, и отзываются все
+	accessToken, _ := getAccessToken(r)
+	revoked, err := stores.GetTokenStore().RevokeOtherSessions(user.Id, accessToken.FamilyId)
+	if err != nil {
+		log.Printf("Failed to revoke other sessions of user %d after password change: %v", user.Id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Successfully changed password of user %d, revoked %d other sessions", user.Id, revoked)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "password changed"})
+}
+
+func changeLogin(w http.ResponseWriter, r *http.Request) {
+	log.Println("Changing login")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to change login")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Login string `json:"login"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode login JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validators.ValidateLogin(req.Login); err != nil {
+		log.Printf("Login validation failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userStore := stores.GetUserStore()
+	if existing := userStore.GetUserByLogin(req.Login); existing != nil && existing.Id != user.Id {
+		log.Printf("Login %s is already taken", req.Login)
+		http.Error(w, "login already taken", http.StatusConflict)
+		return
+	}
+
+	log.Printf("Changing login of user %d from %s to %s", user.Id, user.Login, req.Login)
+	user.Login = req.Login
+	if err := userStore.UpdateUser(user); err != nil {
+		log.Printf("Failed to change login of user %d: %v", user.Id, err)
+		if errors.Is(err, stores.ErrAlreadyExists) {
+			http.Error(w, "login already taken", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Successfully changed login of user %d", user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": user.Id, "login": user.Login})
+}
+
+// Удаление аккаунта подтверждается паролем
+type deleteAccountRequest struct {
+	Password  string `json:"password"`
+	Purchases string `json:"purchases"`
+}
+
+func deleteAccount(w http.ResponseWriter, r *http.Request) {
+	log.Println("Deleting account")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to delete account")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req deleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode delete account JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Purchases == "" {
+		req.Purchases = accountPurchasesDelete
+	}
+	if req.Purchases != accountPurchasesDelete && req.Purchases != accountPurchasesAnonymize {
+		log.Printf("Invalid purchases mode: %s", req.Purchases)
+		http.Error(w, "invalid purchases", http.StatusBadRequest)
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		log.Printf("Invalid password to delete account of user %d", user.Id)
+		http.Error(w, "invalid password", http.StatusForbidden)
+		return
+	}
+
+	err = stores.GetUserStore().DeleteUser(user.Id, req.Purchases == accountPurchasesAnonymize)
+	if err != nil {
+		log.Printf("Failed to delete account of user %d: %v", user.Id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Successfully deleted account of user %d, purchases: %s", user.Id, req.Purchases)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "account deleted"})
+}
+
+// Данные аккаунта в архиве, без хеша пароля
+type exportedAccount struct {
+	Id       domain.UserId        `json:"id"`
+	Login    string               `json:"login"`
+	Currency string               `json:"currency"`
+	Group    []domain.GroupMember `json:"group"`
+}
+
+// Архив содержит только данные самого пользователя, без покупок и продуктов других участников группы
+func exportAccount(w http.ResponseWriter, r *http.Request) {
+	log.Println("Exporting account data")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to export account")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	account := exportedAccount{Id: user.Id, Login: user.Login, Currency: user.Currency, Group: []domain.GroupMember{}}
+	if group := stores.GetGroupStore().GetGroupByUserId(user.Id); group != nil {
+		account.Group = group.Members
+	}
+
+	productStore := stores.GetProductStore()
+	products := productStore.GetProductsByUserId(user.Id)
+	sort.Slice(products, func(i, j int) bool { return products[i].Id < products[j].Id })
+
+	purchases := stores.GetPurchaseStore().GetPurchasesByUserIds([]domain.UserId{user.Id})
+	sort.Slice(purchases, func(i, j int) bool {
+		if !purchases[i].Date.Equal(purchases[j].Date) {
+			return purchases[i].Date.Before(purchases[j].Date)
+		}
+		return purchases[i].Id < purchases[j].Id
+	})
+	productStore.FillUnitPrices(purchases)
+	exported := make([]exportedPurchase, len(purchases))
+	for i, p := range purchases {
+		exported[i] = exportedPurchase{Purchase: p}
+		if p.Tags == nil {
+			exported[i].Tags = []string{}
+		}
+		if product := productStore.GetProductById(p.ProductId); product != nil {
+			exported[i].ProductName, exported[i].ProductBrand, exported[i].ProductVolume = product.Name, product.Brand, product.Volume
+		}
+	}
+
+	receipts := stores.GetReceiptStore().GetReceiptsByUserIds([]domain.UserId{user.Id})
+	sort.Slice(receipts, func(i, j int) bool { return receipts[i].Id < receipts[j].Id })
+
+	inviteStore := stores.GetInviteStore()
+	invites := map[string][]domain.Invite{
+		"incoming": emptyIfNil(inviteStore.GetInvitesToUser(user.Id)),
+		"outgoing": emptyIfNil(inviteStore.GetInvitesFromUser(user.Id)),
+	}
+	links := stores.GetInviteLinkStore().GetInviteLinksByUserId(user.Id)
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"account.json", account},
+		{"products.json", emptyIfNil(products)},
+		{"purchases.json", exported},
+		{"receipts.json", emptyIfNil(receipts)},
+		{"invites.json", invites},
+		{"invite_links.json", emptyIfNil(links)},
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="account.zip"`)
+
+	// После начала ответа статус изменить нельзя, поэтому ошибки записи только логируются
+	out := bufio.NewWriter(w)
+	archive := zip.NewWriter(out)
+	for _, file := range files {
+		f, err := archive.Create(file.name)
+		if err == nil {
+			encoder := json.NewEncoder(f)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(file.data)
+		}
+		if err != nil {
+			log.Printf("Failed to write account export: %v", err)
+			return
+		}
+	}
+
+	// CSV с покупками в формате GET /purchases/export, его можно импортировать в другой аккаунт
+	f, err := archive.Create("purchases.csv")
+	if err != nil {
+		log.Printf("Failed to write account export: %v", err)
+		return
+	}
+	writer := &csvExportWriter{csv: csv.NewWriter(f)}
+	err = writer.begin()
+	for i := 0; i < len(exported) && err == nil; i++ {
+		err = writer.write(exported[i])
+	}
+	if err == nil {
+		err = writer.end()
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		log.Printf("Failed to write account export: %v", err)
+		return
+	}
+	log.Printf("Exported account data of user %d: %d products, %d purchases, %d receipts", user.Id, len(products), len(purchases), len(receipts))
+}
+
+// emptyIfNil возвращает пустой список вместо nil, чтобы в JSON был [], а не null
+func emptyIfNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"yuki_buy_log/internal/currency"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/stores"
+)
+
+func SpendingSummaryHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Spending summary handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodGet {
+			log.Printf("Method not allowed for spending summary: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		getSpendingSummary(w, r)
+	}
+}
+
+func PriceHistoryHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Price history handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodGet {
+			log.Printf("Method not allowed for price history: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		getPriceHistory(w, r)
+	}
+}
+
+func SavingsSummaryHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Savings summary handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodGet {
+			log.Printf("Method not allowed for savings summary: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		getSavingsSummary(w, r)
+	}
+}
+
+func getSpendingSummary(w http.ResponseWriter, r *http.Request) {
+	log.Println("Computing spending summary")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to spending summary")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params := r.URL.Query()
+	query := stores.SpendingQuery{
+		UserIds:   getGroupUserIds(user.Id),
+		Dimension: stores.SpendingByNone,
+		Bucket:    stores.SpendingBucketMonth,
+	}
+	if query.Currency, err = parseCurrencyParam(r, user); err != nil {
+		log.Printf("Invalid currency: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if value := params.Get("dimension"); value != "" {
+		query.Dimension = value
+	}
+	if value := params.Get("bucket"); value != "" {
+		query.Bucket = value
+	}
+	if value := params.Get("date_from"); value != "" {
+		if query.DateFrom, err = parseDateParam(value); err != nil {
+			log.Printf("Invalid date_from: %s", value)
+			http.Error(w, "invalid date_from", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := params.Get("date_to"); value != "" {
+		if query.DateTo, err = parseDateParam(value); err != nil {
+			log.Printf("Invalid date_to: %s", value)
+			http.Error(w, "invalid date_to", http.StatusBadRequest)
+			return
+		}
+	}
+	if !query.DateFrom.IsZero() && !query.DateTo.IsZero() && query.DateTo.Before(query.DateFrom) {
+		log.Println("date_to is before date_from")
+		http.Error(w, "date_to is before date_from", http.StatusBadRequest)
+		return
+	}
+
+	purchaseStore := stores.GetPurchaseStore()
+	summary, err := purchaseStore.SpendingSummary(query)
+	if err != nil {
+		log.Printf("Failed to compute spending summary: %v", err)
+		if errors.Is(err, stores.ErrInvalidSummaryQuery) {
+			http.Error(w, "invalid dimension or bucket", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Computed spending summary of %d purchases for user %d", summary.Count, user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+func getSavingsSummary(w http.ResponseWriter, r *http.Request) {
+	log.Println("Computing savings summary")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to savings summary")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params := r.URL.Query()
+	query := stores.SavingsQuery{UserIds: getGroupUserIds(user.Id)}
+	if query.Currency, err = parseCurrencyParam(r, user); err != nil {
+		log.Printf("Invalid currency: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if value := params.Get("date_from"); value != "" {
+		if query.DateFrom, err = parseDateParam(value); err != nil {
+			log.Printf("Invalid date_from: %s", value)
+			http.Error(w, "invalid date_from", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := params.Get("date_to"); value != "" {
+		if query.DateTo, err = parseDateParam(value); err != nil {
+			log.Printf("Invalid date_to: %s", value)
+			http.Error(w, "invalid date_to", http.StatusBadRequest)
+			return
+		}
+	}
+	if !query.DateFrom.IsZero() && !query.DateTo.IsZero() && query.DateTo.Before(query.DateFrom) {
+		log.Println("date_to is before date_from")
+		http.Error(w, "date_to is before date_from", http.StatusBadRequest)
+		return
+	}
+
+	purchaseStore := stores.GetPurchaseStore()
+	summary, err := purchaseStore.SavingsSummary(query)
+	if err != nil {
+		log.Printf("Failed to compute savings summary: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Computed savings summary of %d purchases for user %d", summary.Count, user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// Возвращает валюту отчета из параметра currency, по умолчанию валюту отчетов группы пользователя
+func parseCurrencyParam(r *http.Request, user *domain.User) (string, error) {
+	value := r.URL.Query().Get("currency")
+	if value == "" {
+		return getReportingCurrency(user), nil
+	}
+	code := strings.ToUpper(value)
+	if !currency.IsValid(code) {
+		return "", fmt.Errorf("invalid currency")
+	}
+	return code, nil
+}
+
+// Окна изменения цены по умолчанию, в днях
+var defaultPriceWindows = []int{7, 30, 90, 365}
+
+// Ограничения на окна изменения цены
+const (
+	maxPriceWindows    = 10
+	maxPriceWindowDays = 3650
+)
+
+func getPriceHistory(w http.ResponseWriter, r *http.Request) {
+	log.Println("Computing price history")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to price history")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params := r.URL.Query()
+	productId, err := strconv.ParseInt(params.Get("product_id"), 10, 64)
+	if err != nil {
+		log.Printf("Invalid product_id: %s", params.Get("product_id"))
+		http.Error(w, "product_id is required", http.StatusBadRequest)
+		return
+	}
+
+	var dateFrom, dateTo time.Time
+	if value := params.Get("date_from"); value != "" {
+		if dateFrom, err = parseDateParam(value); err != nil {
+			log.Printf("Invalid date_from: %s", value)
+			http.Error(w, "invalid date_from", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := params.Get("date_to"); value != "" {
+		if dateTo, err = parseDateParam(value); err != nil {
+			log.Printf("Invalid date_to: %s", value)
+			http.Error(w, "invalid date_to", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// windows=7,30,365 - окна изменения цены в днях
+	windows := defaultPriceWindows
+	if value := params.Get("windows"); value != "" {
+		windows = nil
+		for _, part := range strings.Split(value, ",") {
+			days, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || days < 1 || days > maxPriceWindowDays {
+				log.Printf("Invalid price window: %s", part)
+				http.Error(w, "invalid windows", http.StatusBadRequest)
+				return
+			}
+			windows = append(windows, days)
+		}
+		if len(windows) > maxPriceWindows {
+			log.Printf("Too many price windows: %d", len(windows))
+			http.Error(w, "invalid windows", http.StatusBadRequest)
+			return
+		}
+	}
+
+	reportingCurrency, err := parseCurrencyParam(r, user)
+	if err != nil {
+		log.Printf("Invalid currency: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	productStore := stores.GetProductStore()
+	product := productStore.GetProductById(domain.ProductId(productId))
+	if product == nil || !isProductVisibleToUser(product, user.Id) {
+		log.Printf("Product %d not found for user %d", productId, user.Id)
+		http.Error(w, "product not found", http.StatusNotFound)
+		return
+	}
+
+	purchaseStore := stores.GetPurchaseStore()
+	history, err := purchaseStore.PriceHistory(stores.PriceHistoryQuery{
+		UserIds:    getGroupUserIds(user.Id),
+		ProductId:  product.Id,
+		DateFrom:   dateFrom,
+		DateTo:     dateTo,
+		WindowDays: windows,
+		Currency:   reportingCurrency,
+	})
+	if err != nil {
+		log.Printf("Failed to compute price history: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Computed price history of product %d from %d purchases for user %d", product.Id, len(history.Points), user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
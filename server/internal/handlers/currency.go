@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"yuki_buy_log/internal/currency"
+	"yuki_buy_log/internal/stores"
+)
+
+func CurrencyHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Currency handler called: %s %s", r.Method, r.URL.Path)
+		switch r.Method {
+		case http.MethodGet:
+			getCurrency(w, r)
+		case http.MethodPut:
+			updateCurrency(w, r)
+		default:
+			log.Printf("Method not allowed for currency: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func getCurrency(w http.ResponseWriter, r *http.Request) {
+	log.Println("Fetching currency settings")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to currency settings")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"currency":           user.Currency,
+		"reporting_currency": getReportingCurrency(user),
+	})
+}
+
+func updateCurrency(w http.ResponseWriter, r *http.Request) {
+	log.Println("Updating default currency")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to update currency")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Currency string `json:"currency"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode currency JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	code := strings.ToUpper(req.Currency)
+	if !currency.IsValid(code) {
+		log.Printf("Invalid currency: %s", req.Currency)
+		http.Error(w, "invalid currency", http.StatusBadRequest)
+		return
+	}
+
+	user.Currency = code
+	if err := stores.GetUserStore().UpdateUser(user); err != nil {
+		log.Printf("Failed to update currency of user %d: %v", user.Id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %d changed default currency to %s", user.Id, code)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"currency":           user.Currency,
+		"reporting_currency": getReportingCurrency(user),
+	})
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/fiscal"
+	"yuki_buy_log/internal/matching"
+	"yuki_buy_log/internal/stores"
+	"yuki_buy_log/internal/units"
+	"yuki_buy_log/internal/validators"
+)
+
+// Бренд и название нового продукта, если их не удалось определить при импорте
+const (
+	importedProductBrand = "Unknown"
+	importedProductName  = "Unknown"
+)
+
+// Максимальная длина названия, бренда и объема продукта
+const (
+	maxProductNameLength   = 30
+	maxProductVolumeLength = 10
+)
+
+// importProducts сопоставляет названия из импортируемых чеков и файлов с продуктами пользователя (группы).
+// Новые продукты не сохраняются, а накапливаются, чтобы одинаковые позиции получили один и тот же продукт
+type importProducts struct {
+	user    *domain.User
+	userIds []domain.UserId
+	created map[string]*domain.Product
+}
+
+func newImportProducts(user *domain.User) *importProducts {
+	return &importProducts{
+		user:    user,
+		userIds: getGroupUserIds(user.Id),
+		created: make(map[string]*domain.Product),
+	}
+}
+
+// Результат сопоставления: существующий продукт с оценкой похожести или новый продукт с Id = 0
+type importedProduct struct {
+	product *domain.Product
+	isNew   bool
+	score   float64
+}
+
+// Находит продукт по названию, бренду и объему. Если объем не передан, он ищется в названии.
+// Если похожего продукта нет, возвращает новый продукт; quantityUnit задает объем по умолчанию
+func (p *importProducts) resolve(name, brand, volume, quantityUnit string) (importedProduct, error) {
+	if volume == "" {
+		name, volume = fiscal.SplitVolume(name)
+	}
+	if product, score := stores.GetProductStore().MatchProduct(p.userIds, name+" "+brand, volume); product != nil {
+		return importedProduct{product: product, score: score}, nil
+	}
+
+	key := matching.Normalize(name) + "|" + matching.Normalize(brand) + "|" + matching.NormalizeVolume(volume)
+	if product, ok := p.created[key]; ok {
+		return importedProduct{product: product, isNew: true}, nil
+	}
+
+	product := &domain.Product{
+		Name:        cleanName(name, maxProductNameLength),
+		Brand:       cleanName(brand, maxProductNameLength),
+		Volume:      volume,
+		DefaultTags: []string{},
+		UserId:      p.user.Id,
+	}
+	if product.Name == "" {
+		product.Name = importedProductName
+	}
+	if product.Brand == "" {
+		product.Brand = importedProductBrand
+	}
+	if _, ok := units.ParseVolume(product.Volume); !ok || len(product.Volume) > maxProductVolumeLength {
+		product.Volume = defaultImportVolume(quantityUnit)
+	}
+	if err := validators.ValidateProduct(product); err != nil {
+		return importedProduct{}, fmt.Errorf("cannot create product %q: %w", name, err)
+	}
+	p.created[key] = product
+	return importedProduct{product: product, isNew: true}, nil
+}
+
+// Возвращает существующий продукт по id, если он виден пользователю
+func (p *importProducts) byId(id domain.ProductId) (importedProduct, error) {
+	product := stores.GetProductStore().GetProductById(id)
+	if product == nil || !isProductVisibleToUser(product, p.user.Id) {
+		return importedProduct{}, fmt.Errorf("product %d not found", id)
+	}
+	return importedProduct{product: product, score: 1}, nil
+}
+
+// Проверяет импортированную покупку. Новый продукт еще не создан и не имеет id,
+// поэтому для него проверяется покупка с временным id
+func validateImportedPurchase(purchase domain.Purchase, resolved importedProduct) error {
+	if resolved.isNew {
+		purchase.ProductId = 1
+	}
+	return validators.ValidatePurchase(&purchase)
+}
+
+// Объем нового продукта, если он не указан: весовые товары продаются за килограмм или литр
+func defaultImportVolume(quantityUnit string) string {
+	switch quantityUnit {
+	case units.Kilogram:
+		return "1kg"
+	case units.Litre:
+		return "1l"
+	default:
+		return "1pc"
+	}
+}
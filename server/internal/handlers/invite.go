@@ -2,9 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
-	"time"
 	"yuki_buy_log/internal/domain"
 	"yuki_buy_log/internal/stores"
 )
@@ -43,6 +43,149 @@ func getIncomingInvites(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{"invites": invites})
 }
 
+func IncomingInvitesHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Incoming invites handler called: %s %s", r.Method, r.URL.Path)
+		switch r.Method {
+		case http.MethodGet:
+			getIncomingInvites(w, r)
+		case http.MethodDelete:
+			declineInvite(w, r)
+		default:
+			log.Printf("Method not allowed for incoming invites: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func OutgoingInvitesHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Outgoing invites handler called: %s %s", r.Method, r.URL.Path)
+		switch r.Method {
+		case http.MethodGet:
+			getOutgoingInvites(w, r)
+		case http.MethodDelete:
+			cancelInvite(w, r)
+		default:
+			log.Printf("Method not allowed for outgoing invites: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func getOutgoingInvites(w http.ResponseWriter, r *http.Request) {
+	log.Println("Fetching outgoing invites from store")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to outgoing invites")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	log.Printf("Fetching outgoing invites for user ID: %d", user.Id)
+	var inviteStore = stores.GetInviteStore()
+	invites := inviteStore.GetInvitesFromUser(user.Id)
+	if invites == nil {
+		invites = []domain.Invite{}
+	}
+	log.Printf("Successfully fetched %d outgoing invites for user %d", len(invites), user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"invites": invites})
+}
+
+// Читает id инвайта из тела запроса. При ошибке сам пишет ответ и возвращает false
+func decodeInviteId(w http.ResponseWriter, r *http.Request) (domain.InviteId, bool) {
+	var req struct {
+		Id int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode invite id JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+	if req.Id == 0 {
+		log.Println("Missing id in request body")
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return 0, false
+	}
+	return domain.InviteId(req.Id), true
+}
+
+func declineInvite(w http.ResponseWriter, r *http.Request) {
+	log.Println("Declining invite")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to decline invite")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	inviteId, ok := decodeInviteId(w, r)
+	if !ok {
+		return
+	}
+
+	// Отклонить можно только инвайт, отправленный текущему пользователю
+	inviteStore := stores.GetInviteStore()
+	invite := inviteStore.GetInviteById(inviteId)
+	if invite == nil || invite.ToUserId != user.Id {
+		log.Printf("Incoming invite %d not found for user %d", inviteId, user.Id)
+		http.Error(w, "invite not found", http.StatusNotFound)
+		return
+	}
+
+	err = inviteStore.DeclineInvite(inviteId, user.Id)
+	if err != nil {
+		log.Printf("Failed to decline invite %d: %v", inviteId, err)
+		if errors.Is(err, stores.ErrNotFound) {
+			http.Error(w, "invite not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %d declined invite %d from user %d", user.Id, inviteId, invite.FromUserId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func cancelInvite(w http.ResponseWriter, r *http.Request) {
+	log.Println("Cancelling invite")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to cancel invite")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	inviteId, ok := decodeInviteId(w, r)
+	if !ok {
+		return
+	}
+
+	// Отозвать можно только инвайт, отправленный текущим пользователем
+	inviteStore := stores.GetInviteStore()
+	invite := inviteStore.GetInviteById(inviteId)
+	if invite == nil || invite.FromUserId != user.Id {
+		log.Printf("Outgoing invite %d not found for user %d", inviteId, user.Id)
+		http.Error(w, "invite not found", http.StatusNotFound)
+		return
+	}
+
+	err = inviteStore.CancelInvite(inviteId, user.Id)
+	if err != nil {
+		log.Printf("Failed to cancel invite %d: %v", inviteId, err)
+		if errors.Is(err, stores.ErrNotFound) {
+			http.Error(w, "invite not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %d cancelled invite %d to user %d", user.Id, inviteId, invite.ToUserId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func canMergeUsersToGroups(firstUserId domain.UserId, secondUserId domain.UserId) bool {
 	// Check if users are already in groups
 	var groupStore = stores.GetGroupStore()
@@ -132,7 +275,7 @@ func sendInvite(w http.ResponseWriter, r *http.Request) {
 	inviteStore := stores.GetInviteStore()
 	invite := inviteStore.GetInvite(user.Id, targetUser.Id)
 	if invite != nil {
-		http.Error(w, "Invite already exists", http.StatusInternalServerError)
+		http.Error(w, "Invite already exists", http.StatusConflict)
 		return
 	}
 
@@ -168,11 +311,14 @@ func sendInvite(w http.ResponseWriter, r *http.Request) {
 		ToUserId:   targetUser.Id,
 		FromLogin:  user.Login,
 		ToLogin:    targetUser.Login,
-		CreatedAt:  time.Now(),
 	}
 	inviteId, err := inviteStore.AddInvite(newInvite)
 	if err != nil {
-		log.Printf("Cannot send invite")
+		log.Printf("Cannot send invite: %v", err)
+		if errors.Is(err, stores.ErrAlreadyExists) {
+			http.Error(w, "Invite already exists", http.StatusConflict)
+			return
+		}
 		http.Error(w, "Cannot invite users", http.StatusBadRequest)
 		return
 	}
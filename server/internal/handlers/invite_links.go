@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"yuki_buy_log/internal/auth"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/stores"
+	"yuki_buy_log/internal/validators"
+)
+
+func InviteLinksHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Invite links handler called: %s %s", r.Method, r.URL.Path)
+		switch r.Method {
+		case http.MethodGet:
+			getInviteLinks(w, r)
+		case http.MethodPost:
+			createInviteLink(w, r)
+		case http.MethodDelete:
+			deleteInviteLink(w, r)
+		default:
+			log.Printf("Method not allowed for invite links: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func RedeemInviteLinkHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Redeem invite link handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodPost {
+			log.Printf("Method not allowed for redeem invite link: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		redeemInviteLink(w, r)
+	}
+}
+
+func getInviteLinks(w http.ResponseWriter, r *http.Request) {
+	log.Println("Fetching invite links from store")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to invite links")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	inviteLinkStore := stores.GetInviteLinkStore()
+	links := inviteLinkStore.GetInviteLinksByUserId(user.Id)
+	if links == nil {
+		links = []domain.InviteLink{}
+	}
+	log.Printf("Successfully fetched %d invite links for user %d", len(links), user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"links": links})
+}
+
+func createInviteLink(w http.ResponseWriter, r *http.Request) {
+	log.Println("Creating invite link")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to create invite link")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// По умолчанию ссылка одноразовая и живет сутки
+	req := struct {
+		MaxUses        int `json:"max_uses"`
+		ExpiresInHours int `json:"expires_in_hours"`
+	}{MaxUses: 1, ExpiresInHours: 24}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode invite link JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, tokenHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		log.Printf("Failed to generate invite link token: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	link := domain.InviteLink{
+		UserId:    user.Id,
+		TokenHash: tokenHash,
+		MaxUses:   req.MaxUses,
+		ExpiresAt: time.Now().UTC().Add(time.Duration(req.ExpiresInHours) * time.Hour),
+	}
+	if err := validators.ValidateInviteLink(&link); err != nil {
+		log.Printf("Invite link validation failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	inviteLinkStore := stores.GetInviteLinkStore()
+	if err := inviteLinkStore.CreateInviteLink(&link); err != nil {
+		log.Printf("Failed to create invite link: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Токен возвращается только один раз, в БД хранится его хеш
+	log.Printf("Successfully created invite link %d for user %d", link.Id, user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"link": link, "token": token})
+}
+
+func deleteInviteLink(w http.ResponseWriter, r *http.Request) {
+	log.Println("Deleting invite link")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to delete invite link")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Id int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode delete request JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Id == 0 {
+		log.Println("Missing id in request body")
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	inviteLinkStore := stores.GetInviteLinkStore()
+	err = inviteLinkStore.DeleteInviteLink(domain.InviteLinkId(req.Id), user.Id)
+	if err != nil {
+		log.Printf("Failed to delete invite link: %v", err)
+		http.Error(w, "invite link not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Successfully deleted invite link with ID: %d", req.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Добавляет пользователя в группу создателя ссылки. Если создатель не в группе, группа создается
+func joinGroupOfUser(ownerId domain.UserId, userId domain.UserId) error {
+	groupStore := stores.GetGroupStore()
+	group := groupStore.GetGroupByUserId(ownerId)
+	if group == nil {
+		groupId, err := groupStore.CreateNewGroup(ownerId)
+		if err != nil {
+			return err
+		}
+		return groupStore.AddUserToGroup(*groupId, userId)
+	}
+	return groupStore.AddUserToGroup(group.Id, userId)
+}
+
+func redeemInviteLink(w http.ResponseWriter, r *http.Request) {
+	log.Println("Redeeming invite link")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to redeem invite link")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode redeem JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		log.Println("Missing token in request body")
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+
+	inviteLinkStore := stores.GetInviteLinkStore()
+	link := inviteLinkStore.GetInviteLinkByTokenHash(auth.HashToken(req.Token))
+	if link == nil || !link.ExpiresAt.After(time.Now().UTC()) || link.Uses >= link.MaxUses {
+		log.Printf("Invite link not found, expired or used up for user %d", user.Id)
+		http.Error(w, "invite link not found or expired", http.StatusNotFound)
+		return
+	}
+
+	if link.UserId == user.Id {
+		log.Printf("User %d tried to redeem own invite link %d", user.Id, link.Id)
+		http.Error(w, "cannot redeem own invite link", http.StatusBadRequest)
+		return
+	}
+
+	// По ссылке можно только вступить в группу, поэтому сначала нужно выйти из текущей
+	groupStore := stores.GetGroupStore()
+	if groupStore.GetGroupByUserId(user.Id) != nil {
+		log.Printf("User %d is already in a group", user.Id)
+		http.Error(w, "user is already in a group", http.StatusConflict)
+		return
+	}
+
+	if group := groupStore.GetGroupByUserId(link.UserId); group != nil && len(group.Members) >= 5 {
+		log.Printf("Group %d is full", group.Id)
+		http.Error(w, stores.ErrMaxMembersInGroup.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = inviteLinkStore.UseInviteLink(link.Id)
+	if err != nil {
+		log.Printf("Failed to use invite link %d: %v", link.Id, err)
+		if errors.Is(err, stores.ErrNotFound) {
+			http.Error(w, "invite link not found or expired", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = joinGroupOfUser(link.UserId, user.Id)
+	if err != nil {
+		log.Printf("Failed to join group by invite link %d: %v", link.Id, err)
+		if releaseErr := inviteLinkStore.ReleaseInviteLinkUse(link.Id); releaseErr != nil {
+			log.Printf("Failed to release invite link %d: %v", link.Id, releaseErr)
+		}
+		if errors.Is(err, stores.ErrMaxMembersInGroup) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Инвайты между участниками новой группы больше не нужны
+	if err := stores.GetInviteStore().DeleteInvites(link.UserId, user.Id); err != nil {
+		log.Printf("Failed to delete invites between users %d and %d: %v", link.UserId, user.Id, err)
+	}
+
+	group := groupStore.GetGroupByUserId(user.Id)
+	log.Printf("User %d joined group of user %d by invite link %d", user.Id, link.UserId, link.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "joined group", "group": group})
+}
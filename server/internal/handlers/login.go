@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 	"yuki_buy_log/internal/domain"
 	"yuki_buy_log/internal/stores"
 
@@ -42,7 +43,7 @@ func RegisterHandler(auth Authenticator) http.HandlerFunc {
 			return
 		}
 
-		token, err := auth.GenerateToken(u.Id)
+		response, err := startSession(auth, u.Id, r)
 		if err != nil {
 			log.Printf("Failed to generate token for user %d: %v", u.Id, err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -50,7 +51,7 @@ func RegisterHandler(auth Authenticator) http.HandlerFunc {
 		}
 		log.Printf("Successfully registered user %s with ID: %d", u.Login, u.Id)
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"token": token})
+		json.NewEncoder(w).Encode(response)
 	}
 }
 
@@ -92,8 +93,33 @@ func LoginHandler(auth Authenticator) http.HandlerFunc {
 			return
 		}
 
-		// Генерация токена аутентификации
-		token, err := auth.GenerateToken(user.Id)
+		// С двухфакторной аутентификацией вместо токенов выдается токен подтверждения,
+		// который обменивается на токены вместе с кодом в /login/2fa
+		twoFactor, err := stores.GetTotpStore().IsEnabled(user.Id)
+		if err != nil {
+			log.Printf("Failed to get two-factor status of user %d: %v", user.Id, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if twoFactor {
+			challenge, err := auth.GenerateChallengeToken(user.Id)
+			if err != nil {
+				log.Printf("Failed to generate challenge token for user %d: %v", user.Id, err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			log.Printf("Password of user %s with ID %d accepted, waiting for two-factor code", credentials.Login, user.Id)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"two_factor_required": true,
+				"challenge_token":     challenge.Token,
+				"expires_in":          int(time.Until(challenge.ExpiresAt).Round(time.Second).Seconds()),
+			})
+			return
+		}
+
+		// Генерация access-токена и токена обновления
+		response, err := startSession(auth, user.Id, r)
 		if err != nil {
 			log.Printf("Failed to generate token for user %d: %v", user.Id, err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -103,6 +129,6 @@ func LoginHandler(auth Authenticator) http.HandlerFunc {
 		// Успешный ответ
 		log.Printf("Successfully logged in user %s with ID: %d", credentials.Login, user.Id)
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"token": token})
+		json.NewEncoder(w).Encode(response)
 	}
 }
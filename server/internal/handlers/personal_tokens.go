@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"yuki_buy_log/internal/auth"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/stores"
+	"yuki_buy_log/internal/validators"
+)
+
+func PersonalAccessTokensHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Personal access tokens handler called: %s %s", r.Method, r.URL.Path)
+		switch r.Method {
+		case http.MethodGet:
+			getPersonalAccessTokens(w, r)
+		case http.MethodPost:
+			createPersonalAccessToken(w, r)
+		case http.MethodDelete:
+			deletePersonalAccessToken(w, r)
+		default:
+			log.Printf("Method not allowed for personal access tokens: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func getPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	log.Println("Fetching personal access tokens from store")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to personal access tokens")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tokens := stores.GetPersonalAccessTokenStore().GetPersonalAccessTokensByUserId(user.Id)
+	if tokens == nil {
+		tokens = []domain.PersonalAccessToken{}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Id < tokens[j].Id })
+	log.Printf("Successfully fetched %d personal access tokens for user %d", len(tokens), user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"tokens": tokens})
+}
+
+func createPersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	log.Println("Creating personal access token")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to create personal access token")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name  string            `json:"name"`
+		Scope domain.TokenScope `json:"scope"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode personal access token JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, tokenHash, err := auth.NewPersonalAccessToken()
+	if err != nil {
+		log.Printf("Failed to generate personal access token: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	personalToken := domain.PersonalAccessToken{
+		UserId:    user.Id,
+		Name:      strings.TrimSpace(req.Name),
+		TokenHash: tokenHash,
+		Scope:     req.Scope,
+	}
+	if err := validators.ValidatePersonalAccessToken(&personalToken); err != nil {
+		log.Printf("Personal access token validation failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	personalTokenStore := stores.GetPersonalAccessTokenStore()
+	if err := personalTokenStore.CreatePersonalAccessToken(&personalToken); err != nil {
+		log.Printf("Failed to create personal access token: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Токен возвращается только один раз, в БД хранится его хеш
+	log.Printf("Successfully created personal access token %d with scope %s for user %d", personalToken.Id, personalToken.Scope, user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"personal_access_token": personalToken, "token": token})
+}
+
+func deletePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	log.Println("Revoking personal access token")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to revoke personal access token")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Id int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode delete request JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Id == 0 {
+		log.Println("Missing id in request body")
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	personalTokenStore := stores.GetPersonalAccessTokenStore()
+	err = personalTokenStore.DeletePersonalAccessToken(domain.PersonalAccessTokenId(req.Id), user.Id)
+	if err != nil {
+		log.Printf("Failed to revoke personal access token: %v", err)
+		http.Error(w, "personal access token not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Successfully revoked personal access token with ID: %d", req.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
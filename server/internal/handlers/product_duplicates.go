@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/stores"
+)
+
+// Порог похожести по умолчанию для поиска дубликатов
+const defaultDuplicateThreshold = 0.85
+
+func ProductDuplicatesHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Product duplicates handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodGet {
+			log.Printf("Method not allowed for product duplicates: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		getProductDuplicates(w, r)
+	}
+}
+
+func getProductDuplicates(w http.ResponseWriter, r *http.Request) {
+	log.Println("Searching for duplicate products")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to product duplicates")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	threshold := defaultDuplicateThreshold
+	if value := r.URL.Query().Get("threshold"); value != "" {
+		threshold, err = strconv.ParseFloat(value, 64)
+		if err != nil || threshold <= 0 || threshold > 1 {
+			log.Printf("Invalid threshold: %s", value)
+			http.Error(w, "invalid threshold", http.StatusBadRequest)
+			return
+		}
+	}
+
+	productStore := stores.GetProductStore()
+	clusters := productStore.FindDuplicates(getGroupUserIds(user.Id), threshold)
+	if clusters == nil {
+		clusters = []domain.DuplicateCluster{}
+	}
+
+	log.Printf("Found %d duplicate clusters for user %d", len(clusters), user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"clusters": clusters})
+}
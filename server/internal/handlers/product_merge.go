@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/stores"
+	"yuki_buy_log/internal/validators"
+)
+
+func ProductsMergeHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Products merge handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodPost {
+			log.Printf("Method not allowed for products merge: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		mergeProducts(w, r)
+	}
+}
+
+func mergeProducts(w http.ResponseWriter, r *http.Request) {
+	log.Println("Merging products")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to merge products")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		SurvivorId domain.ProductId   `json:"survivor_id"`
+		ProductIds []domain.ProductId `json:"product_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode merge JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.SurvivorId == 0 {
+		log.Println("Missing survivor_id in request body")
+		http.Error(w, "survivor_id is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.ProductIds) == 0 || len(req.ProductIds) > 50 {
+		log.Printf("Invalid number of products to merge: %d", len(req.ProductIds))
+		http.Error(w, "invalid product_ids", http.StatusBadRequest)
+		return
+	}
+
+	// Объединять можно только продукты из каталога группы пользователя
+	productStore := stores.GetProductStore()
+	survivor := productStore.GetProductById(req.SurvivorId)
+	if survivor == nil || !isProductVisibleToUser(survivor, user.Id) {
+		log.Printf("Survivor product %d not found for user %d", req.SurvivorId, user.Id)
+		http.Error(w, "product not found", http.StatusNotFound)
+		return
+	}
+
+	seen := map[domain.ProductId]bool{survivor.Id: true}
+	var mergedIds []domain.ProductId
+	defaultTags := survivor.DefaultTags
+	for _, id := range req.ProductIds {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		product := productStore.GetProductById(id)
+		if product == nil || !isProductVisibleToUser(product, user.Id) {
+			log.Printf("Product %d not found for user %d", id, user.Id)
+			http.Error(w, "product not found", http.StatusNotFound)
+			return
+		}
+		mergedIds = append(mergedIds, id)
+		defaultTags = mergeTags(defaultTags, product.DefaultTags)
+	}
+
+	if len(mergedIds) == 0 {
+		log.Println("Nothing to merge besides the survivor")
+		http.Error(w, "invalid product_ids", http.StatusBadRequest)
+		return
+	}
+
+	survivor.DefaultTags = defaultTags
+	if err := validators.ValidateProduct(survivor); err != nil {
+		log.Printf("Merged product validation failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Merging products %v into product %d for user %d", mergedIds, survivor.Id, user.Id)
+	moved, err := productStore.MergeProducts(survivor, mergedIds)
+	if err != nil {
+		log.Printf("Failed to merge products: %v", err)
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "product not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Successfully merged %d products into product %d, reassigned %d purchases", len(mergedIds), survivor.Id, moved)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"product":              survivor,
+		"merged_ids":           mergedIds,
+		"reassigned_purchases": moved,
+	})
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
@@ -20,6 +21,8 @@ func ProductsHandler(auth Authenticator) http.HandlerFunc {
 			createProduct(w, r)
 		case http.MethodPut:
 			updateProduct(w, r)
+		case http.MethodDelete:
+			deleteProduct(w, r)
 		default:
 			log.Printf("Method not allowed for products: %s", r.Method)
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -151,3 +154,117 @@ func updateProduct(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(p)
 }
+
+// Проверяет, что продукт виден пользователю, то есть принадлежит ему или участнику его группы
+func isProductVisibleToUser(product *domain.Product, userId domain.UserId) bool {
+	for _, id := range getGroupUserIds(userId) {
+		if product.UserId == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Ответ 409 с количеством покупок, которые ссылаются на продукт
+func writeProductInUse(w http.ResponseWriter, purchaseCount int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":          "product is used by purchases",
+		"purchase_count": purchaseCount,
+	})
+}
+
+func deleteProduct(w http.ResponseWriter, r *http.Request) {
+	log.Println("Deleting product")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to delete product")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Id         domain.ProductId `json:"id"`
+		ReassignTo domain.ProductId `json:"reassign_to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode delete request JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Id == 0 {
+		log.Println("Missing id in request body")
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	productStore := stores.GetProductStore()
+	product := productStore.GetProductById(req.Id)
+	if product == nil || product.UserId != user.Id {
+		log.Printf("Product %d not found for user %d", req.Id, user.Id)
+		http.Error(w, "product not found", http.StatusNotFound)
+		return
+	}
+
+	purchaseStore := stores.GetPurchaseStore()
+
+	// Без reassign_to удаляем только продукт, на который не ссылается ни одна покупка
+	if req.ReassignTo == 0 {
+		if count := purchaseStore.CountPurchasesByProductId(req.Id); count > 0 {
+			log.Printf("Product %d is used by %d purchases", req.Id, count)
+			writeProductInUse(w, count)
+			return
+		}
+
+		log.Printf("Deleting product ID: %d for user ID: %d", req.Id, user.Id)
+		err = productStore.DeleteProduct(req.Id, user.Id)
+		if err != nil {
+			log.Printf("Failed to delete product: %v", err)
+			if errors.Is(err, stores.ErrInUse) {
+				writeProductInUse(w, purchaseStore.CountPurchasesByProductId(req.Id))
+				return
+			}
+			if strings.Contains(err.Error(), "not found") {
+				http.Error(w, "product not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Successfully deleted product with ID: %d", req.Id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// С reassign_to покупки переносятся на другой продукт из каталога группы
+	if req.ReassignTo == req.Id {
+		log.Println("Cannot reassign purchases to the deleted product")
+		http.Error(w, "invalid reassign_to", http.StatusBadRequest)
+		return
+	}
+	target := productStore.GetProductById(req.ReassignTo)
+	if target == nil || !isProductVisibleToUser(target, user.Id) {
+		log.Printf("Target product %d not found for user %d", req.ReassignTo, user.Id)
+		http.Error(w, "invalid reassign_to", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Deleting product ID: %d and reassigning its purchases to product %d", req.Id, req.ReassignTo)
+	moved, err := productStore.DeleteProductReassigningPurchases(req.Id, req.ReassignTo, user.Id)
+	if err != nil {
+		log.Printf("Failed to delete product with reassignment: %v", err)
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "product not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Successfully deleted product with ID: %d, reassigned %d purchases", req.Id, moved)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": req.Id, "reassigned_purchases": moved})
+}
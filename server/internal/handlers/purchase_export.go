@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"yuki_buy_log/internal/currency"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/stores"
+)
+
+// Форматы выгрузки покупок
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+	exportFormatOFX    = "ofx"
+)
+
+// Покупки выгружаются страницами по курсору, каждая страница сразу отправляется клиенту,
+// поэтому в памяти не держится вся история
+const exportBatchSize = 500
+
+// Максимальная длина названия операции в OFX
+const ofxNameLength = 32
+
+func PurchaseExportHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Purchase export handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodGet {
+			log.Printf("Method not allowed for purchase export: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		exportPurchases(w, r)
+	}
+}
+
+// Покупка в выгрузке вместе с названием, брендом и объемом продукта
+type exportedPurchase struct {
+	domain.Purchase
+	ProductName   string `json:"product_name"`
+	ProductBrand  string `json:"product_brand"`
+	ProductVolume string `json:"product_volume"`
+}
+
+// exportWriter пишет покупки в одном из форматов выгрузки
+type exportWriter interface {
+	begin() error
+	write(p exportedPurchase) error
+	end() error
+}
+
+func exportPurchases(w http.ResponseWriter, r *http.Request) {
+	log.Println("Exporting purchases")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to export purchases")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Фильтры те же, что у GET /purchases, но выгружаются все покупки по возрастанию даты
+	query, err := parsePurchaseQuery(r, getGroupUserIds(user.Id))
+	if err != nil {
+		log.Printf("Invalid export query: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.SortBy, query.Descending, query.Limit, query.Cursor = stores.PurchaseSortDate, false, exportBatchSize, ""
+
+	// Первая страница запрашивается до начала ответа, чтобы ошибку еще можно было вернуть статусом
+	purchaseStore := stores.GetPurchaseStore()
+	purchases, nextCursor, err := purchaseStore.QueryPurchases(query)
+	if err != nil {
+		log.Printf("Failed to query purchases for export: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	productStore := stores.GetProductStore()
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormatCSV
+	}
+	out := bufio.NewWriter(w)
+	var writer exportWriter
+	var contentType string
+	switch format {
+	case exportFormatCSV:
+		writer, contentType = &csvExportWriter{csv: csv.NewWriter(out)}, "text/csv; charset=utf-8"
+	case exportFormatNDJSON:
+		writer, contentType = &ndjsonExportWriter{encoder: json.NewEncoder(out)}, "application/x-ndjson"
+	case exportFormatOFX:
+		reportingCurrency, err := parseCurrencyParam(r, user)
+		if err != nil {
+			log.Printf("Invalid currency: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ofx := &ofxExportWriter{
+			out:      out,
+			currency: reportingCurrency,
+			account:  strconv.FormatInt(int64(user.Id), 10),
+			dateFrom: query.DateFrom,
+			dateTo:   query.DateTo,
+			now:      time.Now().UTC(),
+		}
+		// Без фильтра период выписки - от первой покупки до текущего момента
+		if ofx.dateFrom.IsZero() {
+			ofx.dateFrom = ofx.now
+			if len(purchases) > 0 {
+				ofx.dateFrom = purchases[0].Date
+			}
+		}
+		if ofx.dateTo.IsZero() {
+			ofx.dateTo = ofx.now
+		}
+		writer = ofx
+		contentType = "application/x-ofx"
+	default:
+		log.Printf("Invalid export format: %s", format)
+		http.Error(w, "invalid format", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="purchases.%s"`, format))
+	flusher, _ := w.(http.Flusher)
+
+	// После начала ответа статус изменить нельзя, поэтому ошибки записи только логируются
+	if err := writer.begin(); err != nil {
+		log.Printf("Failed to write purchase export: %v", err)
+		return
+	}
+	exported := 0
+	for {
+		productStore.FillUnitPrices(purchases)
+		for _, p := range purchases {
+			row := exportedPurchase{Purchase: p}
+			if product := productStore.GetProductById(p.ProductId); product != nil {
+				row.ProductName, row.ProductBrand, row.ProductVolume = product.Name, product.Brand, product.Volume
+			}
+			if err := writer.write(row); err != nil {
+				log.Printf("Failed to write purchase export: %v", err)
+				return
+			}
+		}
+		exported += len(purchases)
+		if nextCursor == "" {
+			break
+		}
+		if flusher != nil {
+			if err := out.Flush(); err != nil {
+				log.Printf("Failed to write purchase export: %v", err)
+				return
+			}
+			flusher.Flush()
+		}
+
+		query.Cursor = nextCursor
+		purchases, nextCursor, err = purchaseStore.QueryPurchases(query)
+		if err != nil {
+			log.Printf("Failed to query purchases for export: %v", err)
+			return
+		}
+	}
+	if err := writer.end(); err != nil {
+		log.Printf("Failed to write purchase export: %v", err)
+		return
+	}
+	if err := out.Flush(); err != nil {
+		log.Printf("Failed to write purchase export: %v", err)
+		return
+	}
+	log.Printf("Exported %d purchases as %s for user %d", exported, format, user.Id)
+}
+
+// Колонки CSV совпадают с полями POST /purchases/import, поэтому выгрузку можно импортировать обратно
+var csvExportHeader = []string{
+	"id", "date", "product_id", "name", "brand", "volume", "quantity", "quantity_unit",
+	"price", "regular_price", "promo_type", "currency", "store", "tags", "receipt_id", "user_id",
+}
+
+// csvExportWriter пишет покупки в CSV, суммы в основных единицах валюты ("89.90")
+type csvExportWriter struct {
+	csv *csv.Writer
+}
+
+func (c *csvExportWriter) begin() error {
+	return c.csv.Write(csvExportHeader)
+}
+
+func (c *csvExportWriter) write(p exportedPurchase) error {
+	regularPrice := ""
+	if p.RegularPrice != 0 {
+		regularPrice = currency.FormatAmount(int64(p.RegularPrice), p.Currency)
+	}
+	receiptId := ""
+	if p.ReceiptId != 0 {
+		receiptId = strconv.FormatInt(int64(p.ReceiptId), 10)
+	}
+	return c.csv.Write([]string{
+		strconv.FormatInt(int64(p.Id), 10),
+		p.Date.Format(time.DateOnly),
+		strconv.FormatInt(int64(p.ProductId), 10),
+		p.ProductName,
+		p.ProductBrand,
+		p.ProductVolume,
+		strconv.FormatFloat(p.Quantity, 'f', -1, 64),
+		p.QuantityUnit,
+		currency.FormatAmount(int64(p.Price), p.Currency),
+		regularPrice,
+		p.PromoType,
+		p.Currency,
+		p.Store,
+		strings.Join(p.Tags, "|"),
+		receiptId,
+		strconv.FormatInt(int64(p.UserId), 10),
+	})
+}
+
+func (c *csvExportWriter) end() error {
+	c.csv.Flush()
+	return c.csv.Error()
+}
+
+// ndjsonExportWriter пишет по одной покупке в строке в том же виде, что и GET /purchases
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (n *ndjsonExportWriter) begin() error {
+	return nil
+}
+
+func (n *ndjsonExportWriter) write(p exportedPurchase) error {
+	if p.Tags == nil {
+		p.Tags = []string{}
+	}
+	return n.encoder.Encode(p)
+}
+
+func (n *ndjsonExportWriter) end() error {
+	return nil
+}
+
+// ofxExportWriter пишет покупки банковской выпиской OFX 2.1.1 в валюте отчета: каждая покупка - списание
+// на сумму цена × количество. Покупки в другой валюте переводятся по курсу на дату покупки,
+// покупки без курса пропускаются
+type ofxExportWriter struct {
+	out      io.Writer
+	currency string
+	account  string
+	dateFrom time.Time
+	dateTo   time.Time
+	now      time.Time
+	err      error
+}
+
+// printf пишет в выгрузку, запоминая первую ошибку
+func (o *ofxExportWriter) printf(format string, args ...any) {
+	if o.err == nil {
+		_, o.err = fmt.Fprintf(o.out, format, args...)
+	}
+}
+
+// ofxText экранирует строку для XML и обрезает ее до maxLen символов, 0 - без ограничения
+func ofxText(s string, maxLen int) string {
+	if runes := []rune(s); maxLen > 0 && len(runes) > maxLen {
+		s = string(runes[:maxLen])
+	}
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func ofxDate(t time.Time) string {
+	return t.UTC().Format("20060102150405")
+}
+
+func (o *ofxExportWriter) begin() error {
+	o.printf(`<?xml version="1.0" encoding="UTF-8" standalone="no"?>` + "\n")
+	o.printf(`<?OFX OFXHEADER="200" VERSION="211" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>` + "\n")
+	o.printf("<OFX>\n<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>")
+	o.printf("<DTSERVER>%s</DTSERVER><LANGUAGE>ENG</LANGUAGE></SONRS></SIGNONMSGSRSV1>\n", ofxDate(o.now))
+	o.printf("<BANKMSGSRSV1><STMTTRNRS><TRNUID>0</TRNUID><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>\n")
+	o.printf("<STMTRS><CURDEF>%s</CURDEF>\n", o.currency)
+	o.printf("<BANKACCTFROM><BANKID>YUKIBUYLOG</BANKID><ACCTID>%s</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>\n", o.account)
+	o.printf("<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>\n", ofxDate(o.dateFrom), ofxDate(o.dateTo))
+	return o.err
+}
+
+func (o *ofxExportWriter) write(p exportedPurchase) error {
+	amount, ok := stores.GetExchangeRateStore().Convert(float64(p.Price)*p.Quantity, p.Currency, o.currency, p.Date)
+	if !ok {
+		log.Printf("Purchase %d is left out of OFX export: no %s rate on %s", p.Id, p.Currency, p.Date.Format(time.DateOnly))
+		return o.err
+	}
+
+	memo := strings.TrimSpace(fmt.Sprintf("%s %s %s x %s %s", p.ProductName, p.ProductBrand, p.ProductVolume,
+		strconv.FormatFloat(p.Quantity, 'f', -1, 64), p.QuantityUnit))
+	o.printf("<STMTTRN><TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT><FITID>%d</FITID>",
+		p.Date.Format("20060102"), currency.FormatAmount(-amount, o.currency), p.Id)
+	o.printf("<NAME>%s</NAME><MEMO>%s</MEMO>", ofxText(p.Store, ofxNameLength), ofxText(memo, 0))
+	if p.Currency != o.currency {
+		rate, _ := stores.GetExchangeRateStore().Rate(p.Currency, o.currency, p.Date)
+		o.printf("<ORIGCURRENCY><CURRATE>%s</CURRATE><CURSYM>%s</CURSYM></ORIGCURRENCY>",
+			strconv.FormatFloat(rate, 'f', -1, 64), p.Currency)
+	}
+	o.printf("</STMTTRN>\n")
+	return o.err
+}
+
+func (o *ofxExportWriter) end() error {
+	o.printf("</BANKTRANLIST>\n<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>\n",
+		currency.FormatAmount(0, o.currency), ofxDate(o.now))
+	o.printf("</STMTRS></STMTTRNRS></BANKMSGSRSV1>\n</OFX>\n")
+	return o.err
+}
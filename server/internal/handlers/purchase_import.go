@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"yuki_buy_log/internal/currency"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/stores"
+)
+
+// Ограничения на размер импортируемого файла
+const (
+	maxPurchaseImportBytes = 10 << 20
+	maxPurchaseImportRows  = 10000
+)
+
+// Поля покупки, которые можно сопоставить с колонками CSV
+var purchaseImportFields = []string{
+	"date", "product_id", "name", "brand", "volume", "quantity", "quantity_unit",
+	"price", "regular_price", "promo_type", "currency", "store", "tags",
+}
+
+// Форматы дат в импортируемом файле
+var purchaseImportDateLayouts = []string{"2006-01-02", time.RFC3339, "2006-01-02 15:04:05", "02.01.2006"}
+
+func PurchaseImportHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Purchase import handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodPost {
+			log.Printf("Method not allowed for purchase import: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		importPurchases(w, r)
+	}
+}
+
+// CSV с историческими покупками. Mapping сопоставляет поля покупки с названиями колонок,
+// по умолчанию поле берется из колонки с таким же названием
+type purchaseImportRequest struct {
+	CSV       string            `json:"csv"`
+	Delimiter string            `json:"delimiter"`
+	Mapping   map[string]string `json:"mapping"`
+	DryRun    bool              `json:"dry_run"`
+}
+
+// Ошибка строки файла, Row - номер строки в файле с заголовком
+type purchaseImportError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+type purchaseImportReport struct {
+	DryRun          bool                  `json:"dry_run"`
+	Rows            int                   `json:"rows"`
+	Imported        int                   `json:"imported"`
+	MatchedProducts int                   `json:"matched_products"`
+	NewProducts     []domain.Product      `json:"new_products"`
+	Errors          []purchaseImportError `json:"errors"`
+}
+
+// Возвращает номера колонок для полей покупки по заголовку и сопоставлению
+func purchaseImportColumns(header []string, mapping map[string]string) (map[string]int, error) {
+	byName := make(map[string]int)
+	for i, name := range header {
+		// Excel добавляет BOM в начало файла
+		name = strings.TrimPrefix(name, "\ufeff")
+		byName[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	columns := make(map[string]int)
+	for _, field := range purchaseImportFields {
+		if i, ok := byName[field]; ok {
+			columns[field] = i
+		}
+	}
+	for field, name := range mapping {
+		if !slices.Contains(purchaseImportFields, field) {
+			return nil, fmt.Errorf("unknown field %s in mapping", field)
+		}
+		i, ok := byName[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("column %q of field %s not found", name, field)
+		}
+		columns[field] = i
+	}
+
+	for _, field := range []string{"date", "price", "store"} {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("column of field %s is required", field)
+		}
+	}
+	_, hasName := columns["name"]
+	_, hasProductId := columns["product_id"]
+	if !hasName && !hasProductId {
+		return nil, errors.New("column of field name or product_id is required")
+	}
+	return columns, nil
+}
+
+// Разбирает строку файла в покупку и находит ее продукт
+func parsePurchaseImportRow(record []string, columns map[string]int, products *importProducts, user *domain.User) (domain.Purchase, importedProduct, error) {
+	value := func(field string) string {
+		if i, ok := columns[field]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	purchase := domain.Purchase{
+		QuantityUnit: strings.ToLower(value("quantity_unit")),
+		Currency:     strings.ToUpper(value("currency")),
+		PromoType:    value("promo_type"),
+		Store:        value("store"),
+		Tags:         []string{},
+		UserId:       user.Id,
+		Quantity:     1,
+	}
+	setPurchaseDefaults(&purchase, user)
+	if !currency.IsValid(purchase.Currency) {
+		return purchase, importedProduct{}, errors.New("invalid currency")
+	}
+
+	var err error
+	date := value("date")
+	for _, layout := range purchaseImportDateLayouts {
+		if purchase.Date, err = time.Parse(layout, date); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return purchase, importedProduct{}, errors.New("invalid date")
+	}
+	year, month, day := purchase.Date.Date()
+	purchase.Date = time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+
+	price, err := currency.ParseAmount(value("price"), purchase.Currency)
+	if err != nil {
+		return purchase, importedProduct{}, errors.New("invalid price")
+	}
+	purchase.Price = int(price)
+	if value("regular_price") != "" {
+		regularPrice, err := currency.ParseAmount(value("regular_price"), purchase.Currency)
+		if err != nil {
+			return purchase, importedProduct{}, errors.New("invalid regular_price")
+		}
+		// Цена без скидки не выше цены покупки в таблицах означает, что скидки не было
+		if int(regularPrice) > purchase.Price {
+			purchase.RegularPrice = int(regularPrice)
+			purchase.Discount = purchase.RegularPrice - purchase.Price
+		}
+	}
+	// Тип акции без скидки не сохраняется, а скидка без типа считается прочей, как в чеках
+	if purchase.Discount == 0 {
+		purchase.PromoType = ""
+	} else if purchase.PromoType == "" {
+		purchase.PromoType = "other"
+	}
+	if quantity := value("quantity"); quantity != "" {
+		if purchase.Quantity, err = strconv.ParseFloat(strings.ReplaceAll(quantity, ",", "."), 64); err != nil {
+			return purchase, importedProduct{}, errors.New("invalid quantity")
+		}
+	}
+	for _, tag := range strings.FieldsFunc(value("tags"), func(r rune) bool { return r == ',' || r == '|' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			purchase.Tags = mergeTags(purchase.Tags, []string{tag})
+		}
+	}
+
+	var resolved importedProduct
+	if productId := value("product_id"); productId != "" {
+		id, err := strconv.ParseInt(productId, 10, 64)
+		if err != nil {
+			return purchase, importedProduct{}, errors.New("invalid product_id")
+		}
+		resolved, err = products.byId(domain.ProductId(id))
+		if err != nil {
+			return purchase, importedProduct{}, err
+		}
+	} else if name := value("name"); name != "" {
+		resolved, err = products.resolve(name, value("brand"), value("volume"), purchase.QuantityUnit)
+		if err != nil {
+			return purchase, importedProduct{}, err
+		}
+	} else {
+		return purchase, importedProduct{}, errors.New("name or product_id is required")
+	}
+	purchase.ProductId = resolved.product.Id
+
+	if err := validateImportedPurchase(purchase, resolved); err != nil {
+		return purchase, importedProduct{}, err
+	}
+	return purchase, resolved, nil
+}
+
+func importPurchases(w http.ResponseWriter, r *http.Request) {
+	log.Println("Importing purchases from CSV")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to import purchases")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req purchaseImportRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPurchaseImportBytes)).Decode(&req); err != nil {
+		log.Printf("Failed to decode purchase import JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reader := csv.NewReader(strings.NewReader(req.CSV))
+	reader.TrimLeadingSpace = true
+	if req.Delimiter != "" {
+		delimiter, size := utf8.DecodeRuneInString(req.Delimiter)
+		if size != len(req.Delimiter) || delimiter == '"' || delimiter == '\n' {
+			log.Printf("Invalid CSV delimiter: %q", req.Delimiter)
+			http.Error(w, "invalid delimiter", http.StatusBadRequest)
+			return
+		}
+		reader.Comma = delimiter
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		log.Printf("Failed to read CSV header: %v", err)
+		http.Error(w, "invalid csv header", http.StatusBadRequest)
+		return
+	}
+	columns, err := purchaseImportColumns(header, req.Mapping)
+	if err != nil {
+		log.Printf("Invalid CSV mapping: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report := purchaseImportReport{DryRun: req.DryRun, Errors: []purchaseImportError{}}
+	products := newImportProducts(user)
+	var purchases []domain.Purchase
+	// newProducts[i] - новый продукт покупки purchases[i], created - каждый новый продукт один раз
+	var newProducts, created []*domain.Product
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		report.Rows++
+		if report.Rows > maxPurchaseImportRows {
+			log.Printf("Too many rows in CSV import for user %d", user.Id)
+			http.Error(w, fmt.Sprintf("too many rows, at most %d", maxPurchaseImportRows), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) && errors.Is(parseErr.Err, csv.ErrFieldCount) {
+				report.Errors = append(report.Errors, purchaseImportError{Row: parseErr.StartLine, Error: "wrong number of fields"})
+				continue
+			}
+			log.Printf("Failed to read CSV: %v", err)
+			http.Error(w, "invalid csv: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		purchase, resolved, err := parsePurchaseImportRow(record, columns, products, user)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			report.Errors = append(report.Errors, purchaseImportError{Row: line, Error: err.Error()})
+			continue
+		}
+		purchases = append(purchases, purchase)
+		if !resolved.isNew {
+			report.MatchedProducts++
+			newProducts = append(newProducts, nil)
+			continue
+		}
+		newProducts = append(newProducts, resolved.product)
+		if !slices.Contains(created, resolved.product) {
+			created = append(created, resolved.product)
+		}
+	}
+	if report.Rows == 0 {
+		log.Println("CSV import without rows")
+		http.Error(w, "purchases are required", http.StatusBadRequest)
+		return
+	}
+
+	// Файл импортируется целиком: если есть хотя бы одна ошибка, ничего не сохраняется
+	if req.DryRun || len(report.Errors) > 0 {
+		report.NewProducts = dereferenceProducts(created)
+		log.Printf("Checked CSV import of %d rows with %d errors for user %d", report.Rows, len(report.Errors), user.Id)
+		w.Header().Set("Content-Type", "application/json")
+		if !req.DryRun {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	log.Printf("Importing %d purchases for user ID: %d", len(purchases), user.Id)
+	err = stores.GetPurchaseStore().ImportPurchases(purchases, newProducts)
+	if err != nil {
+		log.Printf("Failed to import purchases: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Новые продукты получили id при сохранении
+	report.NewProducts = dereferenceProducts(created)
+	report.Imported = len(purchases)
+
+	log.Printf("Successfully imported %d purchases for user %d", report.Imported, user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// Копирует новые продукты в ответ
+func dereferenceProducts(products []*domain.Product) []domain.Product {
+	result := make([]domain.Product, len(products))
+	for i, product := range products {
+		result[i] = *product
+	}
+	return result
+}
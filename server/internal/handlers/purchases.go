@@ -2,8 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"yuki_buy_log/internal/domain"
 	"yuki_buy_log/internal/stores"
 	"yuki_buy_log/internal/validators"
@@ -17,6 +21,8 @@ func PurchasesHandler(auth Authenticator) http.HandlerFunc {
 			getPurchases(w, r)
 		case http.MethodPost:
 			createPurchase(w, r)
+		case http.MethodPut:
+			updatePurchase(w, r)
 		case http.MethodDelete:
 			deletePurchase(w, r)
 		default:
@@ -38,33 +44,118 @@ func getPurchases(w http.ResponseWriter, r *http.Request) {
 
 	// Get all user IDs in the same group (including current user)
 	// If user is not in a group, just return their own purchases
-	var userIds []domain.UserId
-	groupStore := stores.GetGroupStore()
-	group := groupStore.GetGroupByUserId(user.Id)
-
-	if group == nil || group.Members == nil {
-		// User is not in a group, fetch only their purchases
-		log.Printf("User %d is not in a group, fetching only their purchases", user.Id)
-		userIds = []domain.UserId{user.Id}
-	} else {
-		// User is in a group, get all group member IDs
-		log.Printf("User %d is in a group with %d members, fetching purchases for all", user.Id, len(group.Members))
-		userIds = make([]domain.UserId, len(group.Members))
-		for i, member := range group.Members {
-			userIds[i] = member.UserId
-		}
+	query, err := parsePurchaseQuery(r, getGroupUserIds(user.Id))
+	if err != nil {
+		log.Printf("Invalid purchases query: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	// Get purchases from store
 	purchaseStore := stores.GetPurchaseStore()
-	purchases := purchaseStore.GetPurchasesByUserIds(userIds)
+	purchases, nextCursor, err := purchaseStore.QueryPurchases(query)
+	if err != nil {
+		log.Printf("Failed to query purchases: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if purchases == nil {
 		purchases = []domain.Purchase{}
 	}
+	stores.GetProductStore().FillUnitPrices(purchases)
 	log.Printf("Successfully fetched %d purchases for user %d", len(purchases), user.Id)
 
+	response := map[string]interface{}{"purchases": purchases}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"purchases": purchases})
+	json.NewEncoder(w).Encode(response)
+}
+
+// Максимальный размер страницы покупок
+const maxPurchasesLimit = 500
+
+// Разбирает фильтры, сортировку и пагинацию покупок из query-параметров.
+// groupUserIds - пользователи, чьи покупки доступны текущему пользователю
+func parsePurchaseQuery(r *http.Request, groupUserIds []domain.UserId) (stores.PurchaseQuery, error) {
+	params := r.URL.Query()
+	query := stores.PurchaseQuery{
+		UserIds:    groupUserIds,
+		Store:      params.Get("store"),
+		Tag:        params.Get("tag"),
+		SortBy:     stores.PurchaseSortDate,
+		Descending: true,
+		Cursor:     params.Get("cursor"),
+	}
+
+	var err error
+	if value := params.Get("date_from"); value != "" {
+		if query.DateFrom, err = parseDateParam(value); err != nil {
+			return query, fmt.Errorf("invalid date_from")
+		}
+	}
+	if value := params.Get("date_to"); value != "" {
+		if query.DateTo, err = parseDateParam(value); err != nil {
+			return query, fmt.Errorf("invalid date_to")
+		}
+	}
+
+	intParams := []struct {
+		name   string
+		target *int
+	}{
+		{"price_min", &query.PriceMin},
+		{"price_max", &query.PriceMax},
+		{"limit", &query.Limit},
+	}
+	for _, param := range intParams {
+		if value := params.Get(param.name); value != "" {
+			if *param.target, err = strconv.Atoi(value); err != nil || *param.target < 1 {
+				return query, fmt.Errorf("invalid %s", param.name)
+			}
+		}
+	}
+	if query.Limit > maxPurchasesLimit {
+		return query, fmt.Errorf("invalid limit")
+	}
+
+	if value := params.Get("product_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id < 1 {
+			return query, fmt.Errorf("invalid product_id")
+		}
+		query.ProductId = domain.ProductId(id)
+	}
+
+	// Фильтр по участнику группы
+	if value := params.Get("user_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || !slices.Contains(groupUserIds, domain.UserId(id)) {
+			return query, fmt.Errorf("invalid user_id")
+		}
+		query.UserIds = []domain.UserId{domain.UserId(id)}
+	}
+
+	// sort=date|-date|price|-price, по умолчанию сначала новые
+	if value := params.Get("sort"); value != "" {
+		query.Descending = strings.HasPrefix(value, "-")
+		query.SortBy = strings.TrimPrefix(value, "-")
+		if query.SortBy != stores.PurchaseSortDate && query.SortBy != stores.PurchaseSortPrice {
+			return query, fmt.Errorf("invalid sort")
+		}
+	}
+
+	return query, nil
+}
+
+// Покупку можно добавить только в свой чек. Нулевой receipt_id означает покупку без чека
+func isOwnReceiptOrNone(receiptId domain.ReceiptId, userId domain.UserId) bool {
+	if receiptId == 0 {
+		return true
+	}
+	receipt := stores.GetReceiptStore().GetReceiptById(receiptId)
+	return receipt != nil && receipt.UserId == userId
 }
 
 func createPurchase(w http.ResponseWriter, r *http.Request) {
@@ -82,11 +173,17 @@ func createPurchase(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	p.UserId = user.Id
+	setPurchaseDefaults(&p, user)
 	if err := validators.ValidatePurchase(&p); err != nil {
 		log.Printf("Purchase validation failed: %v", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !isOwnReceiptOrNone(p.ReceiptId, user.Id) {
+		log.Printf("Receipt %d not found for user %d", p.ReceiptId, user.Id)
+		http.Error(w, "invalid receipt_id", http.StatusBadRequest)
+		return
+	}
 	log.Printf("Creating purchase for user ID: %d", user.Id)
 
 	purchaseStore := stores.GetPurchaseStore()
@@ -97,11 +194,89 @@ func createPurchase(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	stores.GetProductStore().FillUnitPrice(&p)
 	log.Printf("Successfully created purchase with ID: %d", p.Id)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(p)
 }
 
+func updatePurchase(w http.ResponseWriter, r *http.Request) {
+	log.Println("Updating purchase")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to update purchase")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// receipt_id читается отдельно, чтобы отличить отсутствующее поле от 0
+	var req struct {
+		domain.Purchase
+		ReceiptId *domain.ReceiptId `json:"receipt_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode purchase JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p := req.Purchase
+
+	if p.Id == 0 {
+		log.Println("Missing id in request body")
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	// Изменять можно только свои покупки
+	purchaseStore := stores.GetPurchaseStore()
+	existing := purchaseStore.GetPurchaseById(p.Id)
+	if existing == nil || existing.UserId != user.Id {
+		log.Printf("Purchase %d not found for user %d", p.Id, user.Id)
+		http.Error(w, "purchase not found", http.StatusNotFound)
+		return
+	}
+
+	// Без receipt_id покупка остается в своем чеке, убрать ее из чека можно только явным receipt_id = 0.
+	// Покупка в чеке всегда совпадает с ним по дате и магазину
+	p.ReceiptId = existing.ReceiptId
+	if req.ReceiptId != nil {
+		p.ReceiptId = *req.ReceiptId
+	}
+	if p.ReceiptId != 0 {
+		receipt := stores.GetReceiptStore().GetReceiptById(p.ReceiptId)
+		if receipt == nil || receipt.UserId != user.Id {
+			log.Printf("Receipt %d not found for user %d", p.ReceiptId, user.Id)
+			http.Error(w, "invalid receipt_id", http.StatusBadRequest)
+			return
+		}
+		p.Date, p.Store = receipt.Date, receipt.Store
+	}
+
+	p.UserId = user.Id
+	setPurchaseDefaults(&p, user)
+	if err := validators.ValidatePurchase(&p); err != nil {
+		log.Printf("Purchase validation failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Updating purchase ID: %d for user ID: %d", p.Id, user.Id)
+	err = purchaseStore.UpdatePurchase(&p)
+	if err != nil {
+		log.Printf("Failed to update purchase: %v", err)
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "purchase not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	stores.GetProductStore().FillUnitPrice(&p)
+	log.Printf("Successfully updated purchase with ID: %d", p.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
 func deletePurchase(w http.ResponseWriter, r *http.Request) {
 	log.Println("Deleting purchase")
 	user, err := getUser(r)
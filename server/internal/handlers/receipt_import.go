@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+	"yuki_buy_log/internal/currency"
+	"yuki_buy_log/internal/database"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/fiscal"
+	"yuki_buy_log/internal/stores"
+	"yuki_buy_log/internal/validators"
+)
+
+func ReceiptImportHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Receipt import handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodPost {
+			log.Printf("Method not allowed for receipt import: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		importReceipt(w, r)
+	}
+}
+
+// Чек импортируется из JSON-выгрузки ФНС (export) или из строки QR-кода с вставленными позициями (qr и items).
+// Без commit возвращается предпросмотр, ничего не сохраняется
+type receiptImportRequest struct {
+	Export     json.RawMessage         `json:"export"`
+	QR         string                  `json:"qr"`
+	Items      string                  `json:"items"`
+	Store      string                  `json:"store"`
+	CommonTags []string                `json:"common_tags"`
+	Overrides  []receiptImportOverride `json:"overrides"`
+	Commit     bool                    `json:"commit"`
+}
+
+// Ручной выбор продукта для позиции: существующий продукт или новый с заданными полями
+type receiptImportOverride struct {
+	Index     int              `json:"index"`
+	ProductId domain.ProductId `json:"product_id"`
+	Product   *domain.Product  `json:"product"`
+}
+
+// Позиция чека в предпросмотре вместе с найденным или новым продуктом
+type receiptImportItem struct {
+	Index        int            `json:"index"`
+	Name         string         `json:"name"`
+	Quantity     float64        `json:"quantity"`
+	QuantityUnit string         `json:"quantity_unit"`
+	Price        int            `json:"price"`
+	Sum          int            `json:"sum"`
+	Product      domain.Product `json:"product"`
+	NewProduct   bool           `json:"new_product"`
+	Score        float64        `json:"score,omitempty"`
+}
+
+// Разбирает чек из выгрузки ФНС или из QR-кода с позициями
+func parseFiscalReceipt(req receiptImportRequest) (fiscal.Receipt, error) {
+	if len(req.Export) > 0 {
+		return fiscal.ParseExport(req.Export)
+	}
+	if req.QR == "" {
+		return fiscal.Receipt{}, errors.New("export or qr is required")
+	}
+	receipt, err := fiscal.ParseQR(req.QR)
+	if err != nil {
+		return fiscal.Receipt{}, err
+	}
+	if receipt.Items, err = fiscal.ParseItems(req.Items); err != nil {
+		return fiscal.Receipt{}, fmt.Errorf("invalid items: %w", err)
+	}
+	return receipt, nil
+}
+
+// Переводит позицию чека в покупку. Если сумма позиции меньше цены × количество,
+// разница считается скидкой, а цена покупки - фактически оплаченной ценой за единицу
+func fiscalItemPurchase(item fiscal.Item) domain.Purchase {
+	purchase := domain.Purchase{
+		Quantity:     item.Quantity,
+		QuantityUnit: item.QuantityUnit,
+		Price:        item.Price,
+		Currency:     currency.Default,
+	}
+	// Позицию с нулевым количеством отклонит валидация покупки, цену за единицу у нее не посчитать
+	if item.Quantity <= 0 {
+		return purchase
+	}
+	paid := int(math.Round(float64(item.Sum) / item.Quantity))
+	if full := int(math.Round(float64(item.Price) * item.Quantity)); item.Sum < full-1 && paid > 0 && paid < item.Price {
+		purchase.Price = paid
+		purchase.RegularPrice = item.Price
+		purchase.Discount = item.Price - paid
+		purchase.PromoType = "other"
+	}
+	return purchase
+}
+
+func importReceipt(w http.ResponseWriter, r *http.Request) {
+	log.Println("Importing fiscal receipt")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to import receipt")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req receiptImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode receipt import JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	parsed, err := parseFiscalReceipt(req)
+	if err != nil {
+		log.Printf("Failed to parse fiscal receipt: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(parsed.Items) == 0 {
+		log.Println("Fiscal receipt without items")
+		http.Error(w, "purchases are required", http.StatusBadRequest)
+		return
+	}
+
+	// Дата покупок - календарный день чека, время не хранится
+	year, month, day := parsed.DateTime.Date()
+	receipt := domain.Receipt{
+		Date:       time.Date(year, month, day, 0, 0, 0, 0, time.UTC),
+		Store:      cleanName(parsed.Store, maxProductNameLength),
+		CommonTags: req.CommonTags,
+		UserId:     user.Id,
+		FiscalId:   parsed.FiscalId(),
+	}
+	if req.Store != "" {
+		receipt.Store = req.Store
+	}
+	if receipt.CommonTags == nil {
+		receipt.CommonTags = []string{}
+	}
+	if err := validators.ValidateReceipt(&receipt); err != nil {
+		log.Printf("Imported receipt validation failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	overrides := make(map[int]receiptImportOverride)
+	for _, override := range req.Overrides {
+		if override.Index < 0 || override.Index >= len(parsed.Items) {
+			log.Printf("Invalid override index: %d", override.Index)
+			http.Error(w, "invalid override index", http.StatusBadRequest)
+			return
+		}
+		overrides[override.Index] = override
+	}
+
+	products := newImportProducts(user)
+	items := make([]receiptImportItem, len(parsed.Items))
+	purchases := make([]domain.Purchase, len(parsed.Items))
+	newProducts := make([]*domain.Product, len(parsed.Items))
+	itemsTotal := 0
+	for i, fiscalItem := range parsed.Items {
+		var resolved importedProduct
+		override, hasOverride := overrides[i]
+		switch {
+		case hasOverride && override.ProductId != 0:
+			resolved, err = products.byId(override.ProductId)
+		case hasOverride && override.Product != nil:
+			product := *override.Product
+			product.Id, product.UserId = 0, user.Id
+			if product.DefaultTags == nil {
+				product.DefaultTags = []string{}
+			}
+			resolved, err = importedProduct{product: &product, isNew: true}, validators.ValidateProduct(&product)
+		default:
+			resolved, err = products.resolve(fiscalItem.Name, "", "", fiscalItem.QuantityUnit)
+		}
+		if err != nil {
+			log.Printf("Failed to resolve product of item %d: %v", i, err)
+			http.Error(w, fmt.Sprintf("item %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+
+		purchase := fiscalItemPurchase(fiscalItem)
+		purchase.ProductId = resolved.product.Id
+		purchase.Date = receipt.Date
+		purchase.Store = receipt.Store
+		purchase.Tags = mergeTags(resolved.product.DefaultTags, receipt.CommonTags)
+		purchase.UserId = user.Id
+		setPurchaseDefaults(&purchase, user)
+		if err := validateImportedPurchase(purchase, resolved); err != nil {
+			log.Printf("Purchase of item %d validation failed: %v", i, err)
+			http.Error(w, fmt.Sprintf("item %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+		purchases[i] = purchase
+		if resolved.isNew {
+			newProducts[i] = resolved.product
+		}
+
+		items[i] = receiptImportItem{
+			Index:        i,
+			Name:         fiscalItem.Name,
+			Quantity:     fiscalItem.Quantity,
+			QuantityUnit: fiscalItem.QuantityUnit,
+			Price:        fiscalItem.Price,
+			Sum:          fiscalItem.Sum,
+			Product:      *resolved.product,
+			NewProduct:   resolved.isNew,
+			Score:        math.Round(resolved.score*1000) / 1000,
+		}
+		itemsTotal += fiscalItem.Sum
+	}
+
+	warnings := []string{}
+	if parsed.Total != 0 && itemsTotal != parsed.Total {
+		warnings = append(warnings, fmt.Sprintf("items total %d does not match receipt total %d", itemsTotal, parsed.Total))
+	}
+	receiptStore := stores.GetReceiptStore()
+	existing := receiptStore.GetReceiptByFiscalId(user.Id, receipt.FiscalId)
+	if existing != nil {
+		warnings = append(warnings, fmt.Sprintf("receipt is already imported as receipt %d", existing.Id))
+	}
+
+	if !req.Commit {
+		receipt.Total, receipt.PurchaseIds = itemsTotal, []domain.PurchaseId{}
+		log.Printf("Previewed fiscal receipt %s with %d items for user %d", receipt.FiscalId, len(items), user.Id)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"receipt":     receipt,
+			"items":       items,
+			"items_total": itemsTotal,
+			"total":       parsed.Total,
+			"warnings":    warnings,
+		})
+		return
+	}
+
+	if existing != nil {
+		log.Printf("Fiscal receipt %s is already imported by user %d", receipt.FiscalId, user.Id)
+		http.Error(w, "receipt already imported", http.StatusConflict)
+		return
+	}
+
+	log.Printf("Importing fiscal receipt %s with %d purchases for user ID: %d", receipt.FiscalId, len(purchases), user.Id)
+	err = receiptStore.ImportReceipt(&receipt, purchases, newProducts)
+	if err != nil {
+		log.Printf("Failed to import receipt: %v", err)
+		if errors.Is(err, database.ErrUniqueViolation) {
+			http.Error(w, "receipt already imported", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Один новый продукт может быть у нескольких позиций, в ответе он возвращается один раз
+	created := []domain.Product{}
+	seen := make(map[domain.ProductId]bool)
+	for _, product := range newProducts {
+		if product != nil && !seen[product.Id] {
+			seen[product.Id] = true
+			created = append(created, *product)
+		}
+	}
+
+	stores.GetProductStore().FillUnitPrices(purchases)
+	log.Printf("Successfully imported receipt with ID: %d", receipt.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"receipt": receipt, "purchases": purchases, "products": created})
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/stores"
+	"yuki_buy_log/internal/validators"
+)
+
+func ReceiptsHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Receipts handler called: %s %s", r.Method, r.URL.Path)
+		switch r.Method {
+		case http.MethodGet:
+			getReceipts(w, r)
+		case http.MethodPost:
+			createReceipt(w, r)
+		case http.MethodPut:
+			updateReceipt(w, r)
+		case http.MethodDelete:
+			deleteReceipt(w, r)
+		default:
+			log.Printf("Method not allowed for receipts: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+type receiptRequest struct {
+	Id         domain.ReceiptId  `json:"id"`
+	Date       time.Time         `json:"date"`
+	Store      string            `json:"store"`
+	CommonTags []string          `json:"common_tags"`
+	Purchases  []domain.Purchase `json:"purchases"`
+}
+
+// Объединяет теги покупки с общими тегами чека без дубликатов
+func mergeTags(tags []string, commonTags []string) []string {
+	result := make([]string, 0, len(tags)+len(commonTags))
+	seen := make(map[string]bool)
+	for _, tag := range append(append([]string{}, tags...), commonTags...) {
+		if !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// Заменяет в тегах покупки старые общие теги чека на новые
+func replaceCommonTags(tags []string, oldCommonTags []string, newCommonTags []string) []string {
+	old := make(map[string]bool)
+	for _, tag := range oldCommonTags {
+		old[tag] = true
+	}
+
+	var own []string
+	for _, tag := range tags {
+		if !old[tag] {
+			own = append(own, tag)
+		}
+	}
+	return mergeTags(own, newCommonTags)
+}
+
+func getReceipts(w http.ResponseWriter, r *http.Request) {
+	log.Println("Fetching receipts from store")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to receipts")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	log.Printf("Fetching receipts for user ID: %d and their group", user.Id)
+
+	receiptStore := stores.GetReceiptStore()
+	receipts := receiptStore.GetReceiptsByUserIds(getGroupUserIds(user.Id))
+	if receipts == nil {
+		receipts = []domain.Receipt{}
+	}
+	log.Printf("Successfully fetched %d receipts for user %d", len(receipts), user.Id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"receipts": receipts})
+}
+
+func createReceipt(w http.ResponseWriter, r *http.Request) {
+	log.Println("Creating new receipt")
+	var req receiptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode receipt JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to create receipt")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	receipt := domain.Receipt{
+		Date:       req.Date,
+		Store:      req.Store,
+		CommonTags: req.CommonTags,
+		UserId:     user.Id,
+	}
+	if receipt.CommonTags == nil {
+		receipt.CommonTags = []string{}
+	}
+	if err := validators.ValidateReceipt(&receipt); err != nil {
+		log.Printf("Receipt validation failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Purchases) == 0 {
+		log.Println("Receipt without purchases")
+		http.Error(w, "purchases are required", http.StatusBadRequest)
+		return
+	}
+
+	// Дата, магазин и общие теги берутся из чека
+	purchases := req.Purchases
+	for i := range purchases {
+		purchases[i].Id = 0
+		purchases[i].Date = receipt.Date
+		purchases[i].Store = receipt.Store
+		purchases[i].Tags = mergeTags(purchases[i].Tags, receipt.CommonTags)
+		purchases[i].UserId = user.Id
+		setPurchaseDefaults(&purchases[i], user)
+		if err := validators.ValidatePurchase(&purchases[i]); err != nil {
+			log.Printf("Purchase %d of receipt validation failed: %v", i, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	log.Printf("Creating receipt with %d purchases for user ID: %d", len(purchases), user.Id)
+
+	receiptStore := stores.GetReceiptStore()
+	err = receiptStore.CreateReceipt(&receipt, purchases)
+	if err != nil {
+		log.Printf("Failed to create receipt: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	stores.GetProductStore().FillUnitPrices(purchases)
+	log.Printf("Successfully created receipt with ID: %d", receipt.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"receipt": receipt, "purchases": purchases})
+}
+
+func updateReceipt(w http.ResponseWriter, r *http.Request) {
+	log.Println("Updating receipt")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to update receipt")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req receiptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode receipt JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Id == 0 {
+		log.Println("Missing id in request body")
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	receiptStore := stores.GetReceiptStore()
+	existing := receiptStore.GetReceiptById(req.Id)
+	if existing == nil || existing.UserId != user.Id {
+		log.Printf("Receipt %d not found for user %d", req.Id, user.Id)
+		http.Error(w, "receipt not found", http.StatusNotFound)
+		return
+	}
+
+	receipt := domain.Receipt{
+		Id:         req.Id,
+		Date:       req.Date,
+		Store:      req.Store,
+		CommonTags: req.CommonTags,
+		UserId:     user.Id,
+		FiscalId:   existing.FiscalId,
+	}
+	if receipt.CommonTags == nil {
+		receipt.CommonTags = []string{}
+	}
+	if err := validators.ValidateReceipt(&receipt); err != nil {
+		log.Printf("Receipt validation failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Переносим изменения чека во все его покупки
+	purchases := stores.GetPurchaseStore().GetPurchasesByReceiptId(receipt.Id)
+	for i := range purchases {
+		purchases[i].Date = receipt.Date
+		purchases[i].Store = receipt.Store
+		purchases[i].Tags = replaceCommonTags(purchases[i].Tags, existing.CommonTags, receipt.CommonTags)
+		setPurchaseDefaults(&purchases[i], user)
+		if err := validators.ValidatePurchase(&purchases[i]); err != nil {
+			log.Printf("Purchase %d validation failed after receipt update: %v", purchases[i].Id, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	log.Printf("Updating receipt ID: %d for user ID: %d", receipt.Id, user.Id)
+	err = receiptStore.UpdateReceipt(&receipt, purchases)
+	if err != nil {
+		log.Printf("Failed to update receipt: %v", err)
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "receipt not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Successfully updated receipt with ID: %d", receipt.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(receipt)
+}
+
+func deleteReceipt(w http.ResponseWriter, r *http.Request) {
+	log.Println("Deleting receipt")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to delete receipt")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Id int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode delete request JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Id == 0 {
+		log.Println("Missing id in request body")
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Deleting receipt ID: %d for user ID: %d", req.Id, user.Id)
+
+	receiptStore := stores.GetReceiptStore()
+	err = receiptStore.DeleteReceipt(domain.ReceiptId(req.Id), user.Id)
+	if err != nil {
+		log.Printf("Failed to delete receipt: %v", err)
+		http.Error(w, "receipt not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Successfully deleted receipt with ID: %d", req.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
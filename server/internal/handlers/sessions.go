@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/stores"
+	"yuki_buy_log/internal/utils"
+)
+
+// Максимальная длина user agent, который сохраняется в сессии
+const maxUserAgentLength = 255
+
+// Сессия в ответе: семейство токенов обновления и признак того, что запрос пришел из этой сессии
+type sessionResponse struct {
+	domain.TokenFamily
+	Current bool `json:"current"`
+}
+
+func SessionsHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Sessions handler called: %s %s", r.Method, r.URL.Path)
+		switch r.Method {
+		case http.MethodGet:
+			getSessions(w, r)
+		case http.MethodDelete:
+			deleteSession(w, r)
+		default:
+			log.Printf("Method not allowed for sessions: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func OtherSessionsHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Other sessions handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodDelete {
+			log.Printf("Method not allowed for other sessions: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		deleteOtherSessions(w, r)
+	}
+}
+
+func getSessions(w http.ResponseWriter, r *http.Request) {
+	log.Println("Fetching sessions")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to sessions")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// С персональным токеном запрос не относится ни к одной сессии, и текущей среди них нет
+	accessToken, fromSession := getAccessToken(r)
+
+	families, err := stores.GetTokenStore().GetSessions(user.Id)
+	if err != nil {
+		log.Printf("Failed to fetch sessions of user %d: %v", user.Id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sessions := make([]sessionResponse, len(families))
+	for i, family := range families {
+		sessions[i] = sessionResponse{TokenFamily: family, Current: fromSession && family.Id == accessToken.FamilyId}
+	}
+	log.Printf("Successfully fetched %d sessions for user %d", len(sessions), user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"sessions": sessions})
+}
+
+// Отзыв сессии завершает ее на устройстве: токен обновления и выданные в сессии access-токены перестают действовать.
+// Можно отозвать и текущую сессию, это то же самое, что выход
+func deleteSession(w http.ResponseWriter, r *http.Request) {
+	log.Println("Revoking session")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to revoke session")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Id int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode revoke session JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Id == 0 {
+		log.Println("Missing id in request body")
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	err = stores.GetTokenStore().RevokeSession(user.Id, domain.TokenFamilyId(req.Id))
+	if err != nil {
+		if errors.Is(err, stores.ErrNotFound) {
+			log.Printf("Session %d of user %d not found", req.Id, user.Id)
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to revoke session %d: %v", req.Id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Successfully revoked session %d of user %d", req.Id, user.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deleteOtherSessions(w http.ResponseWriter, r *http.Request) {
+	log.Println("Revoking other sessions")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to revoke other sessions")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// С персональным токеном нет текущей сессии, которую нужно оставить
+	if token, ok := getPersonalAccessToken(r); ok {
+		log.Printf("Attempt to revoke other sessions with personal access token %d", token.Id)
+		http.Error(w, "personal access tokens have no current session", http.StatusBadRequest)
+		return
+	}
+	accessToken, _ := getAccessToken(r)
+
+	revoked, err := stores.GetTokenStore().RevokeOtherSessions(user.Id, accessToken.FamilyId)
+	if err != nil {
+		log.Printf("Failed to revoke other sessions of user %d: %v", user.Id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Successfully revoked %d other sessions of user %d", revoked, user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"revoked": revoked})
+}
+
+// Возвращает user agent запроса, обрезанный до длины колонки в БД
+func getUserAgent(r *http.Request) string {
+	userAgent := strings.ToValidUTF8(r.UserAgent(), "")
+	if len(userAgent) > maxUserAgentLength {
+		// Обрезанный посередине символ тоже отбрасывается
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+	return userAgent
+}
+
+// Возвращает IP клиента. За обратным прокси (TRUST_PROXY) это первый адрес из X-Forwarded-For,
+// иначе адрес соединения
+func getClientIp(r *http.Request) string {
+	if utils.TrustProxy {
+		forwarded, _, _ := strings.Cut(r.Header.Get("X-Forwarded-For"), ",")
+		if ip := net.ParseIP(strings.TrimSpace(forwarded)); ip != nil {
+			return ip.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"yuki_buy_log/internal/auth"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/stores"
+)
+
+// Ответ на вход и обновление токена: access-токен, новый токен обновления и срок жизни access-токена в секундах
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// startSession создает новое семейство токенов обновления (сессию) для устройства, с которого пришел запрос,
+// и выдает первую пару токенов
+func startSession(authenticator Authenticator, userId domain.UserId, r *http.Request) (tokenResponse, error) {
+	family := domain.TokenFamily{UserId: userId, UserAgent: getUserAgent(r), Ip: getClientIp(r)}
+	if err := stores.GetTokenStore().CreateTokenFamily(&family); err != nil {
+		return tokenResponse{}, err
+	}
+	return issueTokens(authenticator, userId, family.Id)
+}
+
+// issueTokens выдает access-токен и новый токен обновления семейства
+func issueTokens(authenticator Authenticator, userId domain.UserId, familyId domain.TokenFamilyId) (tokenResponse, error) {
+	accessToken, err := authenticator.GenerateToken(userId, familyId)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	refreshToken, refreshTokenHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	err = stores.GetTokenStore().AddRefreshToken(&domain.RefreshToken{
+		FamilyId:        familyId,
+		UserId:          userId,
+		TokenHash:       refreshTokenHash,
+		ExpiresAt:       time.Now().UTC().Add(auth.RefreshTokenTTL),
+		AccessJti:       accessToken.Jti,
+		AccessExpiresAt: accessToken.ExpiresAt.UTC(),
+	})
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	return tokenResponse{
+		Token:        accessToken.Token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(auth.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// Вспомогательная функция для получения access-токена запроса из контекста
+func getAccessToken(r *http.Request) (domain.AccessToken, bool) {
+	token, ok := r.Context().Value("accessToken").(domain.AccessToken)
+	return token, ok
+}
+
+// Вспомогательная функция для получения персонального токена, если запрос выполнен с ним
+func getPersonalAccessToken(r *http.Request) (domain.PersonalAccessToken, bool) {
+	token, ok := r.Context().Value("personalAccessToken").(domain.PersonalAccessToken)
+	return token, ok
+}
+
+func RefreshTokenHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Refresh token handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodPost {
+			log.Printf("Method not allowed for refresh token: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		refreshToken(w, r, auth)
+	}
+}
+
+func LogoutHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Logout handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodPost {
+			log.Printf("Method not allowed for logout: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		logout(w, r)
+	}
+}
+
+// Публичные ключи, которыми можно проверить access-токены, в формате JWKS. HS256-ключи не публикуются
+func JWKSHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("JWKS handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodGet {
+			log.Printf("Method not allowed for JWKS: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(auth.JWKS())
+	}
+}
+
+func refreshToken(w http.ResponseWriter, r *http.Request, authenticator Authenticator) {
+	log.Println("Refreshing token")
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode refresh token JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.RefreshToken == "" {
+		log.Println("Missing refresh token")
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+
+	token, err := stores.GetTokenStore().UseRefreshToken(auth.HashToken(req.RefreshToken), getUserAgent(r), getClientIp(r))
+	if err != nil {
+		if errors.Is(err, stores.ErrTokenReused) {
+			log.Println("Refresh token reused, token family revoked")
+			http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, stores.ErrNotFound) {
+			log.Println("Invalid or expired refresh token")
+			http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("Failed to use refresh token: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := issueTokens(authenticator, token.UserId, token.FamilyId)
+	if err != nil {
+		log.Printf("Failed to issue tokens for user %d: %v", token.UserId, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Successfully refreshed token of user %d, family %d", token.UserId, token.FamilyId)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Выход отзывает семейство токенов обновления текущего входа и сам access-токен
+func logout(w http.ResponseWriter, r *http.Request) {
+	log.Println("Logging out")
+	// Персональный токен не относится ко входу, его нужно отозвать в /account/tokens
+	if token, ok := getPersonalAccessToken(r); ok {
+		log.Printf("Logout attempt with personal access token %d", token.Id)
+		http.Error(w, "personal access tokens cannot log out, revoke the token instead", http.StatusBadRequest)
+		return
+	}
+	user, err := getUser(r)
+	accessToken, ok := getAccessToken(r)
+	if err != nil || !ok {
+		log.Println("Unauthorized access attempt to logout")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tokenStore := stores.GetTokenStore()
+	if accessToken.FamilyId != 0 {
+		if err := tokenStore.RevokeTokenFamily(accessToken.FamilyId); err != nil {
+			log.Printf("Failed to revoke token family %d: %v", accessToken.FamilyId, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if accessToken.Jti != "" {
+		err := tokenStore.RevokeToken(domain.RevokedToken{Jti: accessToken.Jti, ExpiresAt: accessToken.ExpiresAt.UTC()})
+		if err != nil {
+			log.Printf("Failed to revoke access token: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	log.Printf("User %d logged out, token family %d revoked", user.Id, accessToken.FamilyId)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "logged out"})
+}
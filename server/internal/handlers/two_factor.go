@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"yuki_buy_log/internal/auth"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/stores"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Название сервиса в приложении-аутентификаторе
+const totpIssuer = "Yuki Buy Log"
+
+func TwoFactorHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Two-factor handler called: %s %s", r.Method, r.URL.Path)
+		switch r.Method {
+		case http.MethodGet:
+			getTwoFactorStatus(w, r)
+		case http.MethodDelete:
+			disableTwoFactor(w, r)
+		default:
+			log.Printf("Method not allowed for two-factor: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func TwoFactorSetupHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Two-factor setup handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodPost {
+			log.Printf("Method not allowed for two-factor setup: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		setupTwoFactor(w, r)
+	}
+}
+
+func TwoFactorEnableHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Two-factor enable handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodPost {
+			log.Printf("Method not allowed for two-factor enable: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		enableTwoFactor(w, r)
+	}
+}
+
+func TwoFactorRecoveryCodesHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Two-factor recovery codes handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodPost {
+			log.Printf("Method not allowed for two-factor recovery codes: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		regenerateRecoveryCodes(w, r)
+	}
+}
+
+// Второй шаг входа: токен подтверждения, полученный после проверки пароля, обменивается
+// вместе с кодом на access-токен и токен обновления
+func LoginTwoFactorHandler(auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Login two-factor handler called: %s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodPost {
+			log.Printf("Method not allowed for login two-factor: %s", r.Method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		loginTwoFactor(w, r, auth)
+	}
+}
+
+func getTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	log.Println("Fetching two-factor status")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to two-factor status")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	totpStore := stores.GetTotpStore()
+	enabled, err := totpStore.IsEnabled(user.Id)
+	if err != nil {
+		log.Printf("Failed to get two-factor status of user %d: %v", user.Id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recoveryCodes := 0
+	if enabled {
+		recoveryCodes, err = totpStore.CountRecoveryCodes(user.Id)
+		if err != nil {
+			log.Printf("Failed to count recovery codes of user %d: %v", user.Id, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"enabled": enabled, "recovery_codes_left": recoveryCodes})
+}
+
+// Подключение начинается с проверки пароля: пользователь получает секрет и URI для QR-кода,
+// а двухфакторная аутентификация включается после ввода первого кода
+func setupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	log.Println("Setting up two-factor authentication")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to two-factor setup")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode two-factor setup JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		log.Printf("Invalid password of user %d for two-factor setup", user.Id)
+		http.Error(w, "invalid password", http.StatusForbidden)
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		log.Printf("Failed to generate TOTP secret: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := stores.GetTotpStore().SetupTotp(user.Id, secret); err != nil {
+		if errors.Is(err, stores.ErrTotpAlreadyActive) {
+			log.Printf("Two-factor authentication of user %d is already enabled", user.Id)
+			http.Error(w, "two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to setup two-factor authentication of user %d: %v", user.Id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Successfully started two-factor setup of user %d", user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"secret":           secret,
+		"provisioning_uri": auth.TOTPProvisioningURI(totpIssuer, user.Login, secret),
+	})
+}
+
+func enableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	log.Println("Enabling two-factor authentication")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to enable two-factor")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode two-factor enable JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		log.Printf("Failed to generate recovery codes: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = stores.GetTotpStore().EnableTotp(user.Id, req.Code, hashes)
+	if err != nil {
+		switch {
+		case errors.Is(err, stores.ErrNotFound):
+			log.Printf("Two-factor setup of user %d is not started", user.Id)
+			http.Error(w, "two-factor setup is not started", http.StatusBadRequest)
+		case errors.Is(err, stores.ErrTotpAlreadyActive):
+			log.Printf("Two-factor authentication of user %d is already enabled", user.Id)
+			http.Error(w, "two-factor authentication is already enabled", http.StatusConflict)
+		case errors.Is(err, stores.ErrInvalidCode):
+			log.Printf("Invalid code to enable two-factor authentication of user %d", user.Id)
+			http.Error(w, "invalid code", http.StatusForbidden)
+		default:
+			log.Printf("Failed to enable two-factor authentication of user %d: %v", user.Id, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Коды восстановления возвращаются только один раз, в БД хранятся их хеши
+	log.Printf("Successfully enabled two-factor authentication of user %d", user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+// Для выключения нужны и пароль, и код, чтобы его не мог выключить тот, кто знает только пароль
+// или завладел открытой сессией
+func disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	log.Println("Disabling two-factor authentication")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to disable two-factor")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode two-factor disable JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		log.Printf("Invalid password of user %d to disable two-factor", user.Id)
+		http.Error(w, "invalid password", http.StatusForbidden)
+		return
+	}
+
+	totpStore := stores.GetTotpStore()
+	if !verifyTwoFactorCode(w, user.Id, req.Code, http.StatusForbidden) {
+		return
+	}
+	if err := totpStore.DisableTotp(user.Id); err != nil {
+		log.Printf("Failed to disable two-factor authentication of user %d: %v", user.Id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Successfully disabled two-factor authentication of user %d", user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "two-factor authentication disabled"})
+}
+
+func regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	log.Println("Regenerating recovery codes")
+	user, err := getUser(r)
+	if err != nil {
+		log.Println("Unauthorized access attempt to regenerate recovery codes")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode recovery codes JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		log.Printf("Invalid password of user %d to regenerate recovery codes", user.Id)
+		http.Error(w, "invalid password", http.StatusForbidden)
+		return
+	}
+
+	totpStore := stores.GetTotpStore()
+	enabled, err := totpStore.IsEnabled(user.Id)
+	if err != nil {
+		log.Printf("Failed to get two-factor status of user %d: %v", user.Id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !enabled {
+		log.Printf("Two-factor authentication of user %d is not enabled", user.Id)
+		http.Error(w, "two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		log.Printf("Failed to generate recovery codes: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := totpStore.ReplaceRecoveryCodes(user.Id, hashes); err != nil {
+		log.Printf("Failed to replace recovery codes of user %d: %v", user.Id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Successfully regenerated recovery codes of user %d", user.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+func loginTwoFactor(w http.ResponseWriter, r *http.Request, authenticator Authenticator) {
+	log.Println("Checking two-factor code for login")
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode login two-factor JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ChallengeToken == "" || req.Code == "" {
+		log.Println("Missing challenge token or code")
+		http.Error(w, "challenge_token and code are required", http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := authenticator.ParseChallengeToken(req.ChallengeToken)
+	if err != nil {
+		log.Printf("Invalid challenge token: %v", err)
+		http.Error(w, "invalid challenge token", http.StatusUnauthorized)
+		return
+	}
+	if !verifyTwoFactorCode(w, challenge.UserId, req.Code, http.StatusUnauthorized) {
+		return
+	}
+
+	// Токен подтверждения одноразовый
+	err = stores.GetTokenStore().RevokeToken(domain.RevokedToken{Jti: challenge.Jti, ExpiresAt: challenge.ExpiresAt.UTC()})
+	if err != nil {
+		log.Printf("Failed to revoke challenge token: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := startSession(authenticator, challenge.UserId, r)
+	if err != nil {
+		log.Printf("Failed to generate token for user %d: %v", challenge.UserId, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Successfully logged in user %d with two-factor code", challenge.UserId)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Проверяет код из приложения или код восстановления и при ошибке отвечает сам: неверный код -
+// invalidStatus, заблокированная после ошибок проверка - 429. Возвращает true, если код принят
+func verifyTwoFactorCode(w http.ResponseWriter, userId domain.UserId, code string, invalidStatus int) bool {
+	err := stores.GetTotpStore().VerifyCode(userId, code)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, stores.ErrInvalidCode):
+		log.Printf("Invalid two-factor code of user %d", userId)
+		http.Error(w, "invalid code", invalidStatus)
+	case errors.Is(err, stores.ErrTooManyAttempts):
+		log.Printf("Too many invalid two-factor codes of user %d", userId)
+		http.Error(w, "too many attempts, try again later", http.StatusTooManyRequests)
+	case errors.Is(err, stores.ErrTotpNotEnabled):
+		log.Printf("Two-factor authentication of user %d is not enabled", userId)
+		http.Error(w, "two-factor authentication is not enabled", http.StatusBadRequest)
+	default:
+		log.Printf("Failed to verify two-factor code of user %d: %v", userId, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
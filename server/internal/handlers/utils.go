@@ -3,12 +3,20 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"yuki_buy_log/internal/auth"
 	"yuki_buy_log/internal/domain"
 	"yuki_buy_log/internal/stores"
+	"yuki_buy_log/internal/units"
 )
 
 type Authenticator interface {
-	GenerateToken(userId domain.UserId) (string, error)
+	GenerateToken(userId domain.UserId, familyId domain.TokenFamilyId) (domain.AccessToken, error)
+	GenerateChallengeToken(userId domain.UserId) (domain.AccessToken, error)
+	ParseChallengeToken(token string) (domain.AccessToken, error)
+	JWKS() auth.JWKSet
 }
 
 // Вспомогательная функция для получения пользователя из контекста
@@ -32,3 +40,91 @@ func getUser(r *http.Request) (user *domain.User, err error) {
 	}
 	return user, nil
 }
+
+// Возвращает id всех участников группы пользователя, либо только его id, если он не в группе
+func getGroupUserIds(userId domain.UserId) []domain.UserId {
+	groupStore := stores.GetGroupStore()
+	group := groupStore.GetGroupByUserId(userId)
+	if group == nil || len(group.Members) == 0 {
+		return []domain.UserId{userId}
+	}
+
+	userIds := make([]domain.UserId, len(group.Members))
+	for i, member := range group.Members {
+		userIds[i] = member.UserId
+	}
+	return userIds
+}
+
+// Заполняет поля покупки, которые клиент может не передавать.
+// Клиенты без поддержки весовых товаров не передают единицу количества, для них это штуки.
+// Валюта по умолчанию - валюта пользователя
+func setPurchaseDefaults(p *domain.Purchase, user *domain.User) {
+	if p.QuantityUnit == "" {
+		p.QuantityUnit = units.Piece
+	}
+	if p.Currency == "" {
+		p.Currency = user.Currency
+	}
+	// Для скидки достаточно указать обычную цену или размер скидки
+	if p.RegularPrice != 0 && p.Discount == 0 {
+		p.Discount = p.RegularPrice - p.Price
+	} else if p.RegularPrice == 0 && p.Discount != 0 {
+		p.RegularPrice = p.Price + p.Discount
+	}
+}
+
+// Возвращает валюту отчетов пользователя: для группы это валюта ее первого участника,
+// иначе валюта самого пользователя
+func getReportingCurrency(user *domain.User) string {
+	group := stores.GetGroupStore().GetGroupByUserId(user.Id)
+	if group == nil || len(group.Members) == 0 {
+		return user.Currency
+	}
+
+	first := group.Members[0]
+	for _, member := range group.Members {
+		if member.MemberNumber < first.MemberNumber {
+			first = member
+		}
+	}
+	if owner := stores.GetUserStore().GetUserById(first.UserId); owner != nil {
+		return owner.Currency
+	}
+	return user.Currency
+}
+
+// Разбирает дату из query-параметра в формате 2006-01-02 или RFC3339
+func parseDateParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// Приводит произвольную строку (название из чека или файла) к виду, который принимает валидатор:
+// только буквы, цифры и пробелы, не длиннее maxLen байт. Обрезается по границе слова, если это возможно
+func cleanName(s string, maxLen int) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return ' '
+	}, s)
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) <= maxLen {
+		return s
+	}
+	if i := strings.LastIndex(s[:maxLen+1], " "); i > 0 {
+		return s[:i]
+	}
+	// Одно длинное слово обрезаем по границе символа
+	cut := 0
+	for i := range s {
+		if i > maxLen {
+			break
+		}
+		cut = i
+	}
+	return s[:cut]
+}
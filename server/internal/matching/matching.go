@@ -0,0 +1,110 @@
+package matching
+
+import (
+	"strings"
+	"unicode"
+)
+
+// cyrillicToLatin maps lowercase Cyrillic letters to their Latin transliteration
+var cyrillicToLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "h", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "sch", 'ъ': "",
+	'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+// Transliterate replaces Cyrillic letters with Latin ones, the string is expected to be lowercase.
+func Transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if latin, ok := cyrillicToLatin[r]; ok {
+			b.WriteString(latin)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Normalize lowercases and transliterates a string, replaces punctuation with spaces
+// and collapses whitespace, so that "Молоко  1Л" and "moloko 1l" become equal.
+func Normalize(s string) string {
+	s = Transliterate(strings.ToLower(s))
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' {
+			return r
+		}
+		return ' '
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// NormalizeVolume normalizes a volume string: "1,0 Л" -> "1.0l".
+func NormalizeVolume(s string) string {
+	return strings.ReplaceAll(Normalize(strings.ReplaceAll(s, ",", ".")), " ", "")
+}
+
+// Levenshtein returns the edit distance between two strings measured in runes.
+func Levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 {
+		return len(rb)
+	}
+	if len(rb) == 0 {
+		return len(ra)
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// Similarity returns a similarity score between 0 and 1 for two already normalized strings,
+// based on the edit distance relative to the longer string.
+func Similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	maxLen := max(len([]rune(a)), len([]rune(b)))
+	if maxLen == 0 {
+		return 1
+	}
+	return 1 - float64(Levenshtein(a, b))/float64(maxLen)
+}
+
+// tokenMatchThreshold is the minimal similarity of two words to be considered the same word
+const tokenMatchThreshold = 0.8
+
+// TokenCoverage returns the share of words of b that have a similar word in a, for two already
+// normalized strings. It finds "moloko prostokvashino" in "prostokvashino moloko past 2.5".
+func TokenCoverage(a, b string) float64 {
+	aTokens, bTokens := strings.Fields(a), strings.Fields(b)
+	if len(bTokens) == 0 {
+		return 0
+	}
+	found := 0
+	for _, bToken := range bTokens {
+		for _, aToken := range aTokens {
+			if Similarity(aToken, bToken) >= tokenMatchThreshold {
+				found++
+				break
+			}
+		}
+	}
+	return float64(found) / float64(len(bTokens))
+}
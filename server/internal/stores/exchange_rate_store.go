@@ -0,0 +1,131 @@
+package stores
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+	"yuki_buy_log/internal/currency"
+	"yuki_buy_log/internal/database"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/utils"
+)
+
+type ExchangeRateStore struct {
+	// Курсы по валютам, отсортированные по дате
+	data  map[string][]domain.ExchangeRate
+	base  string
+	mutex sync.RWMutex
+	db    database.DatabaseManager
+}
+
+var (
+	exchangeRateStoreInstance *ExchangeRateStore
+	exchangeRateStoreLock     sync.Once
+)
+
+func GetExchangeRateStore() *ExchangeRateStore {
+	exchangeRateStoreLock.Do(func() {
+		var db, _ = database.GetDBManager()
+		rates, err := db.GetAllExchangeRates()
+		if err != nil {
+			rates = []domain.ExchangeRate{}
+		}
+
+		exchangeRateStoreInstance = &ExchangeRateStore{
+			data: make(map[string][]domain.ExchangeRate),
+			base: utils.ExchangeRatesBase,
+			db:   *db,
+		}
+		exchangeRateStoreInstance.put(rates)
+	})
+	return exchangeRateStoreInstance
+}
+
+// put добавляет курсы в локальный стор, заменяя курсы на те же даты. Вызывается под mutex.Lock
+func (s *ExchangeRateStore) put(rates []domain.ExchangeRate) {
+	for _, rate := range rates {
+		list := s.data[rate.Currency]
+		i := sort.Search(len(list), func(i int) bool { return !list[i].Date.Before(rate.Date) })
+		if i < len(list) && list[i].Date.Equal(rate.Date) {
+			list[i] = rate
+			continue
+		}
+		list = append(list, domain.ExchangeRate{})
+		copy(list[i+1:], list[i:])
+		list[i] = rate
+		s.data[rate.Currency] = list
+	}
+}
+
+// LoadRates сохраняет курсы, например загруженные из CSV-файла
+func (s *ExchangeRateStore) LoadRates(rates []domain.ExchangeRate) error {
+	// Добавляем в БД
+	err := s.db.UpsertExchangeRates(rates)
+	if err != nil {
+		return err
+	}
+
+	// Обновляем локальный стор
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.put(rates)
+	return nil
+}
+
+// rateOn возвращает последний известный курс валюты на дату. Вызывается под mutex.RLock
+func (s *ExchangeRateStore) rateOn(code string, date time.Time) (float64, bool) {
+	if code == s.base {
+		return 1, true
+	}
+	list := s.data[code]
+	i := sort.Search(len(list), func(i int) bool { return list[i].Date.After(date) })
+	if i == 0 {
+		return 0, false
+	}
+	return list[i-1].Rate, true
+}
+
+// Convert переводит сумму в минимальных единицах валюты from в минимальные единицы валюты to
+// по курсу на дату. Если на дату нет курса, берется последний более ранний.
+// Возвращает false, если курса на дату или раньше нет.
+func (s *ExchangeRateStore) Convert(amount float64, from string, to string, date time.Time) (int64, bool) {
+	if from == to {
+		return int64(math.Round(amount)), true
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	fromRate, ok := s.rateOn(from, date)
+	if !ok {
+		return 0, false
+	}
+	toRate, ok := s.rateOn(to, date)
+	if !ok {
+		return 0, false
+	}
+	return currency.ConvertMinor(amount, from, fromRate, to, toRate), true
+}
+
+// Rate возвращает курс валюты from к валюте to на дату: стоимость одной единицы from в единицах to.
+// Возвращает false, если курса на дату или раньше нет.
+func (s *ExchangeRateStore) Rate(from string, to string, date time.Time) (float64, bool) {
+	if from == to {
+		return 1, true
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	fromRate, ok := s.rateOn(from, date)
+	if !ok {
+		return 0, false
+	}
+	toRate, ok := s.rateOn(to, date)
+	if !ok {
+		return 0, false
+	}
+	return fromRate / toRate, true
+}
@@ -18,6 +18,8 @@ type GroupStore struct {
 var (
 	ErrMaxMembersInGroup = errors.New("max members in group")
 	ErrNotFound          = errors.New("not found")
+	ErrAlreadyExists     = errors.New("already exists")
+	ErrInUse             = errors.New("in use")
 )
 
 var (
@@ -263,6 +265,7 @@ func (s *GroupStore) DeleteUserFromGroup(userId domain.UserId) error {
 	// Удаляем из БД
 	err := s.db.DeleteUserFromGroup(userId)
 	if err != nil {
+		s.mutex.Unlock()
 		return err
 	}
 
@@ -278,6 +281,27 @@ func (s *GroupStore) DeleteUserFromGroup(userId domain.UserId) error {
 	return nil
 }
 
+// renameMember обновляет логин участника группы, уже измененный в БД
+func (s *GroupStore) renameMember(userId domain.UserId, login string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	groupId, ok := s.groupIdByUserId[userId]
+	if !ok {
+		return
+	}
+	group := s.groupById[groupId]
+	members := make([]domain.GroupMember, len(group.Members))
+	copy(members, group.Members)
+	for i := range members {
+		if members[i].UserId == userId {
+			members[i].Login = login
+		}
+	}
+	group.Members = members
+	s.groupById[groupId] = group
+}
+
 // DeleteGroupById удаляет всю группу
 func (s *GroupStore) DeleteGroupById(id domain.GroupId) error {
 	// Удаляем из локального store
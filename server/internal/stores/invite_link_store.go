@@ -0,0 +1,173 @@
+package stores
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+	"yuki_buy_log/internal/database"
+	"yuki_buy_log/internal/domain"
+)
+
+type InviteLinkStore struct {
+	data  map[domain.InviteLinkId]domain.InviteLink
+	mutex sync.RWMutex
+	db    database.DatabaseManager
+}
+
+var (
+	inviteLinkStoreInstance *InviteLinkStore
+	inviteLinkStoreLock     sync.Once
+)
+
+func GetInviteLinkStore() *InviteLinkStore {
+	inviteLinkStoreLock.Do(func() {
+		var db, _ = database.GetDBManager()
+		links, err := db.GetAllInviteLinks()
+		if err != nil {
+			links = []domain.InviteLink{}
+		}
+
+		// Преобразуем список ссылок в map[InviteLinkId]InviteLink
+		linkMap := make(map[domain.InviteLinkId]domain.InviteLink)
+		for _, link := range links {
+			linkMap[link.Id] = link
+		}
+
+		inviteLinkStoreInstance = &InviteLinkStore{
+			data: linkMap,
+			db:   *db,
+		}
+	})
+	return inviteLinkStoreInstance
+}
+
+// GetInviteLinkByTokenHash возвращает ссылку по хешу токена
+func (s *InviteLinkStore) GetInviteLinkByTokenHash(tokenHash string) *domain.InviteLink {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, link := range s.data {
+		if link.TokenHash == tokenHash {
+			linkCopy := link
+			return &linkCopy
+		}
+	}
+	return nil
+}
+
+// GetInviteLinksByUserId возвращает все ссылки, созданные пользователем
+func (s *InviteLinkStore) GetInviteLinksByUserId(userId domain.UserId) []domain.InviteLink {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var links []domain.InviteLink
+	for _, link := range s.data {
+		if link.UserId == userId {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// CreateInviteLink сохраняет новую ссылку
+func (s *InviteLinkStore) CreateInviteLink(link *domain.InviteLink) error {
+	// Добавляем в БД
+	err := s.db.CreateInviteLink(link)
+	if err != nil {
+		return err
+	}
+
+	// Обновляем локальный стор
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data[link.Id] = *link
+	return nil
+}
+
+// UseInviteLink списывает одно использование ссылки.
+// Возвращает ErrNotFound, если ссылка истекла или исчерпана.
+func (s *InviteLinkStore) UseInviteLink(id domain.InviteLinkId) error {
+	// Счетчик увеличивается в БД атомарно, чтобы не превысить max_uses при параллельных запросах
+	uses, err := s.db.UseInviteLink(id, time.Now().UTC())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	s.setUses(id, uses)
+	return nil
+}
+
+// ReleaseInviteLinkUse возвращает ранее списанное использование ссылки
+func (s *InviteLinkStore) ReleaseInviteLinkUse(id domain.InviteLinkId) error {
+	uses, err := s.db.ReleaseInviteLinkUse(id)
+	if err != nil {
+		return err
+	}
+
+	s.setUses(id, uses)
+	return nil
+}
+
+func (s *InviteLinkStore) setUses(id domain.InviteLinkId, uses int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if link, ok := s.data[id]; ok {
+		link.Uses = uses
+		s.data[id] = link
+	}
+}
+
+// DeleteInviteLink удаляет ссылку пользователя
+func (s *InviteLinkStore) DeleteInviteLink(id domain.InviteLinkId, userId domain.UserId) error {
+	// Удаляем из БД
+	err := s.db.DeleteInviteLink(id, userId)
+	if err != nil {
+		return err
+	}
+
+	// Удаляем из локального стора
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.data, id)
+	return nil
+}
+
+// DeleteExpiredInviteLinks удаляет истекшие и полностью использованные ссылки
+func (s *InviteLinkStore) DeleteExpiredInviteLinks(now time.Time) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Удаляем из БД
+	rowsAffected, err := s.db.DeleteExpiredInviteLinks(now)
+	if err != nil {
+		return 0, err
+	}
+
+	// Удаляем из локального стора
+	for id, link := range s.data {
+		if !link.ExpiresAt.After(now) || link.Uses >= link.MaxUses {
+			delete(s.data, id)
+		}
+	}
+
+	return rowsAffected, nil
+}
+
+// deleteUserInviteLinks удаляет из локального стора ссылки пользователя, уже удаленные в БД
+func (s *InviteLinkStore) deleteUserInviteLinks(userId domain.UserId) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, link := range s.data {
+		if link.UserId == userId {
+			delete(s.data, id)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package stores
 
 import (
+	"errors"
 	"sync"
 	"time"
 	"yuki_buy_log/internal/database"
@@ -85,26 +86,22 @@ func (s *InviteStore) GetInvite(fromUserId, toUserId domain.UserId) *domain.Invi
 	return nil
 }
 
+// AddInvite сохраняет инвайт в БД и только после этого добавляет его в локальный стор.
+// Id и время создания берутся из БД.
 func (s *InviteStore) AddInvite(invite domain.Invite) (domain.InviteId, error) {
 	// Добавляем в БД
-	//_, err := database.CreateInvite(fromUserId, toUserId)
-	//if err != nil {
-	//	return 0, err
-	//}
+	err := s.db.CreateInvite(&invite)
+	if err != nil {
+		if errors.Is(err, database.ErrUniqueViolation) {
+			return 0, ErrAlreadyExists
+		}
+		return 0, err
+	}
 
 	// Добавляем в локальный стор
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	var maxId = domain.InviteId(0)
-	for _, item := range s.data {
-		if item.Id > maxId {
-			maxId = item.Id
-		}
-	}
-
-	// При добавлении нового инвайта добавляем его с Id больше на 1
-	invite.Id = maxId + 1
 	s.data = append(s.data, invite)
 
 	return invite.Id, nil
@@ -133,6 +130,72 @@ func (s *InviteStore) DeleteInvites(fromUserId, toUserId domain.UserId) error {
 	return nil
 }
 
+// DeclineInvite удаляет входящий инвайт. Отклонить инвайт может только тот, кому он отправлен
+func (s *InviteStore) DeclineInvite(id domain.InviteId, toUserId domain.UserId) error {
+	return s.deleteInvite(id, func(invite domain.Invite) bool { return invite.ToUserId == toUserId })
+}
+
+// CancelInvite удаляет исходящий инвайт. Отозвать инвайт может только тот, кто его отправил
+func (s *InviteStore) CancelInvite(id domain.InviteId, fromUserId domain.UserId) error {
+	return s.deleteInvite(id, func(invite domain.Invite) bool { return invite.FromUserId == fromUserId })
+}
+
+// deleteInvite удаляет инвайт по id, если он проходит проверку владельца
+func (s *InviteStore) deleteInvite(id domain.InviteId, isOwner func(domain.Invite) bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	index := -1
+	for i, invite := range s.data {
+		if invite.Id == id && isOwner(invite) {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return ErrNotFound
+	}
+
+	// Удаляем из БД
+	err := s.db.DeleteInvite(id)
+	if err != nil {
+		return err
+	}
+
+	// Удаляем из локального стора
+	s.data = append(s.data[:index], s.data[index+1:]...)
+	return nil
+}
+
+// renameUser обновляет логин пользователя во входящих и исходящих инвайтах, уже измененный в БД
+func (s *InviteStore) renameUser(userId domain.UserId, login string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := range s.data {
+		if s.data[i].FromUserId == userId {
+			s.data[i].FromLogin = login
+		}
+		if s.data[i].ToUserId == userId {
+			s.data[i].ToLogin = login
+		}
+	}
+}
+
+// deleteUserInvites удаляет из локального стора входящие и исходящие инвайты пользователя, уже удаленные в БД
+func (s *InviteStore) deleteUserInvites(userId domain.UserId) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var newData []domain.Invite
+	for _, invite := range s.data {
+		if invite.FromUserId != userId && invite.ToUserId != userId {
+			newData = append(newData, invite)
+		}
+	}
+	s.data = newData
+}
+
 func (s *InviteStore) DeleteOldInvites(cutoffTime time.Time) (int64, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
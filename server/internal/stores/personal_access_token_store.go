@@ -0,0 +1,120 @@
+package stores
+
+import (
+	"sync"
+	"yuki_buy_log/internal/database"
+	"yuki_buy_log/internal/domain"
+)
+
+// PersonalAccessTokenStore хранит персональные токены. Токен проверяется на каждом запросе,
+// поэтому кроме токенов по id хранится индекс по хешу
+type PersonalAccessTokenStore struct {
+	data   map[domain.PersonalAccessTokenId]domain.PersonalAccessToken
+	byHash map[string]domain.PersonalAccessTokenId
+	mutex  sync.RWMutex
+	db     database.DatabaseManager
+}
+
+var (
+	personalAccessTokenStoreInstance *PersonalAccessTokenStore
+	personalAccessTokenStoreLock     sync.Once
+)
+
+func GetPersonalAccessTokenStore() *PersonalAccessTokenStore {
+	personalAccessTokenStoreLock.Do(func() {
+		var db, _ = database.GetDBManager()
+		tokens, err := db.GetAllPersonalAccessTokens()
+		if err != nil {
+			tokens = []domain.PersonalAccessToken{}
+		}
+
+		personalAccessTokenStoreInstance = &PersonalAccessTokenStore{
+			data:   make(map[domain.PersonalAccessTokenId]domain.PersonalAccessToken),
+			byHash: make(map[string]domain.PersonalAccessTokenId),
+			db:     *db,
+		}
+		for _, token := range tokens {
+			personalAccessTokenStoreInstance.put(token)
+		}
+	})
+	return personalAccessTokenStoreInstance
+}
+
+// put добавляет токен в локальный стор, вызывается под мьютексом или при инициализации
+func (s *PersonalAccessTokenStore) put(token domain.PersonalAccessToken) {
+	s.data[token.Id] = token
+	s.byHash[token.TokenHash] = token.Id
+}
+
+// GetPersonalAccessToken возвращает токен по хешу
+func (s *PersonalAccessTokenStore) GetPersonalAccessToken(tokenHash string) *domain.PersonalAccessToken {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	id, ok := s.byHash[tokenHash]
+	if !ok {
+		return nil
+	}
+	tokenCopy := s.data[id]
+	return &tokenCopy
+}
+
+// GetPersonalAccessTokensByUserId возвращает все токены пользователя
+func (s *PersonalAccessTokenStore) GetPersonalAccessTokensByUserId(userId domain.UserId) []domain.PersonalAccessToken {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var tokens []domain.PersonalAccessToken
+	for _, token := range s.data {
+		if token.UserId == userId {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// CreatePersonalAccessToken сохраняет новый токен
+func (s *PersonalAccessTokenStore) CreatePersonalAccessToken(token *domain.PersonalAccessToken) error {
+	// Добавляем в БД
+	err := s.db.CreatePersonalAccessToken(token)
+	if err != nil {
+		return err
+	}
+
+	// Обновляем локальный стор
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.put(*token)
+	return nil
+}
+
+// DeletePersonalAccessToken отзывает токен пользователя
+func (s *PersonalAccessTokenStore) DeletePersonalAccessToken(id domain.PersonalAccessTokenId, userId domain.UserId) error {
+	// Удаляем из БД
+	err := s.db.DeletePersonalAccessToken(id, userId)
+	if err != nil {
+		return err
+	}
+
+	// Удаляем из локального стора
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.byHash, s.data[id].TokenHash)
+	delete(s.data, id)
+	return nil
+}
+
+// deleteUserTokens удаляет из локального стора токены пользователя, уже удаленные в БД
+func (s *PersonalAccessTokenStore) deleteUserTokens(userId domain.UserId) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, token := range s.data {
+		if token.UserId == userId {
+			delete(s.byHash, token.TokenHash)
+			delete(s.data, id)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package stores
+
+import (
+	"math"
+	"sort"
+	"time"
+	"yuki_buy_log/internal/domain"
+)
+
+// PriceHistoryQuery параметры истории цены продукта. Нулевые DateFrom и DateTo не ограничивают период
+type PriceHistoryQuery struct {
+	UserIds   []domain.UserId
+	ProductId domain.ProductId
+	DateFrom  time.Time
+	DateTo    time.Time
+	// Окна изменения цены в днях
+	WindowDays []int
+	// Валюта отчета, в нее переводятся цены по курсу на дату покупки
+	Currency string
+}
+
+// PriceHistory возвращает цены продукта в покупках пользователей (для группы) по датам,
+// минимум, максимум, медиану и изменение цены за каждое окно из WindowDays.
+// Изменение считается от последней цены к цене, действовавшей за WindowDays дней до последней покупки.
+// Покупки без курса валюты на дату не учитываются и считаются в Unconverted.
+func (s *PurchaseStore) PriceHistory(q PriceHistoryQuery) (domain.PriceHistory, error) {
+	purchases, _, err := s.QueryPurchases(PurchaseQuery{
+		UserIds:   q.UserIds,
+		ProductId: q.ProductId,
+		DateFrom:  q.DateFrom,
+		DateTo:    q.DateTo,
+		SortBy:    PurchaseSortDate,
+	})
+	if err != nil {
+		return domain.PriceHistory{}, err
+	}
+
+	history := domain.PriceHistory{
+		ProductId: q.ProductId,
+		Currency:  q.Currency,
+		Points:    make([]domain.PricePoint, 0, len(purchases)),
+		Changes:   []domain.PriceChange{},
+	}
+
+	rates := GetExchangeRateStore()
+	product := GetProductStore().GetProductById(q.ProductId)
+	prices := make([]int, 0, len(purchases))
+	for _, p := range purchases {
+		price, ok := rates.Convert(float64(p.Price), p.Currency, q.Currency, p.Date)
+		if !ok {
+			history.Unconverted++
+			continue
+		}
+		p.Price = int(price)
+
+		point := domain.PricePoint{
+			PurchaseId: p.Id,
+			Date:       p.Date,
+			Store:      p.Store,
+			Price:      p.Price,
+		}
+		// Цена за единицу сравнима, только если все точки в одной единице: берется единица первой точки
+		if price, unit := pricePerUnit(p, product); unit != "" && (history.Unit == "" || history.Unit == unit) {
+			history.Unit, point.PricePerUnit = unit, price
+		}
+		history.Points = append(history.Points, point)
+		prices = append(prices, p.Price)
+	}
+	if len(prices) == 0 {
+		return history, nil
+	}
+
+	sort.Ints(prices)
+	minPrice, maxPrice := prices[0], prices[len(prices)-1]
+	median := float64(prices[len(prices)/2])
+	if len(prices)%2 == 0 {
+		median = float64(prices[len(prices)/2-1]+prices[len(prices)/2]) / 2
+	}
+	history.Min, history.Max, history.Median = &minPrice, &maxPrice, &median
+
+	last := history.Points[len(history.Points)-1]
+	for _, days := range q.WindowDays {
+		change := domain.PriceChange{WindowDays: days, ToPrice: last.Price}
+		windowStart := last.Date.AddDate(0, 0, -days)
+
+		// Ищем последнюю цену не позже начала окна, точки отсортированы по дате
+		i := sort.Search(len(history.Points), func(i int) bool {
+			return history.Points[i].Date.After(windowStart)
+		})
+		if i > 0 && history.Points[i-1].Price > 0 {
+			fromPrice := history.Points[i-1].Price
+			percent := math.Round(float64(last.Price-fromPrice)/float64(fromPrice)*10000) / 100
+			change.FromPrice, change.Percent = &fromPrice, &percent
+		}
+		history.Changes = append(history.Changes, change)
+	}
+
+	return history, nil
+}
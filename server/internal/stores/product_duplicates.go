@@ -0,0 +1,102 @@
+package stores
+
+import (
+	"math"
+	"sort"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/matching"
+)
+
+// Веса названия и бренда в итоговой оценке похожести продуктов
+const (
+	duplicateNameWeight  = 0.7
+	duplicateBrandWeight = 0.3
+)
+
+type normalizedProduct struct {
+	product domain.Product
+	name    string
+	brand   string
+}
+
+// productSimilarity оценивает похожесть двух продуктов с одинаковым объемом
+func productSimilarity(a, b normalizedProduct) float64 {
+	return duplicateNameWeight*matching.Similarity(a.name, b.name) +
+		duplicateBrandWeight*matching.Similarity(a.brand, b.brand)
+}
+
+// FindDuplicates ищет вероятные дубликаты среди продуктов пользователей (для группы).
+// Сравниваются только продукты с одинаковым нормализованным объемом, продукты с похожестью
+// не ниже threshold объединяются в кластеры. Оценка кластера - минимальная похожесть пары в нем.
+func (s *ProductStore) FindDuplicates(userIds []domain.UserId, threshold float64) []domain.DuplicateCluster {
+	products := s.GetProductsByUserIds(userIds)
+
+	// Разбиваем продукты по объему, чтобы не сравнивать "Молоко 1л" с "Молоко 2л"
+	byVolume := make(map[string][]normalizedProduct)
+	for _, product := range products {
+		volume := matching.NormalizeVolume(product.Volume)
+		byVolume[volume] = append(byVolume[volume], normalizedProduct{
+			product: product,
+			name:    matching.Normalize(product.Name),
+			brand:   matching.Normalize(product.Brand),
+		})
+	}
+
+	var clusters []domain.DuplicateCluster
+	for _, items := range byVolume {
+		// Объединяем похожие пары в кластеры через систему непересекающихся множеств
+		parent := make([]int, len(items))
+		for i := range parent {
+			parent[i] = i
+		}
+		var find func(int) int
+		find = func(i int) int {
+			if parent[i] != i {
+				parent[i] = find(parent[i])
+			}
+			return parent[i]
+		}
+
+		scores := make(map[[2]int]float64)
+		for i := 0; i < len(items); i++ {
+			for j := i + 1; j < len(items); j++ {
+				score := productSimilarity(items[i], items[j])
+				scores[[2]int{i, j}] = score
+				if score >= threshold {
+					parent[find(i)] = find(j)
+				}
+			}
+		}
+
+		members := make(map[int][]int)
+		for i := range items {
+			root := find(i)
+			members[root] = append(members[root], i)
+		}
+
+		for _, indexes := range members {
+			if len(indexes) < 2 {
+				continue
+			}
+			cluster := domain.DuplicateCluster{Score: 1}
+			for a, i := range indexes {
+				cluster.Products = append(cluster.Products, items[i].product)
+				for _, j := range indexes[a+1:] {
+					cluster.Score = math.Min(cluster.Score, scores[[2]int{min(i, j), max(i, j)}])
+				}
+			}
+			cluster.Score = math.Round(cluster.Score*1000) / 1000
+			sort.Slice(cluster.Products, func(i, j int) bool { return cluster.Products[i].Id < cluster.Products[j].Id })
+			clusters = append(clusters, cluster)
+		}
+	}
+
+	// Сначала самые похожие кластеры
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Score != clusters[j].Score {
+			return clusters[i].Score > clusters[j].Score
+		}
+		return clusters[i].Products[0].Id < clusters[j].Products[0].Id
+	})
+	return clusters
+}
@@ -0,0 +1,51 @@
+package stores
+
+import (
+	"sort"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/matching"
+	"yuki_buy_log/internal/units"
+)
+
+// Минимальная похожесть названия позиции чека на продукт, чтобы считать их одним продуктом
+const productMatchThreshold = 0.8
+
+// Вес совпадения только по названию продукта без бренда: "Молоко" найдется в "Молоко Простоквашино 2,5%",
+// но продукт, у которого совпал и бренд, окажется похожее
+const productNameOnlyWeight = 0.85
+
+// MatchProduct ищет среди продуктов пользователей (для группы) продукт, наиболее похожий на название
+// позиции чека или строки импорта. Если volume распознан, продукты с другим объемом не рассматриваются.
+// Возвращает nil, если похожесть лучшего продукта ниже порога.
+func (s *ProductStore) MatchProduct(userIds []domain.UserId, name string, volume string) (*domain.Product, float64) {
+	products := s.GetProductsByUserIds(userIds)
+	sort.Slice(products, func(i, j int) bool { return products[i].Id < products[j].Id })
+
+	itemVolume, hasVolume := units.ParseVolume(volume)
+	itemName := matching.Normalize(name)
+
+	var best *domain.Product
+	bestScore := 0.0
+	for i, product := range products {
+		if hasVolume && product.Unit != "" && (product.Unit != itemVolume.Unit || product.UnitAmount != itemVolume.Amount) {
+			continue
+		}
+		productName := matching.Normalize(product.Name)
+		withBrand := matching.Normalize(product.Name + " " + product.Brand)
+		score := max(
+			matching.Similarity(itemName, productName),
+			matching.Similarity(itemName, withBrand),
+			matching.Similarity(itemName, matching.Normalize(product.Brand+" "+product.Name)),
+			matching.TokenCoverage(itemName, withBrand),
+			productNameOnlyWeight*matching.TokenCoverage(itemName, productName),
+		)
+		if score > bestScore {
+			best, bestScore = &products[i], score
+		}
+	}
+
+	if bestScore < productMatchThreshold {
+		return nil, 0
+	}
+	return best, bestScore
+}
@@ -1,6 +1,7 @@
 package stores
 
 import (
+	"errors"
 	"sync"
 	"yuki_buy_log/internal/database"
 	"yuki_buy_log/internal/domain"
@@ -28,6 +29,10 @@ func GetProductStore() *ProductStore {
 		// Преобразуем список продуктов в map[ProductId]Product
 		productMap := make(map[domain.ProductId]domain.Product)
 		for _, product := range products {
+			// Продукты, созданные до нормализации объема, получают ее при загрузке
+			if product.Unit == "" {
+				normalizeVolume(&product)
+			}
 			productMap[product.Id] = product
 		}
 
@@ -88,6 +93,8 @@ func (s *ProductStore) GetProductsByUserIds(userIds []domain.UserId) []domain.Pr
 
 // CreateProduct создает новый продукт
 func (s *ProductStore) CreateProduct(product *domain.Product) error {
+	normalizeVolume(product)
+
 	// Добавляем в БД
 	err := s.db.CreateProduct(product)
 	if err != nil {
@@ -102,8 +109,41 @@ func (s *ProductStore) CreateProduct(product *domain.Product) error {
 	return nil
 }
 
+// putProducts добавляет в локальный стор продукты, уже созданные в БД. nil пропускаются
+func (s *ProductStore) putProducts(products []*domain.Product) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, product := range products {
+		if product != nil {
+			s.data[product.Id] = *product
+		}
+	}
+}
+
+// deleteUserProducts обновляет локальный стор после удаления пользователя: переданные продукты
+// получают нового владельца (0 у обезличенных), остальные продукты пользователя удаляются
+func (s *ProductStore) deleteUserProducts(userId domain.UserId, handedOver map[domain.ProductId]domain.UserId) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, product := range s.data {
+		if product.UserId != userId {
+			continue
+		}
+		if ownerId, ok := handedOver[id]; ok {
+			product.UserId = ownerId
+			s.data[id] = product
+		} else {
+			delete(s.data, id)
+		}
+	}
+}
+
 // UpdateProduct обновляет данные продукта
 func (s *ProductStore) UpdateProduct(product *domain.Product) error {
+	normalizeVolume(product)
+
 	// Обновляем в БД
 	err := s.db.UpdateProduct(product)
 	if err != nil {
@@ -123,6 +163,9 @@ func (s *ProductStore) DeleteProduct(id domain.ProductId, userId domain.UserId)
 	// Удаляем из БД
 	err := s.db.DeleteProduct(id, userId)
 	if err != nil {
+		if errors.Is(err, database.ErrForeignKeyViolation) {
+			return ErrInUse
+		}
 		return err
 	}
 
@@ -133,3 +176,45 @@ func (s *ProductStore) DeleteProduct(id domain.ProductId, userId domain.UserId)
 	delete(s.data, id)
 	return nil
 }
+
+// DeleteProductReassigningPurchases переносит покупки продукта на другой продукт и удаляет продукт.
+// Возвращает количество перенесенных покупок.
+func (s *ProductStore) DeleteProductReassigningPurchases(id domain.ProductId, targetId domain.ProductId, userId domain.UserId) (int64, error) {
+	// Обновляем БД в одной транзакции
+	moved, err := s.db.ReassignPurchasesAndDeleteProduct(id, targetId, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	// Обновляем локальные сторы
+	GetPurchaseStore().reassignProducts([]domain.ProductId{id}, targetId)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.data, id)
+	return moved, nil
+}
+
+// MergeProducts объединяет продукты в один: покупки переносятся на survivor,
+// теги по умолчанию уже объединены в survivor.DefaultTags, остальные продукты удаляются.
+// Возвращает количество перенесенных покупок.
+func (s *ProductStore) MergeProducts(survivor *domain.Product, mergedIds []domain.ProductId) (int64, error) {
+	// Обновляем БД в одной транзакции
+	moved, err := s.db.MergeProducts(survivor, mergedIds)
+	if err != nil {
+		return 0, err
+	}
+
+	// Обновляем локальные сторы
+	GetPurchaseStore().reassignProducts(mergedIds, survivor.Id)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data[survivor.Id] = *survivor
+	for _, id := range mergedIds {
+		delete(s.data, id)
+	}
+	return moved, nil
+}
@@ -0,0 +1,74 @@
+package stores
+
+import (
+	"strings"
+	"yuki_buy_log/internal/domain"
+)
+
+type purchaseIdSet map[domain.PurchaseId]struct{}
+
+// purchaseIndex вторичные индексы покупок, чтобы не сканировать весь стор при фильтрации
+type purchaseIndex struct {
+	byUserId    map[domain.UserId]purchaseIdSet
+	byProductId map[domain.ProductId]purchaseIdSet
+	byReceiptId map[domain.ReceiptId]purchaseIdSet
+	byStore     map[string]purchaseIdSet
+	byTag       map[string]purchaseIdSet
+}
+
+func newPurchaseIndex() *purchaseIndex {
+	return &purchaseIndex{
+		byUserId:    make(map[domain.UserId]purchaseIdSet),
+		byProductId: make(map[domain.ProductId]purchaseIdSet),
+		byReceiptId: make(map[domain.ReceiptId]purchaseIdSet),
+		byStore:     make(map[string]purchaseIdSet),
+		byTag:       make(map[string]purchaseIdSet),
+	}
+}
+
+// Магазины и теги индексируются без учета регистра
+func indexKey(s string) string {
+	return strings.ToLower(s)
+}
+
+func addToIndex[K comparable](index map[K]purchaseIdSet, key K, id domain.PurchaseId) {
+	set, ok := index[key]
+	if !ok {
+		set = make(purchaseIdSet)
+		index[key] = set
+	}
+	set[id] = struct{}{}
+}
+
+func removeFromIndex[K comparable](index map[K]purchaseIdSet, key K, id domain.PurchaseId) {
+	if set, ok := index[key]; ok {
+		delete(set, id)
+		if len(set) == 0 {
+			delete(index, key)
+		}
+	}
+}
+
+func (i *purchaseIndex) add(p domain.Purchase) {
+	addToIndex(i.byUserId, p.UserId, p.Id)
+	addToIndex(i.byProductId, p.ProductId, p.Id)
+	if p.ReceiptId != 0 {
+		addToIndex(i.byReceiptId, p.ReceiptId, p.Id)
+	}
+	addToIndex(i.byStore, indexKey(p.Store), p.Id)
+	for _, tag := range p.Tags {
+		addToIndex(i.byTag, indexKey(tag), p.Id)
+	}
+}
+
+func (i *purchaseIndex) remove(p domain.Purchase) {
+	removeFromIndex(i.byUserId, p.UserId, p.Id)
+	removeFromIndex(i.byProductId, p.ProductId, p.Id)
+	if p.ReceiptId != 0 {
+		removeFromIndex(i.byReceiptId, p.ReceiptId, p.Id)
+	}
+	removeFromIndex(i.byStore, indexKey(p.Store), p.Id)
+	for _, tag := range p.Tags {
+		removeFromIndex(i.byTag, indexKey(tag), p.Id)
+	}
+}
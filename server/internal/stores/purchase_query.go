@@ -0,0 +1,203 @@
+package stores
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"yuki_buy_log/internal/domain"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Поля сортировки покупок
+const (
+	PurchaseSortDate  = "date"
+	PurchaseSortPrice = "price"
+)
+
+// PurchaseQuery фильтр, сортировка и пагинация покупок. Нулевые значения фильтров не применяются
+type PurchaseQuery struct {
+	UserIds   []domain.UserId
+	DateFrom  time.Time
+	DateTo    time.Time
+	Store     string
+	Tag       string
+	ProductId domain.ProductId
+	PriceMin  int
+	PriceMax  int
+
+	SortBy     string
+	Descending bool
+
+	// Limit = 0 означает вернуть все покупки без пагинации
+	Limit  int
+	Cursor string
+}
+
+// purchaseCursor позиция последней отданной покупки: значение поля сортировки и id
+type purchaseCursor struct {
+	value int64
+	id    domain.PurchaseId
+}
+
+func encodePurchaseCursor(c purchaseCursor) string {
+	raw := fmt.Sprintf("%d:%d", c.value, c.id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodePurchaseCursor(s string) (purchaseCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return purchaseCursor{}, ErrInvalidCursor
+	}
+	value, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return purchaseCursor{}, ErrInvalidCursor
+	}
+	var c purchaseCursor
+	if c.value, err = strconv.ParseInt(value, 10, 64); err != nil {
+		return purchaseCursor{}, ErrInvalidCursor
+	}
+	parsedId, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return purchaseCursor{}, ErrInvalidCursor
+	}
+	c.id = domain.PurchaseId(parsedId)
+	return c, nil
+}
+
+// sortValue значение поля сортировки покупки
+func (q *PurchaseQuery) sortValue(p domain.Purchase) int64 {
+	if q.SortBy == PurchaseSortPrice {
+		return int64(p.Price)
+	}
+	return p.Date.UnixNano()
+}
+
+// less задает стабильный порядок: по полю сортировки, при равенстве по id
+func (q *PurchaseQuery) less(a, b purchaseCursor) bool {
+	if a.value != b.value {
+		return (a.value < b.value) != q.Descending
+	}
+	return (a.id < b.id) != q.Descending
+}
+
+func (q *PurchaseQuery) matches(p domain.Purchase) bool {
+	if !q.DateFrom.IsZero() && p.Date.Before(q.DateFrom) {
+		return false
+	}
+	if !q.DateTo.IsZero() && p.Date.After(q.DateTo) {
+		return false
+	}
+	if q.Store != "" && indexKey(p.Store) != indexKey(q.Store) {
+		return false
+	}
+	if q.ProductId != 0 && p.ProductId != q.ProductId {
+		return false
+	}
+	if q.PriceMin != 0 && p.Price < q.PriceMin {
+		return false
+	}
+	if q.PriceMax != 0 && p.Price > q.PriceMax {
+		return false
+	}
+	if q.Tag != "" {
+		found := false
+		for _, tag := range p.Tags {
+			if indexKey(tag) == indexKey(q.Tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// candidates выбирает по индексам наименьшее множество покупок, которое нужно проверить фильтром.
+// Вызывается под mutex.RLock
+func (s *PurchaseStore) candidates(q *PurchaseQuery) []purchaseIdSet {
+	var best []purchaseIdSet
+	bestSize := -1
+	consider := func(sets ...purchaseIdSet) {
+		size := 0
+		for _, set := range sets {
+			size += len(set)
+		}
+		if bestSize == -1 || size < bestSize {
+			best, bestSize = sets, size
+		}
+	}
+
+	byUser := make([]purchaseIdSet, 0, len(q.UserIds))
+	for _, userId := range q.UserIds {
+		byUser = append(byUser, s.index.byUserId[userId])
+	}
+	consider(byUser...)
+	if q.ProductId != 0 {
+		consider(s.index.byProductId[q.ProductId])
+	}
+	if q.Store != "" {
+		consider(s.index.byStore[indexKey(q.Store)])
+	}
+	if q.Tag != "" {
+		consider(s.index.byTag[indexKey(q.Tag)])
+	}
+	return best
+}
+
+// QueryPurchases возвращает отфильтрованные и отсортированные покупки пользователей (для группы).
+// Если задан Limit, возвращает не больше Limit покупок и курсор следующей страницы
+// (пустой, если страница последняя).
+func (s *PurchaseStore) QueryPurchases(q PurchaseQuery) ([]domain.Purchase, string, error) {
+	var after *purchaseCursor
+	if q.Cursor != "" {
+		c, err := decodePurchaseCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after = &c
+	}
+
+	userIdMap := make(map[domain.UserId]bool)
+	for _, userId := range q.UserIds {
+		userIdMap[userId] = true
+	}
+
+	s.mutex.RLock()
+	var purchases []domain.Purchase
+	for _, set := range s.candidates(&q) {
+		for id := range set {
+			p := s.data[id]
+			if !userIdMap[p.UserId] || !q.matches(p) {
+				continue
+			}
+			if after != nil && !q.less(*after, purchaseCursor{q.sortValue(p), p.Id}) {
+				continue
+			}
+			purchases = append(purchases, p)
+		}
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(purchases, func(i, j int) bool {
+		return q.less(
+			purchaseCursor{q.sortValue(purchases[i]), purchases[i].Id},
+			purchaseCursor{q.sortValue(purchases[j]), purchases[j].Id},
+		)
+	})
+
+	if q.Limit == 0 || len(purchases) <= q.Limit {
+		return purchases, "", nil
+	}
+
+	purchases = purchases[:q.Limit]
+	last := purchases[len(purchases)-1]
+	return purchases, encodePurchaseCursor(purchaseCursor{q.sortValue(last), last.Id}), nil
+}
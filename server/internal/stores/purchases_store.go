@@ -8,6 +8,7 @@ import (
 
 type PurchaseStore struct {
 	data  map[domain.PurchaseId]domain.Purchase
+	index *purchaseIndex
 	mutex sync.RWMutex
 	db    database.DatabaseManager
 }
@@ -25,38 +26,69 @@ func GetPurchaseStore() *PurchaseStore {
 			purchases = []domain.Purchase{}
 		}
 
-		// Преобразуем список покупок в map[PurchaseId]Purchase
-		purchaseMap := make(map[domain.PurchaseId]domain.Purchase)
-		for _, purchase := range purchases {
-			purchaseMap[purchase.Id] = purchase
-		}
-
 		purchaseStoreInstance = &PurchaseStore{
-			data: purchaseMap,
-			db:   *db,
+			data:  make(map[domain.PurchaseId]domain.Purchase),
+			index: newPurchaseIndex(),
+			db:    *db,
+		}
+		for _, purchase := range purchases {
+			purchaseStoreInstance.put(purchase)
 		}
 	})
 	return purchaseStoreInstance
 }
 
+// put сохраняет покупку в локальный стор и обновляет индексы. Вызывается под mutex.Lock
+func (s *PurchaseStore) put(purchase domain.Purchase) {
+	if old, ok := s.data[purchase.Id]; ok {
+		s.index.remove(old)
+	}
+	// Цена за единицу вычисляется при ответе и не хранится
+	purchase.PricePerUnit, purchase.Unit = 0, ""
+	s.data[purchase.Id] = purchase
+	s.index.add(purchase)
+}
+
+// remove удаляет покупку из локального стора и индексов. Вызывается под mutex.Lock
+func (s *PurchaseStore) remove(id domain.PurchaseId) {
+	if old, ok := s.data[id]; ok {
+		s.index.remove(old)
+		delete(s.data, id)
+	}
+}
+
+// collect возвращает покупки по множеству id из индекса. Вызывается под mutex.RLock
+func (s *PurchaseStore) collect(ids purchaseIdSet) []domain.Purchase {
+	var purchases []domain.Purchase
+	for id := range ids {
+		purchases = append(purchases, s.data[id])
+	}
+	return purchases
+}
+
 // GetPurchasesByUserIds возвращает покупки для списка пользователей (для группы)
 func (s *PurchaseStore) GetPurchasesByUserIds(userIds []domain.UserId) []domain.Purchase {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	// Создаем map для быстрого поиска
-	userIdMap := make(map[domain.UserId]bool)
+	var purchases []domain.Purchase
 	for _, userId := range userIds {
-		userIdMap[userId] = true
+		purchases = append(purchases, s.collect(s.index.byUserId[userId])...)
 	}
+	return purchases
+}
 
-	var purchases []domain.Purchase
-	for _, purchase := range s.data {
-		if userIdMap[purchase.UserId] {
-			purchases = append(purchases, purchase)
-		}
+// GetPurchaseById возвращает покупку по ID
+func (s *PurchaseStore) GetPurchaseById(id domain.PurchaseId) *domain.Purchase {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if purchase, ok := s.data[id]; ok {
+		// Возвращаем копию, чтобы избежать модификации извне
+		purchaseCopy := purchase
+		return &purchaseCopy
 	}
-	return purchases
+	return nil
 }
 
 // AddPurchase добавляет новую покупку
@@ -71,7 +103,44 @@ func (s *PurchaseStore) AddPurchase(purchase *domain.Purchase) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	s.data[purchase.Id] = *purchase
+	s.put(*purchase)
+	return nil
+}
+
+// ImportPurchases создает импортированные покупки вместе с новыми продуктами, все или ни одной.
+// newProducts[i] - новый продукт покупки purchases[i] или nil для существующего продукта
+func (s *PurchaseStore) ImportPurchases(purchases []domain.Purchase, newProducts []*domain.Product) error {
+	for _, product := range newProducts {
+		if product != nil {
+			normalizeVolume(product)
+		}
+	}
+
+	// Добавляем в БД
+	err := s.db.ImportPurchases(purchases, newProducts)
+	if err != nil {
+		return err
+	}
+
+	// Обновляем локальные сторы
+	GetProductStore().putProducts(newProducts)
+	s.putPurchases(purchases)
+	return nil
+}
+
+// UpdatePurchase обновляет данные покупки
+func (s *PurchaseStore) UpdatePurchase(purchase *domain.Purchase) error {
+	// Обновляем в БД
+	err := s.db.UpdatePurchase(purchase)
+	if err != nil {
+		return err
+	}
+
+	// Обновляем локальный стор
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.put(*purchase)
 	return nil
 }
 
@@ -87,6 +156,73 @@ func (s *PurchaseStore) DeletePurchase(purchaseId domain.PurchaseId, userId doma
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	delete(s.data, purchaseId)
+	s.remove(purchaseId)
 	return nil
 }
+
+// GetPurchasesByReceiptId возвращает все покупки чека
+func (s *PurchaseStore) GetPurchasesByReceiptId(receiptId domain.ReceiptId) []domain.Purchase {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.collect(s.index.byReceiptId[receiptId])
+}
+
+// putPurchases обновляет локальный стор покупками, уже записанными в БД
+func (s *PurchaseStore) putPurchases(purchases []domain.Purchase) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, purchase := range purchases {
+		s.put(purchase)
+	}
+}
+
+// deletePurchasesByReceiptId удаляет из локального стора покупки чека, уже удаленные в БД
+func (s *PurchaseStore) deletePurchasesByReceiptId(receiptId domain.ReceiptId) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id := range s.index.byReceiptId[receiptId] {
+		s.remove(id)
+	}
+}
+
+// deleteUserPurchases обновляет локальный стор после удаления пользователя: покупки, уже удаленные в БД,
+// удаляются, а обезличенные остаются без пользователя, чека и тегов, как их загружает GetAllPurchases
+func (s *PurchaseStore) deleteUserPurchases(userId domain.UserId, anonymize bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, purchase := range s.collect(s.index.byUserId[userId]) {
+		if !anonymize {
+			s.remove(purchase.Id)
+			continue
+		}
+		purchase.UserId = 0
+		purchase.ReceiptId = 0
+		purchase.Tags = []string{}
+		s.put(purchase)
+	}
+}
+
+// CountPurchasesByProductId возвращает количество покупок продукта
+func (s *PurchaseStore) CountPurchasesByProductId(productId domain.ProductId) int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.index.byProductId[productId])
+}
+
+// reassignProducts переносит в локальном сторе покупки на другой продукт, уже перенесенные в БД
+func (s *PurchaseStore) reassignProducts(fromIds []domain.ProductId, toId domain.ProductId) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, fromId := range fromIds {
+		for _, purchase := range s.collect(s.index.byProductId[fromId]) {
+			purchase.ProductId = toId
+			s.put(purchase)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package stores
+
+import (
+	"sort"
+	"sync"
+	"yuki_buy_log/internal/database"
+	"yuki_buy_log/internal/domain"
+)
+
+type ReceiptStore struct {
+	data  map[domain.ReceiptId]domain.Receipt
+	mutex sync.RWMutex
+	db    database.DatabaseManager
+}
+
+var (
+	receiptStoreInstance *ReceiptStore
+	receiptStoreLock     sync.Once
+)
+
+func GetReceiptStore() *ReceiptStore {
+	receiptStoreLock.Do(func() {
+		var db, _ = database.GetDBManager()
+		receipts, err := db.GetAllReceipts()
+		if err != nil {
+			receipts = []domain.Receipt{}
+		}
+
+		// Преобразуем список чеков в map[ReceiptId]Receipt
+		receiptMap := make(map[domain.ReceiptId]domain.Receipt)
+		for _, receipt := range receipts {
+			receiptMap[receipt.Id] = receipt
+		}
+
+		receiptStoreInstance = &ReceiptStore{
+			data: receiptMap,
+			db:   *db,
+		}
+	})
+	return receiptStoreInstance
+}
+
+// fillFromPurchases заполняет вычисляемые поля чека (список покупок и сумму)
+func fillFromPurchases(receipt *domain.Receipt, purchases []domain.Purchase) {
+	receipt.PurchaseIds = make([]domain.PurchaseId, 0, len(purchases))
+	receipt.Total = 0
+	for _, purchase := range purchases {
+		receipt.PurchaseIds = append(receipt.PurchaseIds, purchase.Id)
+		receipt.Total += int(purchaseAmount(purchase))
+	}
+	sort.Slice(receipt.PurchaseIds, func(i, j int) bool { return receipt.PurchaseIds[i] < receipt.PurchaseIds[j] })
+}
+
+// GetReceiptById возвращает чек по ID вместе с суммой по его покупкам
+func (s *ReceiptStore) GetReceiptById(id domain.ReceiptId) *domain.Receipt {
+	s.mutex.RLock()
+	receipt, ok := s.data[id]
+	s.mutex.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	// Возвращаем копию, чтобы избежать модификации извне
+	fillFromPurchases(&receipt, GetPurchaseStore().GetPurchasesByReceiptId(id))
+	return &receipt
+}
+
+// GetReceiptsByUserIds возвращает чеки для списка пользователей (для группы)
+func (s *ReceiptStore) GetReceiptsByUserIds(userIds []domain.UserId) []domain.Receipt {
+	// Покупки группируем по чекам один раз, чтобы не сканировать стор покупок для каждого чека
+	purchasesByReceiptId := make(map[domain.ReceiptId][]domain.Purchase)
+	for _, purchase := range GetPurchaseStore().GetPurchasesByUserIds(userIds) {
+		if purchase.ReceiptId != 0 {
+			purchasesByReceiptId[purchase.ReceiptId] = append(purchasesByReceiptId[purchase.ReceiptId], purchase)
+		}
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Создаем map для быстрого поиска
+	userIdMap := make(map[domain.UserId]bool)
+	for _, userId := range userIds {
+		userIdMap[userId] = true
+	}
+
+	var receipts []domain.Receipt
+	for _, receipt := range s.data {
+		if userIdMap[receipt.UserId] {
+			fillFromPurchases(&receipt, purchasesByReceiptId[receipt.Id])
+			receipts = append(receipts, receipt)
+		}
+	}
+	return receipts
+}
+
+// GetReceiptByFiscalId возвращает импортированный пользователем чек по фискальному id
+func (s *ReceiptStore) GetReceiptByFiscalId(userId domain.UserId, fiscalId string) *domain.Receipt {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, receipt := range s.data {
+		if receipt.UserId == userId && receipt.FiscalId == fiscalId {
+			return &receipt
+		}
+	}
+	return nil
+}
+
+// CreateReceipt создает чек вместе с покупками
+func (s *ReceiptStore) CreateReceipt(receipt *domain.Receipt, purchases []domain.Purchase) error {
+	// Добавляем в БД
+	err := s.db.CreateReceipt(receipt, purchases)
+	if err != nil {
+		return err
+	}
+
+	// Обновляем локальные сторы
+	GetPurchaseStore().putPurchases(purchases)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fillFromPurchases(receipt, purchases)
+	s.data[receipt.Id] = *receipt
+	return nil
+}
+
+// ImportReceipt создает импортированный чек вместе с покупками и новыми продуктами.
+// newProducts[i] - новый продукт покупки purchases[i] или nil для существующего продукта
+func (s *ReceiptStore) ImportReceipt(receipt *domain.Receipt, purchases []domain.Purchase, newProducts []*domain.Product) error {
+	for _, product := range newProducts {
+		if product != nil {
+			normalizeVolume(product)
+		}
+	}
+
+	// Добавляем в БД
+	err := s.db.ImportReceipt(receipt, purchases, newProducts)
+	if err != nil {
+		return err
+	}
+
+	// Обновляем локальные сторы
+	GetProductStore().putProducts(newProducts)
+	GetPurchaseStore().putPurchases(purchases)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fillFromPurchases(receipt, purchases)
+	s.data[receipt.Id] = *receipt
+	return nil
+}
+
+// UpdateReceipt обновляет чек и его покупки (дату, магазин и теги)
+func (s *ReceiptStore) UpdateReceipt(receipt *domain.Receipt, purchases []domain.Purchase) error {
+	// Обновляем в БД
+	err := s.db.UpdateReceipt(receipt, purchases)
+	if err != nil {
+		return err
+	}
+
+	// Обновляем локальные сторы
+	GetPurchaseStore().putPurchases(purchases)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fillFromPurchases(receipt, purchases)
+	s.data[receipt.Id] = *receipt
+	return nil
+}
+
+// DeleteReceipt удаляет чек вместе с покупками
+func (s *ReceiptStore) DeleteReceipt(id domain.ReceiptId, userId domain.UserId) error {
+	// Удаляем из БД
+	err := s.db.DeleteReceipt(id, userId)
+	if err != nil {
+		return err
+	}
+
+	// Удаляем из локальных сторов
+	GetPurchaseStore().deletePurchasesByReceiptId(id)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.data, id)
+	return nil
+}
+
+// deleteUserReceipts удаляет из локального стора чеки пользователя, уже удаленные в БД
+func (s *ReceiptStore) deleteUserReceipts(userId domain.UserId) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, receipt := range s.data {
+		if receipt.UserId == userId {
+			delete(s.data, id)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package stores
+
+import (
+	"sort"
+	"time"
+	"yuki_buy_log/internal/currency"
+	"yuki_buy_log/internal/domain"
+)
+
+// SavingsQuery параметры сводки экономии на скидках. Нулевые DateFrom и DateTo не ограничивают период
+type SavingsQuery struct {
+	UserIds  []domain.UserId
+	DateFrom time.Time
+	DateTo   time.Time
+	// Валюта отчета, в нее переводится экономия по курсу на дату покупки
+	Currency string
+}
+
+// savingsGroups накапливает экономию по значениям одного измерения
+type savingsGroups map[string]*domain.SavingsGroup
+
+// add добавляет экономию покупки в группу с ключом key
+func (g savingsGroups) add(key, label string, amount int64) {
+	group, ok := g[key]
+	if !ok {
+		group = &domain.SavingsGroup{Key: key, Label: label}
+		g[key] = group
+	}
+	group.Total += amount
+	group.Count++
+}
+
+// sorted возвращает группы, отсортированные функцией less
+func (g savingsGroups) sorted(less func(a, b domain.SavingsGroup) bool) []domain.SavingsGroup {
+	groups := make([]domain.SavingsGroup, 0, len(g))
+	for _, group := range g {
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return less(groups[i], groups[j]) })
+	return groups
+}
+
+// byTotalDesc сортирует группы по убыванию экономии, при равенстве по ключу
+func byTotalDesc(a, b domain.SavingsGroup) bool {
+	if a.Total != b.Total {
+		return a.Total > b.Total
+	}
+	return a.Key < b.Key
+}
+
+// SavingsSummary считает экономию на скидках (скидка × количество) в покупках пользователей (для группы)
+// за период в валюте отчета по магазинам, месяцам и типам акций. Месяцы идут по порядку, магазины и
+// типы акций - по убыванию экономии. Покупки без курса валюты на дату не учитываются и считаются в Unconverted.
+func (s *PurchaseStore) SavingsSummary(q SavingsQuery) (domain.SavingsSummary, error) {
+	if !currency.IsValid(q.Currency) {
+		return domain.SavingsSummary{}, ErrInvalidSummaryQuery
+	}
+
+	purchases, _, err := s.QueryPurchases(PurchaseQuery{
+		UserIds:  q.UserIds,
+		DateFrom: q.DateFrom,
+		DateTo:   q.DateTo,
+		SortBy:   PurchaseSortDate,
+	})
+	if err != nil {
+		return domain.SavingsSummary{}, err
+	}
+
+	summary := domain.SavingsSummary{Currency: q.Currency}
+	if !q.DateFrom.IsZero() {
+		summary.DateFrom = &q.DateFrom
+	}
+	if !q.DateTo.IsZero() {
+		summary.DateTo = &q.DateTo
+	}
+
+	byStore, byMonth, byPromoType := savingsGroups{}, savingsGroups{}, savingsGroups{}
+	rates := GetExchangeRateStore()
+	for _, p := range purchases {
+		if p.Discount == 0 {
+			continue
+		}
+		amount, ok := rates.Convert(float64(p.Discount)*p.Quantity, p.Currency, q.Currency, p.Date)
+		if !ok {
+			summary.Unconverted++
+			continue
+		}
+
+		summary.Total += amount
+		summary.Count++
+		byStore.add(indexKey(p.Store), p.Store, amount)
+		month := bucketStart(p.Date, SpendingBucketMonth).Format("2006-01")
+		byMonth.add(month, month, amount)
+		byPromoType.add(p.PromoType, p.PromoType, amount)
+	}
+
+	summary.ByStore = byStore.sorted(byTotalDesc)
+	summary.ByMonth = byMonth.sorted(func(a, b domain.SavingsGroup) bool { return a.Key < b.Key })
+	summary.ByPromoType = byPromoType.sorted(byTotalDesc)
+
+	return summary, nil
+}
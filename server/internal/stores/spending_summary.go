@@ -0,0 +1,243 @@
+package stores
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+	"yuki_buy_log/internal/currency"
+	"yuki_buy_log/internal/domain"
+)
+
+var ErrInvalidSummaryQuery = errors.New("invalid summary query")
+
+// Измерения, по которым группируются траты
+const (
+	SpendingByNone    = "none"
+	SpendingByTag     = "tag"
+	SpendingByStore   = "store"
+	SpendingByMember  = "member"
+	SpendingByProduct = "product"
+)
+
+// Временные интервалы для группировки трат
+const (
+	SpendingBucketDay   = "day"
+	SpendingBucketWeek  = "week"
+	SpendingBucketMonth = "month"
+	SpendingBucketYear  = "year"
+)
+
+// SpendingQuery параметры сводки трат. Нулевые DateFrom и DateTo не ограничивают период
+type SpendingQuery struct {
+	UserIds   []domain.UserId
+	DateFrom  time.Time
+	DateTo    time.Time
+	Dimension string
+	Bucket    string
+	// Валюта отчета, в нее переводятся суммы покупок по курсу на дату покупки
+	Currency string
+}
+
+// bucketStart возвращает начало интервала, в который попадает дата. Неделя начинается с понедельника
+func bucketStart(date time.Time, bucket string) time.Time {
+	year, month, day := date.UTC().Date()
+	switch bucket {
+	case SpendingBucketWeek:
+		offset := (int(date.UTC().Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, time.UTC)
+	case SpendingBucketMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	case SpendingBucketYear:
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// dimensionKeys возвращает значения измерения для покупки. Покупка с несколькими тегами
+// учитывается в каждом из них, покупка без тегов - под пустым ключом
+func dimensionKeys(p domain.Purchase, dimension string) []string {
+	switch dimension {
+	case SpendingByTag:
+		if len(p.Tags) == 0 {
+			return []string{""}
+		}
+		keys := make([]string, 0, len(p.Tags))
+		seen := make(map[string]bool)
+		for _, tag := range p.Tags {
+			if key := indexKey(tag); !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	case SpendingByStore:
+		return []string{indexKey(p.Store)}
+	case SpendingByMember:
+		return []string{strconv.FormatInt(int64(p.UserId), 10)}
+	case SpendingByProduct:
+		return []string{strconv.FormatInt(int64(p.ProductId), 10)}
+	default:
+		return []string{""}
+	}
+}
+
+// dimensionLabel возвращает человекочитаемое название значения измерения
+func dimensionLabel(p domain.Purchase, key string, dimension string) string {
+	switch dimension {
+	case SpendingByTag:
+		for _, tag := range p.Tags {
+			if indexKey(tag) == key {
+				return tag
+			}
+		}
+	case SpendingByStore:
+		return p.Store
+	case SpendingByMember:
+		if user := GetUserStore().GetUserById(p.UserId); user != nil {
+			return user.Login
+		}
+	case SpendingByProduct:
+		if product := GetProductStore().GetProductById(p.ProductId); product != nil {
+			return product.Name
+		}
+	}
+	return key
+}
+
+// unitAmountAccumulator купленный объем продукта в одном интервале
+type unitAmountAccumulator struct {
+	amount float64
+	unit   string
+	// Покупки в разных единицах или без объема нельзя сложить, цена за единицу не считается
+	mixed bool
+}
+
+// add добавляет купленный объем покупки
+func (a *unitAmountAccumulator) add(p domain.Purchase) {
+	amount, unit := purchaseUnitAmount(p, GetProductStore().GetProductById(p.ProductId))
+	if amount == 0 || (a.unit != "" && a.unit != unit) {
+		a.mixed = true
+		return
+	}
+	a.amount, a.unit = a.amount+amount, unit
+}
+
+// fill заполняет в группе купленный объем и среднюю цену за единицу
+func (a *unitAmountAccumulator) fill(group *domain.SpendingGroup) {
+	if a.mixed || a.amount <= 0 {
+		return
+	}
+	group.UnitAmount = math.Round(a.amount*1e6) / 1e6
+	group.Unit = a.unit
+	group.PricePerUnit = math.Round(float64(group.Total)/a.amount*100) / 100
+}
+
+// SpendingSummary считает траты (цена × количество) пользователей (для группы) за период в валюте отчета,
+// сгруппированные по временным интервалам и измерению. Интервалы без покупок не возвращаются.
+// Покупки без курса валюты на дату не учитываются и считаются в Unconverted.
+func (s *PurchaseStore) SpendingSummary(q SpendingQuery) (domain.SpendingSummary, error) {
+	switch q.Dimension {
+	case SpendingByNone, SpendingByTag, SpendingByStore, SpendingByMember, SpendingByProduct:
+	default:
+		return domain.SpendingSummary{}, ErrInvalidSummaryQuery
+	}
+	switch q.Bucket {
+	case SpendingBucketDay, SpendingBucketWeek, SpendingBucketMonth, SpendingBucketYear:
+	default:
+		return domain.SpendingSummary{}, ErrInvalidSummaryQuery
+	}
+	if !currency.IsValid(q.Currency) {
+		return domain.SpendingSummary{}, ErrInvalidSummaryQuery
+	}
+
+	purchases, _, err := s.QueryPurchases(PurchaseQuery{
+		UserIds:  q.UserIds,
+		DateFrom: q.DateFrom,
+		DateTo:   q.DateTo,
+		SortBy:   PurchaseSortDate,
+	})
+	if err != nil {
+		return domain.SpendingSummary{}, err
+	}
+
+	summary := domain.SpendingSummary{
+		Dimension: q.Dimension,
+		Bucket:    q.Bucket,
+		Currency:  q.Currency,
+		Buckets:   []domain.SpendingBucket{},
+	}
+	if !q.DateFrom.IsZero() {
+		summary.DateFrom = &q.DateFrom
+	}
+	if !q.DateTo.IsZero() {
+		summary.DateTo = &q.DateTo
+	}
+
+	// Покупки отсортированы по дате, поэтому интервалы заполняются по порядку
+	var groups map[string]*domain.SpendingGroup
+	var unitAmounts map[string]*unitAmountAccumulator
+	flush := func() {
+		if len(summary.Buckets) == 0 {
+			return
+		}
+		bucket := &summary.Buckets[len(summary.Buckets)-1]
+		bucket.Groups = make([]domain.SpendingGroup, 0, len(groups))
+		for key, group := range groups {
+			if acc, ok := unitAmounts[key]; ok {
+				acc.fill(group)
+			}
+			bucket.Groups = append(bucket.Groups, *group)
+		}
+		sort.Slice(bucket.Groups, func(i, j int) bool {
+			if bucket.Groups[i].Total != bucket.Groups[j].Total {
+				return bucket.Groups[i].Total > bucket.Groups[j].Total
+			}
+			return bucket.Groups[i].Key < bucket.Groups[j].Key
+		})
+	}
+
+	rates := GetExchangeRateStore()
+	for _, p := range purchases {
+		amount, ok := rates.Convert(float64(p.Price)*p.Quantity, p.Currency, q.Currency, p.Date)
+		if !ok {
+			summary.Unconverted++
+			continue
+		}
+
+		start := bucketStart(p.Date, q.Bucket)
+		if len(summary.Buckets) == 0 || !summary.Buckets[len(summary.Buckets)-1].Start.Equal(start) {
+			flush()
+			summary.Buckets = append(summary.Buckets, domain.SpendingBucket{Start: start})
+			groups = make(map[string]*domain.SpendingGroup)
+			unitAmounts = make(map[string]*unitAmountAccumulator)
+		}
+
+		bucket := &summary.Buckets[len(summary.Buckets)-1]
+		bucket.Total += amount
+		bucket.Count++
+		summary.Total += amount
+		summary.Count++
+
+		for _, key := range dimensionKeys(p, q.Dimension) {
+			group, ok := groups[key]
+			if !ok {
+				group = &domain.SpendingGroup{Key: key, Label: dimensionLabel(p, key, q.Dimension)}
+				groups[key] = group
+			}
+			group.Total += amount
+			group.Count++
+			if q.Dimension == SpendingByProduct {
+				if _, ok := unitAmounts[key]; !ok {
+					unitAmounts[key] = &unitAmountAccumulator{}
+				}
+				unitAmounts[key].add(p)
+			}
+		}
+	}
+	flush()
+
+	return summary, nil
+}
@@ -0,0 +1,190 @@
+package stores
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+	"yuki_buy_log/internal/database"
+	"yuki_buy_log/internal/domain"
+)
+
+// ErrTokenReused возвращается при повторном использовании токена обновления, его семейство отзывается
+var ErrTokenReused = errors.New("refresh token reused")
+
+// TokenStore хранит токены обновления в БД, а в памяти - только список отозванных access-токенов,
+// который проверяется на каждом запросе
+type TokenStore struct {
+	// Время истечения отозванных access-токенов по jti
+	revoked map[string]time.Time
+	mutex   sync.RWMutex
+	db      database.DatabaseManager
+}
+
+var (
+	tokenStoreInstance *TokenStore
+	tokenStoreLock     sync.Once
+)
+
+func GetTokenStore() *TokenStore {
+	tokenStoreLock.Do(func() {
+		var db, _ = database.GetDBManager()
+		tokens, err := db.GetAllRevokedTokens()
+		if err != nil {
+			tokens = []domain.RevokedToken{}
+		}
+
+		tokenStoreInstance = &TokenStore{
+			revoked: make(map[string]time.Time),
+			db:      *db,
+		}
+		tokenStoreInstance.putRevoked(tokens)
+	})
+	return tokenStoreInstance
+}
+
+// putRevoked добавляет отозванные токены в локальный стор
+func (s *TokenStore) putRevoked(tokens []domain.RevokedToken) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, token := range tokens {
+		s.revoked[token.Jti] = token.ExpiresAt
+	}
+}
+
+// IsRevoked проверяет, отозван ли access-токен
+func (s *TokenStore) IsRevoked(jti string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, ok := s.revoked[jti]
+	return ok
+}
+
+// CreateTokenFamily создает семейство токенов обновления для нового входа пользователя
+func (s *TokenStore) CreateTokenFamily(family *domain.TokenFamily) error {
+	family.CreatedAt = time.Now().UTC()
+	family.LastSeenAt = family.CreatedAt
+	return s.db.CreateTokenFamily(family)
+}
+
+// AddRefreshToken сохраняет новый токен обновления семейства
+func (s *TokenStore) AddRefreshToken(token *domain.RefreshToken) error {
+	return s.db.CreateRefreshToken(token)
+}
+
+// UseRefreshToken помечает токен обновления использованным и возвращает его, в сессии запоминается устройство
+// и IP, с которых пришел запрос. Возвращает ErrNotFound, если токен не найден, истек или отозван,
+// и ErrTokenReused, если токен уже был использован: в этом случае отзывается все семейство,
+// потому что токен, скорее всего, украден
+func (s *TokenStore) UseRefreshToken(tokenHash string, userAgent string, ip string) (*domain.RefreshToken, error) {
+	now := time.Now().UTC()
+	token, err := s.db.UseRefreshToken(tokenHash, userAgent, ip, now)
+	if err == nil {
+		return &token, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	familyId, err := s.db.GetReusedTokenFamily(tokenHash, now)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if err := s.RevokeTokenFamily(familyId); err != nil {
+		return nil, err
+	}
+	return nil, ErrTokenReused
+}
+
+// RevokeTokenFamily отзывает семейство токенов обновления вместе с выданными в нем access-токенами
+func (s *TokenStore) RevokeTokenFamily(familyId domain.TokenFamilyId) error {
+	// Отзываем в БД
+	revoked, err := s.db.RevokeTokenFamily(familyId, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	// Обновляем локальный стор
+	s.putRevoked(revoked)
+	return nil
+}
+
+// GetSessions возвращает действующие сессии пользователя, последние использованные - первыми
+func (s *TokenStore) GetSessions(userId domain.UserId) ([]domain.TokenFamily, error) {
+	return s.db.GetActiveTokenFamilies(userId, time.Now().UTC())
+}
+
+// RevokeSession отзывает сессию пользователя. Возвращает ErrNotFound, если сессия не найдена,
+// уже отозвана или принадлежит другому пользователю
+func (s *TokenStore) RevokeSession(userId domain.UserId, familyId domain.TokenFamilyId) error {
+	family, err := s.db.GetTokenFamily(familyId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if family.UserId != userId || family.RevokedAt != nil {
+		return ErrNotFound
+	}
+	return s.RevokeTokenFamily(familyId)
+}
+
+// RevokeOtherSessions отзывает все действующие сессии пользователя, кроме текущей. Возвращает количество
+// отозванных сессий
+func (s *TokenStore) RevokeOtherSessions(userId domain.UserId, currentFamilyId domain.TokenFamilyId) (int, error) {
+	families, err := s.GetSessions(userId)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, family := range families {
+		if family.Id == currentFamilyId {
+			continue
+		}
+		if err := s.RevokeTokenFamily(family.Id); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// RevokeToken отзывает один access-токен до истечения его срока
+func (s *TokenStore) RevokeToken(token domain.RevokedToken) error {
+	// Отзываем в БД
+	err := s.db.RevokeToken(token)
+	if err != nil {
+		return err
+	}
+
+	// Обновляем локальный стор
+	s.putRevoked([]domain.RevokedToken{token})
+	return nil
+}
+
+// DeleteExpiredTokens удаляет истекшие токены обновления и отозванные access-токены, срок которых уже истек
+func (s *TokenStore) DeleteExpiredTokens(now time.Time) (int64, error) {
+	// Удаляем из БД
+	rowsAffected, err := s.db.DeleteExpiredTokens(now)
+	if err != nil {
+		return 0, err
+	}
+
+	// Удаляем из локального стора
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for jti, expiresAt := range s.revoked {
+		if !expiresAt.After(now) {
+			delete(s.revoked, jti)
+		}
+	}
+	return rowsAffected, nil
+}
@@ -0,0 +1,151 @@
+package stores
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+	"yuki_buy_log/internal/auth"
+	"yuki_buy_log/internal/database"
+	"yuki_buy_log/internal/domain"
+)
+
+// После maxTotpAttempts неверных кодов подряд проверка кодов блокируется на totpLockDuration
+const (
+	maxTotpAttempts  = 5
+	totpLockDuration = 15 * time.Minute
+)
+
+var (
+	ErrInvalidCode       = errors.New("invalid code")
+	ErrTooManyAttempts   = errors.New("too many attempts")
+	ErrTotpNotEnabled    = errors.New("two-factor authentication is not enabled")
+	ErrTotpAlreadyActive = errors.New("two-factor authentication is already enabled")
+)
+
+// TotpStore хранит настройки двухфакторной аутентификации. Они нужны только при входе и в настройках
+// аккаунта, поэтому не кешируются
+type TotpStore struct {
+	db database.DatabaseManager
+}
+
+var (
+	totpStoreInstance *TotpStore
+	totpStoreLock     sync.Once
+)
+
+func GetTotpStore() *TotpStore {
+	totpStoreLock.Do(func() {
+		var db, _ = database.GetDBManager()
+		totpStoreInstance = &TotpStore{db: *db}
+	})
+	return totpStoreInstance
+}
+
+// GetUserTotp возвращает настройки пользователя или nil, если он не начинал подключение
+func (s *TotpStore) GetUserTotp(userId domain.UserId) (*domain.UserTotp, error) {
+	totp, err := s.db.GetUserTotp(userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// IsEnabled проверяет, включена ли у пользователя двухфакторная аутентификация
+func (s *TotpStore) IsEnabled(userId domain.UserId) (bool, error) {
+	totp, err := s.GetUserTotp(userId)
+	if err != nil {
+		return false, err
+	}
+	return totp != nil && totp.Enabled, nil
+}
+
+// SetupTotp сохраняет новый секрет, который начнет действовать после подтверждения кодом.
+// Повторный вызов до подтверждения заменяет секрет. Возвращает ErrTotpAlreadyActive, если
+// двухфакторная аутентификация уже включена
+func (s *TotpStore) SetupTotp(userId domain.UserId, secret string) error {
+	err := s.db.SetupUserTotp(userId, secret, time.Now().UTC())
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTotpAlreadyActive
+	}
+	return err
+}
+
+// EnableTotp проверяет код от нового секрета и включает двухфакторную аутентификацию с новыми кодами
+// восстановления. Возвращает ErrNotFound, если подключение не начато, ErrTotpAlreadyActive, если
+// она уже включена, и ErrInvalidCode при неверном коде
+func (s *TotpStore) EnableTotp(userId domain.UserId, code string, recoveryCodeHashes []string) error {
+	totp, err := s.GetUserTotp(userId)
+	if err != nil {
+		return err
+	}
+	if totp == nil {
+		return ErrNotFound
+	}
+	if totp.Enabled {
+		return ErrTotpAlreadyActive
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return ErrInvalidCode
+	}
+	err = s.db.EnableUserTotp(userId, step, recoveryCodeHashes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTotpAlreadyActive
+	}
+	return err
+}
+
+// VerifyCode проверяет код из приложения или код восстановления. Каждый код принимается один раз.
+// Возвращает ErrTotpNotEnabled, ErrTooManyAttempts, если проверка заблокирована после ошибок,
+// и ErrInvalidCode при неверном коде
+func (s *TotpStore) VerifyCode(userId domain.UserId, code string) error {
+	totp, err := s.GetUserTotp(userId)
+	if err != nil {
+		return err
+	}
+	if totp == nil || !totp.Enabled {
+		return ErrTotpNotEnabled
+	}
+	now := time.Now().UTC()
+	if totp.LockedUntil != nil && totp.LockedUntil.After(now) {
+		return ErrTooManyAttempts
+	}
+
+	if step, ok := auth.ValidateTOTP(totp.Secret, code, now); ok {
+		err = s.db.UseTotpStep(userId, step)
+	} else {
+		err = s.db.UseRecoveryCode(userId, auth.HashRecoveryCode(code), now)
+	}
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	// Неверный или уже использованный код
+	if _, err := s.db.AddTotpFailedAttempt(userId, maxTotpAttempts, now.Add(totpLockDuration)); err != nil {
+		return err
+	}
+	return ErrInvalidCode
+}
+
+// DisableTotp выключает двухфакторную аутентификацию и удаляет коды восстановления
+func (s *TotpStore) DisableTotp(userId domain.UserId) error {
+	return s.db.DeleteUserTotp(userId)
+}
+
+// ReplaceRecoveryCodes заменяет коды восстановления новыми, старые перестают действовать
+func (s *TotpStore) ReplaceRecoveryCodes(userId domain.UserId, recoveryCodeHashes []string) error {
+	return s.db.ReplaceRecoveryCodes(userId, recoveryCodeHashes)
+}
+
+// CountRecoveryCodes возвращает количество неиспользованных кодов восстановления
+func (s *TotpStore) CountRecoveryCodes(userId domain.UserId) (int, error) {
+	return s.db.CountUnusedRecoveryCodes(userId)
+}
@@ -0,0 +1,62 @@
+package stores
+
+import (
+	"math"
+	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/units"
+)
+
+// normalizeVolume заполняет нормализованный объем продукта по строке Volume
+func normalizeVolume(product *domain.Product) {
+	product.UnitAmount, product.Unit = 0, ""
+	if volume, ok := units.ParseVolume(product.Volume); ok {
+		product.UnitAmount, product.Unit = volume.Amount, volume.Unit
+	}
+}
+
+// pricePerUnit возвращает цену покупки за литр, килограмм или штуку, округленную до сотых.
+// Возвращает 0, если количество штучное, а объем продукта не распознан
+func pricePerUnit(p domain.Purchase, product *domain.Product) (float64, string) {
+	if isWeighed(p) {
+		return float64(p.Price), p.QuantityUnit
+	}
+	if product == nil || product.UnitAmount <= 0 {
+		return 0, ""
+	}
+	return math.Round(float64(p.Price)/product.UnitAmount*100) / 100, product.Unit
+}
+
+// purchaseAmount возвращает стоимость покупки: цена × количество, округленная до целого
+func purchaseAmount(p domain.Purchase) int64 {
+	return int64(math.Round(float64(p.Price) * p.Quantity))
+}
+
+// isWeighed проверяет, что количество покупки указано в литрах или килограммах,
+// тогда цена покупки уже является ценой за единицу
+func isWeighed(p domain.Purchase) bool {
+	return p.QuantityUnit == units.Litre || p.QuantityUnit == units.Kilogram
+}
+
+// purchaseUnitAmount возвращает купленный объем в нормализованных единицах.
+// Возвращает 0, если объем неизвестен
+func purchaseUnitAmount(p domain.Purchase, product *domain.Product) (float64, string) {
+	if isWeighed(p) {
+		return p.Quantity, p.QuantityUnit
+	}
+	if product == nil || product.UnitAmount <= 0 {
+		return 0, ""
+	}
+	return product.UnitAmount * p.Quantity, product.Unit
+}
+
+// FillUnitPrices заполняет цену за единицу в покупках по объему их продуктов
+func (s *ProductStore) FillUnitPrices(purchases []domain.Purchase) {
+	for i := range purchases {
+		s.FillUnitPrice(&purchases[i])
+	}
+}
+
+// FillUnitPrice заполняет цену за единицу в покупке по объему ее продукта
+func (s *ProductStore) FillUnitPrice(purchase *domain.Purchase) {
+	purchase.PricePerUnit, purchase.Unit = pricePerUnit(*purchase, s.GetProductById(purchase.ProductId))
+}
@@ -1,7 +1,9 @@
 package stores
 
 import (
+	"errors"
 	"sync"
+	"yuki_buy_log/internal/currency"
 	"yuki_buy_log/internal/database"
 	"yuki_buy_log/internal/domain"
 )
@@ -69,6 +71,10 @@ func (s *UserStore) GetUserByLogin(login string) *domain.User {
 
 // AddUser добавляет нового пользователя
 func (s *UserStore) AddUser(user *domain.User) error {
+	if user.Currency == "" {
+		user.Currency = currency.Default
+	}
+
 	// Обновляем локальный стор
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -83,35 +89,60 @@ func (s *UserStore) AddUser(user *domain.User) error {
 	return nil
 }
 
-// UpdateUser обновляет данные пользователя
+// UpdateUser обновляет данные пользователя. Новый логин также проставляется в участников группы и инвайты.
+// Если логин уже занят, возвращает ErrAlreadyExists
 func (s *UserStore) UpdateUser(user *domain.User) error {
 	// Обновляем в БД
 	err := s.db.UpdateUser(user)
 	if err != nil {
+		if errors.Is(err, database.ErrUniqueViolation) {
+			return ErrAlreadyExists
+		}
 		return err
 	}
 
 	// Обновляем локальный стор
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
+	oldLogin := s.data[user.Id].Login
 	s.data[user.Id] = *user
+	s.mutex.Unlock()
+
+	// Логин хранится и в других сторах
+	if user.Login != oldLogin {
+		GetGroupStore().renameMember(user.Id, user.Login)
+		GetInviteStore().renameUser(user.Id, user.Login)
+	}
 	return nil
 }
 
-// DeleteUser удаляет пользователя
-func (s *UserStore) DeleteUser(userId domain.UserId) error {
-	// Удаляем из БД
-	err := s.db.DeleteUser(userId)
+// DeleteUser удаляет пользователя вместе с его данными: пользователь выходит из группы, удаляются инвайты,
+// ссылки-приглашения и чеки. Покупки удаляются или, при anonymize, остаются в БД без пользователя.
+// Продукты, которые используются в покупках других пользователей, передаются им
+func (s *UserStore) DeleteUser(userId domain.UserId, anonymize bool) error {
+	// Удаляем из БД, участие в группе удаляется в той же транзакции
+	handedOver, err := s.db.DeleteUser(userId, anonymize)
 	if err != nil {
 		return err
 	}
 
-	// Удаляем из локального стора
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	// Удаляем из локальных сторов
+	GetPurchaseStore().deleteUserPurchases(userId, anonymize)
+	GetReceiptStore().deleteUserReceipts(userId)
+	GetProductStore().deleteUserProducts(userId, handedOver)
+	GetInviteStore().deleteUserInvites(userId)
+	GetInviteLinkStore().deleteUserInviteLinks(userId)
+	GetPersonalAccessTokenStore().deleteUserTokens(userId)
 
+	s.mutex.Lock()
 	delete(s.data, userId)
+	s.mutex.Unlock()
+
+	// Группа обновляется только после удаления пользователя: если осталось меньше двух участников,
+	// она распадается, иначе участники перенумеровываются
+	groupStore := GetGroupStore()
+	if groupStore.GetGroupIdByUserId(userId) != nil {
+		return groupStore.DeleteUserFromGroup(userId)
+	}
 	return nil
 }
 
@@ -0,0 +1,24 @@
+package tasks
+
+import (
+	"log"
+	"time"
+	"yuki_buy_log/internal/stores"
+)
+
+func CleanupExpiredInviteLinks() func() {
+	return func() {
+		now := time.Now().UTC()
+
+		inviteLinkStore := stores.GetInviteLinkStore()
+		rowsAffected, err := inviteLinkStore.DeleteExpiredInviteLinks(now)
+		if err != nil {
+			log.Printf("Failed to cleanup expired invite links: %v", err)
+			return
+		}
+
+		if rowsAffected > 0 {
+			log.Printf("Cleaned up %d expired or used invite link(s)", rowsAffected)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package tasks
+
+import (
+	"log"
+	"time"
+	"yuki_buy_log/internal/stores"
+)
+
+func CleanupExpiredTokens() func() {
+	return func() {
+		now := time.Now().UTC()
+
+		tokenStore := stores.GetTokenStore()
+		rowsAffected, err := tokenStore.DeleteExpiredTokens(now)
+		if err != nil {
+			log.Printf("Failed to cleanup expired tokens: %v", err)
+			return
+		}
+
+		if rowsAffected > 0 {
+			log.Printf("Cleaned up %d expired token record(s)", rowsAffected)
+		}
+	}
+}
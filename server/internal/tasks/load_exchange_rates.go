@@ -0,0 +1,34 @@
+package tasks
+
+import (
+	"log"
+	"os"
+	"yuki_buy_log/internal/currency"
+	"yuki_buy_log/internal/stores"
+)
+
+// LoadExchangeRates загружает курсы валют из CSV-файла. Файл можно дополнять новыми курсами,
+// уже загруженные курсы перезаписываются
+func LoadExchangeRates(path string) func() {
+	return func() {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Printf("Failed to open exchange rates file %s: %v", path, err)
+			return
+		}
+		defer file.Close()
+
+		rates, err := currency.ParseRatesCSV(file)
+		if err != nil {
+			log.Printf("Failed to parse exchange rates file %s: %v", path, err)
+			return
+		}
+
+		if err := stores.GetExchangeRateStore().LoadRates(rates); err != nil {
+			log.Printf("Failed to load exchange rates: %v", err)
+			return
+		}
+
+		log.Printf("Loaded %d exchange rate(s) from %s", len(rates), path)
+	}
+}
@@ -0,0 +1,112 @@
+package units
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"yuki_buy_log/internal/matching"
+)
+
+// Normalized units of a product volume
+const (
+	Litre    = "l"
+	Kilogram = "kg"
+	Piece    = "pcs"
+)
+
+// unitScales maps a transliterated unit to its normalized unit and the scale to it
+var unitScales = map[string]struct {
+	unit  string
+	scale float64
+}{
+	"l":     {Litre, 1},
+	"lt":    {Litre, 1},
+	"ltr":   {Litre, 1},
+	"dl":    {Litre, 0.1},
+	"cl":    {Litre, 0.01},
+	"ml":    {Litre, 0.001},
+	"kg":    {Kilogram, 1},
+	"g":     {Kilogram, 0.001},
+	"gr":    {Kilogram, 0.001},
+	"mg":    {Kilogram, 0.000001},
+	"pc":    {Piece, 1},
+	"pcs":   {Piece, 1},
+	"sht":   {Piece, 1},
+	"shtuk": {Piece, 1},
+}
+
+// Volume is a product amount in a normalized unit: litres, kilograms or pieces.
+type Volume struct {
+	Amount float64
+	Unit   string
+}
+
+var amountPattern = regexp.MustCompile(`^(\d+(?:\.\d+)?|\.\d+)([a-z]*)$`)
+
+// parseAmount parses a single amount with an optional unit, "0.5l" -> 0.5, "l".
+func parseAmount(s string) (amount float64, unit string, ok bool) {
+	match := amountPattern.FindStringSubmatch(s)
+	if match == nil {
+		return 0, "", false
+	}
+	amount, err := strconv.ParseFloat(match[1], 64)
+	if err != nil || amount <= 0 {
+		return 0, "", false
+	}
+	return amount, match[2], true
+}
+
+// ParseVolume parses a free-form product volume like "1л", "500 g", "6x0.33" or "10 шт".
+// Units are metric, in Latin or Cyrillic. A multipack "6x0.33l" is parsed as the total amount 1.98 l;
+// a multipack item without a unit is assumed to be in litres, a single number without a unit is pieces.
+func ParseVolume(s string) (Volume, bool) {
+	s = strings.ToLower(s)
+	// Cyrillic "х" must become the multipack separator before transliteration turns it into "h"
+	s = strings.NewReplacer("х", "x", "×", "x", "*", "x", ",", ".", " ", "").Replace(s)
+	s = strings.TrimSuffix(matching.Transliterate(s), ".")
+
+	count := 1.0
+	if first, second, found := strings.Cut(s, "x"); found {
+		countAmount, countUnit, ok := parseAmount(first)
+		if !ok {
+			return Volume{}, false
+		}
+		itemAmount, itemUnit, ok := parseAmount(second)
+		if !ok {
+			return Volume{}, false
+		}
+		// "0.5lx6": the count can follow the item
+		if countUnit != "" && itemUnit == "" {
+			countAmount, itemAmount, itemUnit = itemAmount, countAmount, countUnit
+		} else if countUnit != "" {
+			return Volume{}, false
+		}
+		if countAmount != math.Trunc(countAmount) {
+			return Volume{}, false
+		}
+		if itemUnit == "" {
+			itemUnit = Litre
+		}
+		count, s = countAmount, strconv.FormatFloat(itemAmount, 'f', -1, 64)+itemUnit
+	}
+
+	amount, unit, ok := parseAmount(s)
+	if !ok {
+		return Volume{}, false
+	}
+	if unit == "" {
+		unit = Piece
+	}
+	scale, ok := unitScales[unit]
+	if !ok {
+		return Volume{}, false
+	}
+
+	// Rounding removes float noise like 6 * 0.33 = 1.9800000000000002
+	total := math.Round(count*amount*scale.scale*1e6) / 1e6
+	if total == 0 {
+		return Volume{}, false
+	}
+	return Volume{Amount: total, Unit: scale.unit}, true
+}
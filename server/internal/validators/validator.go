@@ -2,14 +2,25 @@ package validators
 
 import (
 	"errors"
+	"math"
 	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"yuki_buy_log/internal/currency"
 	"yuki_buy_log/internal/domain"
+	"yuki_buy_log/internal/units"
 )
 
 var (
 	// reValidName allows Unicode letters, digits, and spaces
 	reValidName = regexp.MustCompile(`^[\p{L}\p{N}\s]+$`)
+
+	// promoTypes lists the kinds of promotions a discounted purchase can have:
+	// loyalty card price, percentage discount, multi-buy ("2 for 1"), coupon or other
+	promoTypes = []string{"loyalty_card", "percentage", "multi_buy", "coupon", "other"}
 )
 
 // ValidateProduct validates a product.
@@ -39,12 +50,34 @@ func ValidatePurchase(p *domain.Purchase) error {
 	if p.ProductId <= 0 {
 		return errors.New("invalid product_id")
 	}
-	if p.Quantity < 1 || p.Quantity > 100000 {
-		return errors.New("invalid quantity")
+	switch p.QuantityUnit {
+	case units.Piece:
+		if p.Quantity < 1 || p.Quantity > 100000 || p.Quantity != math.Trunc(p.Quantity) {
+			return errors.New("invalid quantity")
+		}
+	case units.Kilogram, units.Litre:
+		// Весовые товары взвешиваются с точностью до грамма
+		scaled := p.Quantity * 1000
+		if p.Quantity <= 0 || p.Quantity > 100000 || math.Abs(scaled-math.Round(scaled)) > 1e-6 {
+			return errors.New("invalid quantity")
+		}
+	default:
+		return errors.New("invalid quantity_unit")
 	}
 	if p.Price < 1 || p.Price > 100000000 {
 		return errors.New("invalid price")
 	}
+	if !currency.IsValid(p.Currency) {
+		return errors.New("invalid currency")
+	}
+	if p.RegularPrice != 0 || p.Discount != 0 || p.PromoType != "" {
+		if p.Discount < 1 || p.RegularPrice > 100000000 || p.RegularPrice-p.Discount != p.Price {
+			return errors.New("invalid discount")
+		}
+		if !slices.Contains(promoTypes, p.PromoType) {
+			return errors.New("invalid promo_type")
+		}
+	}
 	if p.Date.IsZero() {
 		return errors.New("invalid date")
 	}
@@ -61,3 +94,64 @@ func ValidatePurchase(p *domain.Purchase) error {
 	}
 	return nil
 }
+
+// ValidateReceipt validates a receipt.
+func ValidateReceipt(r *domain.Receipt) error {
+	if r.Date.IsZero() {
+		return errors.New("invalid date")
+	}
+	if len(r.Store) == 0 || len(r.Store) > 30 || !reValidName.MatchString(r.Store) {
+		return errors.New("invalid store")
+	}
+	if len(r.CommonTags) > 10 {
+		return errors.New("too many common tags")
+	}
+	for _, tag := range r.CommonTags {
+		if len(tag) == 0 || len(tag) > 20 || !reValidName.MatchString(tag) {
+			return errors.New("invalid common tag")
+		}
+	}
+	return nil
+}
+
+// ValidateLogin validates a user login.
+func ValidateLogin(login string) error {
+	if strings.TrimSpace(login) != login || login == "" || utf8.RuneCountInString(login) > 50 {
+		return errors.New("invalid login")
+	}
+	return nil
+}
+
+// ValidatePassword validates a new password. bcrypt uses at most 72 bytes of a password.
+func ValidatePassword(password string) error {
+	if password == "" || len(password) > 72 {
+		return errors.New("invalid password")
+	}
+	return nil
+}
+
+// ValidatePersonalAccessToken validates the name and the scope of a personal access token.
+func ValidatePersonalAccessToken(t *domain.PersonalAccessToken) error {
+	if strings.TrimSpace(t.Name) == "" || utf8.RuneCountInString(t.Name) > 100 {
+		return errors.New("invalid name")
+	}
+	switch t.Scope {
+	case domain.ScopeRead, domain.ScopePurchases, domain.ScopeAdmin:
+		return nil
+	default:
+		return errors.New("invalid scope")
+	}
+}
+
+// ValidateInviteLink validates an invite link.
+func ValidateInviteLink(l *domain.InviteLink) error {
+	// В группе максимум 5 участников, поэтому по ссылке могут вступить максимум 4 человека
+	if l.MaxUses < 1 || l.MaxUses > 4 {
+		return errors.New("invalid max_uses")
+	}
+	now := time.Now()
+	if !l.ExpiresAt.After(now) || l.ExpiresAt.After(now.Add(7*24*time.Hour)) {
+		return errors.New("invalid expiration")
+	}
+	return nil
+}